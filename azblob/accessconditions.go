@@ -9,7 +9,7 @@ import (
 func storerOptionConditions(options *StorerOptions) (azStorageBlob.BlobAccessConditions, error) {
 
 	var blobAccessConditions azStorageBlob.BlobAccessConditions
-	if options.leaseID == "" && options.etagCondition == EtagNotUsed {
+	if options.leaseID == "" && options.etagCondition == EtagNotUsed && options.sinceCondition == IfConditionNotUsed {
 		return blobAccessConditions, nil
 	}
 	if options.etag == "" && options.etagCondition != EtagNotUsed {
@@ -0,0 +1,203 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// AppendBlockMaxSize is the largest block Append accepts
+const AppendBlockMaxSize = 4 * 1024 * 1024
+
+// Appender is the interface for append blobs. An append blob can only be
+// changed by adding blocks to the end, which makes it suitable for logs.
+// Page blobs are not supported.
+type Appender interface {
+	Append(
+		ctx context.Context,
+		identity string,
+		data []byte,
+		opts ...Option,
+	) (*WriteResponse, error)
+	CommittedLength(
+		ctx context.Context,
+		identity string,
+		opts ...Option,
+	) (*CommittedLengthResponse, error)
+}
+
+// CommittedLengthResponse describes the committed content of an append blob
+type CommittedLengthResponse struct {
+	Length              int64
+	CommittedBlockCount int32
+
+	ETag         *string
+	LastModified *time.Time
+}
+
+// Append adds data as a single block to the end of the append blob. A block
+// larger than AppendBlockMaxSize is rejected with status 400.
+//
+// Options:
+//
+//	WithAppendPosition() - only append if the committed length is this value
+//	WithAppendMaxSize() - only append if the result is no larger than this
+//	WithCreateIfAbsent() - create the append blob, with WithMetadata() and
+//	                       WithTags(), if it does not exist
+//	WithLeaseID() - required if the blob is leased
+//	WithEtagMatch() etc. - the usual access conditions
+//
+// If an append condition is not met the error has the storage code
+// AppendPositionConditionNotMet or MaxBlobSizeConditionNotMet, see
+// Error.IsAppendConditionNotMet(). Appending to a blob that is not an append
+// blob fails with InvalidBlobType.
+func (azp *Storer) Append(
+	ctx context.Context,
+	identity string,
+	data []byte,
+	opts ...Option,
+) (*WriteResponse, error) {
+	logger.Sugar.Debugf("Append %d bytes to %s", len(data), identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkAppendBlock(data); err != nil {
+		return nil, err
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	appendBlobClient, err := azp.containerClient.NewAppendBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	appendBlock := func() (azStorageBlob.AppendBlobAppendBlockResponse, error) {
+		return appendBlobClient.AppendBlock(
+			ctx,
+			NewBytesReaderCloser(data),
+			&azStorageBlob.AppendBlobAppendBlockOptions{
+				BlobAccessConditions: &blobAccessConditions,
+				AppendPositionAccessConditions: &azStorageBlob.AppendPositionAccessConditions{
+					AppendPosition: options.appendPosition,
+					MaxSize:        options.appendMaxSize,
+				},
+			},
+		)
+	}
+
+	r, err := appendBlock()
+	if err != nil && options.createIfAbsent &&
+		ErrorFromError(err).StorageErrorCode() == string(azStorageBlob.StorageErrorCodeBlobNotFound) {
+
+		logger.Sugar.Infof("Create AppendBlob %s", identity)
+		ifNoneMatch := "*"
+		_, err = appendBlobClient.Create(ctx, &azStorageBlob.AppendBlobCreateOptions{
+			BlobAccessConditions: &azStorageBlob.BlobAccessConditions{
+				ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{
+					IfNoneMatch: &ifNoneMatch,
+				},
+			},
+			Metadata: options.metadata,
+			TagsMap:  options.tags,
+		})
+		// someone else may have created it first, which is fine
+		if err == nil || ErrorFromError(err).StorageErrorCode() == string(azStorageBlob.StorageErrorCodeBlobAlreadyExists) {
+			r, err = appendBlock()
+		}
+	}
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return appendBlockWriteResponse(r)
+}
+
+// checkAppendBlock returns an error if data is too large for a single block
+func checkAppendBlock(data []byte) error {
+	if len(data) > AppendBlockMaxSize {
+		return NewStatusError(
+			fmt.Sprintf("append block of %d bytes is larger than %d", len(data), AppendBlockMaxSize),
+			http.StatusBadRequest)
+	}
+	return nil
+}
+
+func appendBlockWriteResponse(r azStorageBlob.AppendBlobAppendBlockResponse) (*WriteResponse, error) {
+	w := WriteResponse{
+		ETag:                r.ETag,
+		LastModified:        r.LastModified,
+		CommittedBlockCount: r.BlobCommittedBlockCount,
+	}
+	w.Status = r.RawResponse.Status
+	w.StatusCode = r.RawResponse.StatusCode
+	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
+	if ok && len(value) > 0 {
+		w.XMsErrorCode = value[0]
+	}
+	if r.BlobAppendOffset != nil {
+		offset, err := strconv.ParseInt(*r.BlobAppendOffset, 10, 64)
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		w.AppendOffset = &offset
+	}
+	return &w, nil
+}
+
+// CommittedLength returns the committed length of the append blob. The length
+// can be used with WithAppendPosition() so that an Append only succeeds if
+// nothing else has been appended in the mean time.
+func (azp *Storer) CommittedLength(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*CommittedLengthResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	if azp.containerClient == nil {
+		return nil, errors.New("no container client available for reader")
+	}
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	props, err := blobClient.GetProperties(ctx, &azStorageBlob.BlobGetPropertiesOptions{
+		BlobAccessConditions: &blobAccessConditions,
+	})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if props.BlobType == nil || *props.BlobType != azStorageBlob.BlobTypeAppendBlob {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeInvalidBlobType, http.StatusConflict,
+			fmt.Sprintf("blob %s is not an append blob", identity))
+	}
+	r := &CommittedLengthResponse{
+		ETag:         props.ETag,
+		LastModified: props.LastModified,
+	}
+	if props.ContentLength != nil {
+		r.Length = *props.ContentLength
+	}
+	if props.BlobCommittedBlockCount != nil {
+		r.CommittedBlockCount = *props.BlobCommittedBlockCount
+	}
+	return r, nil
+}
@@ -0,0 +1,95 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorerAppend(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Append(ctx, "log", []byte("first"))
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			wr, err := store.Append(ctx, "log", []byte("first"),
+				WithCreateIfAbsent(), WithMetadata(map[string]string{"kind": "log"}))
+			require.NoError(t, err)
+			require.NotNil(t, wr.AppendOffset)
+			assert.Equal(t, int64(0), *wr.AppendOffset)
+
+			committed, err := store.CommittedLength(ctx, "log")
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), committed.Length)
+			assert.Equal(t, int32(1), committed.CommittedBlockCount)
+			assert.Equal(t, *wr.ETag, *committed.ETag)
+
+			wr, err = store.Append(ctx, "log", []byte("second"), WithAppendPosition(committed.Length))
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), *wr.AppendOffset)
+			assert.Equal(t, int32(2), *wr.CommittedBlockCount)
+
+			// someone else appended since we read the length
+			_, err = store.Append(ctx, "log", []byte("third"), WithAppendPosition(committed.Length))
+			assert.True(t, ErrorFromError(err).IsAppendConditionNotMet())
+
+			_, err = store.Append(ctx, "log", []byte("third"), WithAppendMaxSize(12))
+			assert.True(t, ErrorFromError(err).IsAppendConditionNotMet())
+
+			leaseID, err := store.AcquireLease(ctx, "log", -1)
+			require.NoError(t, err)
+			_, err = store.Append(ctx, "log", []byte("third"))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+			_, err = store.Append(ctx, "log", []byte("third"), WithLeaseID(leaseID))
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "log", WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("firstsecondthird"), readAll(t, rr))
+			assert.Equal(t, "log", rr.Metadata["Kind"])
+
+			_, err = store.Put(ctx, "block", NewBytesReaderCloser([]byte("block")))
+			require.NoError(t, err)
+			_, err = store.Append(ctx, "block", []byte("more"))
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+			_, err = store.CommittedLength(ctx, "block")
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			_, err = store.Append(ctx, "log", make([]byte, AppendBlockMaxSize+1))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+			committed, err = store.CommittedLength(ctx, "log")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len("firstsecondthird")), committed.Length)
+		})
+	}
+}
+
+func TestStorerAppendBlockMaxSize(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+
+	// an oversized block is rejected without sending it
+	_, err := azp.Append(context.Background(), "log", make([]byte, AppendBlockMaxSize+1), WithCreateIfAbsent())
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	assert.Equal(t, int32(0), requests.Load())
+}
@@ -0,0 +1,473 @@
+package azblob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	// BatchMaxSize is the maximum number of sub requests in a single batch
+	BatchMaxSize = 256
+
+	defaultBatchConcurrency = 4  // batches in flight
+	defaultManyConcurrency  = 16 // single blob requests in flight
+
+	// the batch endpoint needs the service version set explicitly, this is the
+	// version the sdk uses
+	batchServiceVersion = "2020-10-02"
+
+	// restTryTimeout limits each try of a request that does not go through
+	// an sdk client
+	restTryTimeout = time.Minute
+)
+
+// restPipeline sends the requests that do not go through an sdk client with
+// the retries of the sdk clients. Requests that fail with status 408, 429 or
+// a 5xx are retried with a backoff, honouring Retry-After.
+var restPipeline = runtime.NewPipeline(
+	"azblob", "v0.4.1", runtime.PipelineOptions{},
+	&policy.ClientOptions{Retry: policy.RetryOptions{TryTimeout: restTryTimeout}})
+
+// BatchResult is the outcome of a batch operation for one blob
+type BatchResult struct {
+	Identity string
+	// Err is nil on success, otherwise an *Error with the status and storage
+	// error code for the blob
+	Err error
+}
+
+// Batcher is the interface for operations on many blobs at once
+type Batcher interface {
+	DeleteMany(ctx context.Context, identities []string, opts ...Option) ([]BatchResult, error)
+	SetTagsMany(ctx context.Context, identities []string, tags map[string]string, opts ...Option) ([]BatchResult, error)
+	SetTierMany(ctx context.Context, identities []string, tier AccessTier, opts ...Option) ([]BatchResult, error)
+}
+
+func newBatchResults(identities []string) []BatchResult {
+	results := make([]BatchResult, len(identities))
+	for i, identity := range identities {
+		results[i].Identity = identity
+	}
+	return results
+}
+
+// batchItemError returns err as an *Error, or an untyped nil
+func batchItemError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrorFromError(err)
+}
+
+// runConcurrently calls fn for each of n items with at most concurrency calls
+// in flight. Once ctx is done no more calls are started and the remaining
+// items are reported to skipped.
+func runConcurrently(ctx context.Context, n int, concurrency int, fn func(i int), skipped func(i int)) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			for ; i < n; i++ {
+				skipped(i)
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// DeleteMany deletes the blobs, using the blob batch endpoint with up to
+// BatchMaxSize deletes per request. As for Delete, a blob that does not exist
+// is not an error. If the storer has no shared key the blobs are deleted by
+// concurrent single requests instead.
+//
+// There is a result for each identity, in the same order, and the error is
+// only non nil if ctx is done before all the blobs were attempted. The failure
+// of a whole batch is reported against each of the blobs in it.
+//
+// Options:
+//
+//	WithConcurrency() - the maximum number of batches in flight, default 4
+//	WithDryRun() - log the blobs that would be deleted, without deleting them
+//	WithDeleteSnapshots() - the snapshots policy for every blob
+func (azp *Storer) DeleteMany(ctx context.Context, identities []string, opts ...Option) ([]BatchResult, error) {
+	logger.Sugar.Infof("DeleteMany %d blobs", len(identities))
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	results := newBatchResults(identities)
+	if options.dryRun {
+		for _, identity := range identities {
+			logger.Sugar.Infof("DeleteMany dry run: would delete %s", identity)
+		}
+		return results, nil
+	}
+	skipped := func(i int) {
+		results[i].Err = ErrorFromError(ctx.Err())
+	}
+
+	if azp.credential == nil {
+		concurrency := options.concurrency
+		if concurrency <= 0 {
+			concurrency = defaultManyConcurrency
+		}
+		runConcurrently(ctx, len(identities), concurrency, func(i int) {
+			results[i].Err = batchItemError(azp.Delete(ctx, identities[i], WithDeleteSnapshots(options.deleteSnapshots)))
+		}, skipped)
+		return results, ctx.Err()
+	}
+
+	concurrency := options.concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	numBatches := (len(identities) + BatchMaxSize - 1) / BatchMaxSize
+	runConcurrently(ctx, numBatches, concurrency, func(n int) {
+		start := n * BatchMaxSize
+		end := min(start+BatchMaxSize, len(identities))
+		azp.deleteBatch(ctx, results[start:end], options)
+	}, func(n int) {
+		start := n * BatchMaxSize
+		end := min(start+BatchMaxSize, len(identities))
+		for i := start; i < end; i++ {
+			skipped(i)
+		}
+	})
+	return results, ctx.Err()
+}
+
+// deleteBatch deletes the blobs in a single batch request, filling in the
+// results
+func (azp *Storer) deleteBatch(ctx context.Context, results []BatchResult, options *StorerOptions) {
+	deleteSnapshots := deleteSnapshotsOption(options.deleteSnapshots)
+	requests := make([]*http.Request, len(results))
+	for i := range results {
+		req, err := http.NewRequest(http.MethodDelete, azp.containerURL+"/"+escapeBlobPath(results[i].Identity), nil)
+		if err != nil {
+			results[i].Err = ErrorFromError(err)
+			continue
+		}
+		if deleteSnapshots != nil {
+			req.Header.Set("x-ms-delete-snapshots", string(*deleteSnapshots))
+		}
+		requests[i] = req
+	}
+	responses, err := azp.submitBatch(ctx, requests)
+	for i := range results {
+		switch {
+		case results[i].Err != nil:
+		case err != nil:
+			results[i].Err = err
+		case responses[i] == nil:
+			results[i].Err = NewStatusError(
+				fmt.Sprintf("no response in batch for delete of %s", results[i].Identity), http.StatusInternalServerError)
+		case responses[i].StatusCode == http.StatusAccepted, responses[i].StatusCode == http.StatusNotFound:
+		default:
+			results[i].Err = restResponseError(responses[i], "delete "+results[i].Identity)
+		}
+	}
+}
+
+// escapeBlobPath escapes the blob name for use in a url path, keeping the /
+// separators as the sdk does
+func escapeBlobPath(identity string) string {
+	segments := strings.Split(identity, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// restResponseError returns the error for a failed request that did not go
+// through the sdk pipeline, including the sub requests of a batch
+func restResponseError(resp *http.Response, what string) *Error {
+	code := resp.Header.Get(xMsErrorCodeHeader)
+	return newStorageCodeError(
+		azStorageBlob.StorageErrorCode(code), resp.StatusCode,
+		fmt.Sprintf("%s: %s %s", what, resp.Status, code))
+}
+
+// newRESTRequest returns a request with body for restPipeline. The body is
+// sent again if the request is retried.
+func newRESTRequest(
+	ctx context.Context, method string, url string, body []byte, contentType string,
+) (*policy.Request, error) {
+	req, err := runtime.NewRequest(ctx, method, url)
+	if err != nil {
+		return nil, err
+	}
+	if err = req.SetBody(streaming.NopCloser(bytes.NewReader(body)), contentType); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// submitBatch sends the requests, which have no body, as a single batch
+// request for the container. The responses are in the same order as the
+// requests, and are nil for any request the service did not respond to.
+func (azp *Storer) submitBatch(ctx context.Context, requests []*http.Request) ([]*http.Response, error) {
+	boundary := "batch_" + uuid.NewString()
+	body, err := azp.batchBody(requests, boundary)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	req, err := newRESTRequest(ctx, http.MethodPost, azp.containerURL+"?restype=container&comp=batch",
+		body, "multipart/mixed; boundary="+boundary)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	req.Raw().Header.Set("x-ms-version", batchServiceVersion)
+	// the signature is valid for 15 minutes, which covers the retries
+	if err = signSharedKey(azp.credential, req.Raw()); err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	resp, err := restPipeline.Do(req)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, restResponseError(resp, "batch")
+	}
+	return parseBatchResponse(resp, len(requests))
+}
+
+// batchBody encodes the requests as the parts of a multipart/mixed body, each
+// signed in its own right
+func (azp *Storer) batchBody(requests []*http.Request, boundary string) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+	for i, sub := range requests {
+		if sub == nil {
+			continue
+		}
+		if err := signSharedKey(azp.credential, sub); err != nil {
+			return nil, err
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/http"},
+			"Content-Transfer-Encoding": {"binary"},
+			"Content-ID":                {strconv.Itoa(i)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "%s %s HTTP/1.1\r\n", sub.Method, sub.URL.RequestURI())
+		if err = sub.Header.Write(part); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "Content-Length: 0\r\n\r\n")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// parseBatchResponse returns the sub responses, ordered by their Content-ID
+func parseBatchResponse(resp *http.Response, n int) ([]*http.Response, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, NewStatusError(
+			fmt.Sprintf("unexpected batch response content type %s", resp.Header.Get("Content-Type")),
+			http.StatusInternalServerError)
+	}
+	responses := make([]*http.Response, n)
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return responses, nil
+		}
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		i, err := strconv.Atoi(part.Header.Get("Content-ID"))
+		if err != nil || i < 0 || i >= n {
+			logger.Sugar.Infof("ignoring batch response part with Content-ID '%s'", part.Header.Get("Content-ID"))
+			continue
+		}
+		sub, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		// the sub responses we send have no body worth keeping
+		_, _ = io.Copy(io.Discard, sub.Body)
+		_ = sub.Body.Close()
+		responses[i] = sub
+	}
+}
+
+// signSharedKey authorizes a request that does not go through the sdk
+// pipeline, in the same way as the sdk's shared key policy. See
+// https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func signSharedKey(credential *SharedKeyCredential, req *http.Request) error {
+	if req.Header.Get("x-ms-date") == "" {
+		req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	resource, err := canonicalizedResource(credential.AccountName(), req.URL)
+	if err != nil {
+		return err
+	}
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalizedHeaders(req.Header),
+		resource,
+	}, "\n")
+	signature, err := credential.ComputeHMACSHA256(stringToSign)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "SharedKey "+credential.AccountName()+":"+signature)
+	return nil
+}
+
+func canonicalizedHeaders(headers http.Header) string {
+	var lines []string
+	for k, v := range headers {
+		name := strings.ToLower(strings.TrimSpace(k))
+		if strings.HasPrefix(name, "x-ms-") {
+			lines = append(lines, name+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func canonicalizedResource(account string, u *url.URL) (string, error) {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	resource := "/" + account + path
+	params, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query params: %w", err)
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := params[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return resource, nil
+}
+
+// SetTagsMany replaces the tags of each of the blobs with tags. The blob batch
+// endpoint does not support setting tags, so the blobs are tagged by
+// concurrent single requests.
+//
+// There is a result for each identity, in the same order, and the error is
+// only non nil if ctx is done before all the blobs were attempted.
+//
+// Options:
+//
+//	WithConcurrency() - the maximum number of requests in flight, default 16
+//	WithWhereTags() - only tag the blobs whose current tags match
+//	WithDryRun() - log the blobs that would be tagged, without tagging them
+func (azp *Storer) SetTagsMany(
+	ctx context.Context, identities []string, tags map[string]string, opts ...Option,
+) ([]BatchResult, error) {
+	logger.Sugar.Infof("SetTagsMany %d blobs", len(identities))
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	results := newBatchResults(identities)
+	if options.dryRun {
+		for _, identity := range identities {
+			logger.Sugar.Infof("SetTagsMany dry run: would tag %s with %v", identity, tags)
+		}
+		return results, nil
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := options.concurrency
+	if concurrency <= 0 {
+		concurrency = defaultManyConcurrency
+	}
+	runConcurrently(ctx, len(identities), concurrency, func(i int) {
+		blobClient, err := azp.blobClient(identities[i], &StorerOptions{})
+		if err != nil {
+			results[i].Err = batchItemError(err)
+			return
+		}
+		_, err = blobClient.SetTags(ctx, &azStorageBlob.BlobSetTagsOptions{
+			TagsMap:                  tags,
+			ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+			LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		})
+		results[i].Err = batchItemError(err)
+	}, func(i int) {
+		results[i].Err = ErrorFromError(ctx.Err())
+	})
+	return results, ctx.Err()
+}
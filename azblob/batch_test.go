@@ -0,0 +1,345 @@
+package azblob
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// checkSignature re-signs a copy of the request and checks the signature
+// matches the one it was sent with
+func checkSignature(t *testing.T, cred *SharedKeyCredential, req *http.Request) {
+	t.Helper()
+	sent := req.Header.Get("Authorization")
+	check := req.Clone(context.Background())
+	check.Header.Del("Authorization")
+	require.NoError(t, signSharedKey(cred, check))
+	assert.Equal(t, sent, check.Header.Get("Authorization"), "signature for %s %s", req.Method, req.URL)
+}
+
+func TestSignSharedKeyMatchesSDK(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	cred, err := azStorageBlob.NewSharedKeyCredential(azuriteWellKnownAccount, azuriteWellKnownKey)
+	require.NoError(t, err)
+
+	var checked atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ContentLength = 0 // as for a request that is about to be sent
+		checkSignature(t, cred, r)
+		checked.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	containerClient, err := azStorageBlob.NewContainerClientWithSharedKey(srv.URL+"/devcontainer", cred, nil)
+	require.NoError(t, err)
+	blobClient, err := containerClient.NewBlobClient("tenant/1/a blob")
+	require.NoError(t, err)
+	etag := "\"0x1\""
+	_, _ = blobClient.GetProperties(context.Background(), &azStorageBlob.BlobGetPropertiesOptions{
+		BlobAccessConditions: &azStorageBlob.BlobAccessConditions{
+			ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{IfMatch: &etag},
+		},
+	})
+	assert.Greater(t, checked.Load(), int32(0))
+}
+
+const (
+	// the only snapshot and version of the blobs of batchServer
+	batchSnapshot  = "2024-01-01T00:00:00.0000000Z"
+	batchVersionID = "2024-01-02T00:00:00.0000000Z"
+	// the lease of the blobs named leased*
+	batchLeaseID = "11111111-2222-3333-4444-555555555555"
+)
+
+// batchServer emulates the container batch endpoint for deletes and set
+// tiers. Requests for blobs named missing* are 404. Blobs named leased* have
+// the lease batchLeaseID, and requests for them without it are 412, as are
+// requests with a lease for other blobs. Each blob has the snapshot
+// batchSnapshot and the version batchVersionID, requests for others are 404.
+func batchServer(t *testing.T, cred *SharedKeyCredential, batches *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		batches.Add(1)
+		if !assert.Equal(t, http.MethodPost, r.Method) ||
+			!assert.Equal(t, "batch", r.URL.Query().Get("comp")) ||
+			!assert.Equal(t, "container", r.URL.Query().Get("restype")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		checkSignature(t, cred, r)
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		// read the whole request before responding
+		type subResponse struct{ id, status, code string }
+		var subs []subResponse
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			sub, err := http.ReadRequest(bufio.NewReader(part))
+			require.NoError(t, err)
+			sub.URL.Scheme, sub.URL.Host = "http", r.Host
+			checkSignature(t, cred, sub)
+
+			resp := subResponse{id: part.Header.Get("Content-ID"), status: "202 Accepted"}
+			switch sub.Method {
+			case http.MethodDelete:
+			case http.MethodPut:
+				assert.Equal(t, "tier", sub.URL.Query().Get("comp"))
+				assert.NotEmpty(t, sub.Header.Get("x-ms-access-tier"))
+				resp.status = "200 OK"
+			default:
+				t.Errorf("unexpected batch sub request %s", sub.Method)
+			}
+			name := sub.URL.Path[strings.LastIndex(sub.URL.Path, "/")+1:]
+			leaseID := sub.Header.Get("x-ms-lease-id")
+			snapshot, versionID := sub.URL.Query().Get("snapshot"), sub.URL.Query().Get("versionid")
+			switch {
+			case strings.HasPrefix(name, "missing"),
+				snapshot != "" && snapshot != batchSnapshot, versionID != "" && versionID != batchVersionID:
+				resp.status, resp.code = "404 The specified blob does not exist.", "BlobNotFound"
+			case strings.HasPrefix(name, "leased") && leaseID != batchLeaseID:
+				resp.status, resp.code = "412 There is currently a lease on the blob", "LeaseIdMissing"
+			case !strings.HasPrefix(name, "leased") && leaseID != "":
+				resp.status, resp.code = "412 There is currently no lease on the blob", "LeaseNotPresentWithBlobOperation"
+			}
+			subs = append(subs, resp)
+		}
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		w.WriteHeader(http.StatusAccepted)
+		for _, resp := range subs {
+			out, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type": {"application/http"},
+				"Content-ID":   {resp.id},
+			})
+			require.NoError(t, err)
+			fmt.Fprintf(out, "HTTP/1.1 %s\r\nx-ms-error-code: %s\r\nContent-Length: 0\r\n\r\n", resp.status, resp.code)
+		}
+		require.NoError(t, mw.Close())
+	}))
+}
+
+func TestStorerDeleteMany(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	cred, err := azStorageBlob.NewSharedKeyCredential(azuriteWellKnownAccount, azuriteWellKnownKey)
+	require.NoError(t, err)
+	var batches atomic.Int32
+	srv := batchServer(t, cred, &batches)
+	defer srv.Close()
+
+	azp := &Storer{
+		Container:    "devcontainer",
+		containerURL: srv.URL + "/devcontainer",
+		credential:   cred,
+	}
+
+	identities := make([]string, 0, 300)
+	for i := 0; i < 298; i++ {
+		identities = append(identities, "tenant/1/blob "+strconv.Itoa(i))
+	}
+	identities = append(identities, "tenant/1/missing", "tenant/1/leased")
+
+	ctx := context.Background()
+	results, err := azp.DeleteMany(ctx, identities, WithDryRun())
+	require.NoError(t, err)
+	assert.Len(t, results, len(identities))
+	assert.Equal(t, int32(0), batches.Load())
+
+	results, err = azp.DeleteMany(ctx, identities, WithConcurrency(2))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), batches.Load())
+	require.Len(t, results, len(identities))
+	for i, result := range results {
+		assert.Equal(t, identities[i], result.Identity)
+		if result.Identity == "tenant/1/leased" {
+			require.Error(t, result.Err)
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(result.Err).StatusCode())
+			assert.Equal(t, string(azStorageBlob.StorageErrorCodeLeaseIDMissing), ErrorFromError(result.Err).StorageErrorCode())
+			continue
+		}
+		// as for Delete, a missing blob is not an error
+		assert.NoError(t, result.Err, result.Identity)
+	}
+}
+
+// unavailableServer responds 503 to the first failures requests, asking for
+// an immediate retry, and passes the rest to next
+func unavailableServer(next http.Handler, failures int32) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.Header().Set("retry-after-ms", "1")
+			w.Header().Set(xMsErrorCodeHeader, "ServerBusy")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})), &requests
+}
+
+func TestStorerBatchRetries(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	cred, err := azStorageBlob.NewSharedKeyCredential(azuriteWellKnownAccount, azuriteWellKnownKey)
+	require.NoError(t, err)
+	var batches atomic.Int32
+	batchSrv := batchServer(t, cred, &batches)
+	defer batchSrv.Close()
+	ctx := context.Background()
+
+	// a busy service is retried
+	srv, requests := unavailableServer(batchSrv.Config.Handler, 2)
+	defer srv.Close()
+	azp := &Storer{
+		Container:    "devcontainer",
+		containerURL: srv.URL + "/devcontainer",
+		credential:   cred,
+	}
+	results, err := azp.DeleteMany(ctx, []string{"tenant/1/a", "tenant/1/leased"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, int32(1), batches.Load())
+	require.Len(t, results, 2)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(results[1].Err).StatusCode())
+
+	// but not forever
+	srv, requests = unavailableServer(batchSrv.Config.Handler, 100)
+	defer srv.Close()
+	azp.containerURL = srv.URL + "/devcontainer"
+	results, err = azp.DeleteMany(ctx, []string{"tenant/1/a"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, ErrorFromError(results[0].Err).StatusCode())
+	assert.Equal(t, "ServerBusy", ErrorFromError(results[0].Err).StorageErrorCode())
+	assert.Equal(t, int32(4), requests.Load())
+	assert.Equal(t, int32(1), batches.Load())
+}
+
+func TestStorerSetTierMany(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	cred, err := azStorageBlob.NewSharedKeyCredential(azuriteWellKnownAccount, azuriteWellKnownKey)
+	require.NoError(t, err)
+	var batches atomic.Int32
+	srv := batchServer(t, cred, &batches)
+	defer srv.Close()
+
+	azp := &Storer{
+		Container:    "devcontainer",
+		containerURL: srv.URL + "/devcontainer",
+		credential:   cred,
+	}
+	ctx := context.Background()
+
+	_, err = azp.SetTierMany(ctx, []string{"a"}, AccessTierCool, WithEtagMatch("\"0x1\""))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	_, err = azp.SetTierMany(ctx, []string{"a"}, "P10")
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	assert.Equal(t, int32(0), batches.Load())
+
+	results, err := azp.SetTierMany(ctx, []string{"tenant/1/a", "tenant/1/missing"}, AccessTierArchive,
+		WithWhereTags("retain='false'"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), batches.Load())
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(results[1].Err).StatusCode())
+
+	// the lease, snapshot and version are set for each blob
+	results, err = azp.SetTierMany(ctx, []string{"tenant/1/leased", "tenant/1/a"}, AccessTierCool,
+		WithLeaseID(batchLeaseID))
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, string(azStorageBlob.StorageErrorCodeLeaseNotPresentWithBlobOperation),
+		ErrorFromError(results[1].Err).StorageErrorCode())
+
+	for _, opt := range []Option{WithSnapshot(batchSnapshot), WithVersionID(batchVersionID)} {
+		results, err = azp.SetTierMany(ctx, []string{"tenant/1/a"}, AccessTierCool, opt)
+		require.NoError(t, err)
+		assert.NoError(t, results[0].Err)
+	}
+	for _, opt := range []Option{WithSnapshot(batchVersionID), WithVersionID(batchSnapshot)} {
+		results, err = azp.SetTierMany(ctx, []string{"tenant/1/a"}, AccessTierCool, opt)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, ErrorFromError(results[0].Err).StatusCode())
+	}
+	_, err = azp.SetTierMany(ctx, []string{"tenant/1/a"}, AccessTierCool,
+		WithSnapshot(batchSnapshot), WithVersionID(batchVersionID))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
+
+func TestLocalStorerBatch(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for _, identity := range []string{"a", "b", "c"} {
+				_, err := store.Put(ctx, identity, NewBytesReaderCloser([]byte(identity)))
+				require.NoError(t, err)
+			}
+			leaseID, err := store.AcquireLease(ctx, "c", 15)
+			require.NoError(t, err)
+
+			results, err := store.SetTagsMany(ctx, []string{"a", "b", "c", "missing"}, map[string]string{"retain": "false"})
+			require.NoError(t, err)
+			assert.NoError(t, results[0].Err)
+			assert.NoError(t, results[1].Err)
+			assert.NoError(t, results[2].Err, "tags are set without the lease")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(results[3].Err).StatusCode())
+
+			count, err := store.Count(ctx, "retain='false'")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), count)
+
+			results, err = store.DeleteMany(ctx, []string{"a", "b", "c"}, WithDryRun())
+			require.NoError(t, err)
+			assert.Len(t, results, 3)
+			count, err = store.Count(ctx, "retain='false'")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), count)
+
+			results, err = store.DeleteMany(ctx, []string{"a", "b", "c", "missing"})
+			require.NoError(t, err)
+			assert.NoError(t, results[0].Err)
+			assert.NoError(t, results[1].Err)
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(results[2].Err).StatusCode())
+			assert.NoError(t, results[3].Err)
+
+			require.NoError(t, store.ReleaseLease(ctx, "c", leaseID))
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			results, err = store.DeleteMany(cancelled, []string{"c"})
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.ErrorIs(t, results[0].Err, context.Canceled)
+		})
+	}
+}
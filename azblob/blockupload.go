@@ -0,0 +1,218 @@
+package azblob
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	maxUploadBuffers = 3
+	// maxStageBlockSize is the largest block azure accepts
+	maxStageBlockSize = 4000 * 1024 * 1024
+	// maxUploadBufferSize limits the total size of the block buffers of an
+	// upload, the concurrency is reduced to keep within it
+	maxUploadBufferSize = 512 * 1024 * 1024
+	// minBlockBufferSize is the initial size of a block buffer, which grows
+	// up to the block size as the content is read
+	minBlockBufferSize = 64 * 1024
+)
+
+// blockUpload stages the content of a reader as uncommitted blocks and then
+// commits the block list, together with the metadata and tags, in a single
+// request. Nothing is visible to readers until the commit succeeds, and the
+// access conditions are applied on the commit.
+//
+// The metadata is obtained from commitMetadata, if it is not nil, only once
+// the reader is exhausted. This allows metadata derived from the content, such
+// as its hash, to be written atomically with it.
+type blockUpload struct {
+	client         *azStorageBlob.BlockBlobClient
+	options        *StorerOptions
+	conditions     *azStorageBlob.BlobAccessConditions
+	commitMetadata func() (map[string]string, error)
+
+	uploadID string
+	ids      []string
+
+	mu       sync.Mutex
+	firstErr error
+	staged   int64
+}
+
+// blockID returns the base64 block id for the nth block. All block ids for a
+// blob must be the same length.
+func (u *blockUpload) blockID(n int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%08d", u.uploadID, n)))
+}
+
+func (u *blockUpload) setErr(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.firstErr == nil {
+		u.firstErr = err
+	}
+}
+
+func (u *blockUpload) err() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.firstErr
+}
+
+// blockStaged reports the progress of the upload. The lock keeps the reported
+// totals in order.
+func (u *blockUpload) blockStaged(length int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.staged += int64(length)
+	if u.options.progress != nil {
+		u.options.progress(u.staged)
+	}
+}
+
+// checkUploadOptions validates the WithBlockSize and WithConcurrency options
+// for a block upload
+func checkUploadOptions(options *StorerOptions) error {
+	if options.blockSize > maxStageBlockSize {
+		return NewStatusError(
+			fmt.Sprintf("block size %d exceeds the maximum %d", options.blockSize, maxStageBlockSize),
+			http.StatusBadRequest)
+	}
+	return nil
+}
+
+// uploadConcurrency returns the number of blocks to stage concurrently,
+// limited so that the buffers for them are at most maxUploadBufferSize
+func uploadConcurrency(blockSize int64, concurrency int) int {
+	if concurrency <= 0 {
+		concurrency = maxUploadBuffers
+	}
+	return int(max(1, min(int64(concurrency), maxUploadBufferSize/blockSize)))
+}
+
+// readBlock reads up to blockSize bytes into buf, which is grown as the
+// content is read so that a short upload doesn't allocate a whole block. It
+// returns the block read and io.EOF, or another error, if the reader ended.
+func readBlock(reader io.Reader, buf []byte, blockSize int64) ([]byte, error) {
+	buf = buf[:0]
+	for int64(len(buf)) < blockSize {
+		if len(buf) == cap(buf) {
+			grown := make([]byte, len(buf), min(max(2*int64(cap(buf)), minBlockBufferSize), blockSize))
+			copy(grown, buf)
+			buf = grown
+		}
+		n, err := reader.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// run stages the blocks, using at most WithConcurrency() concurrent requests,
+// and commits them. Each request holds a buffer of up to WithBlockSize()
+// bytes, the buffers are allocated as the content is read.
+func (u *blockUpload) run(ctx context.Context, reader io.Reader) (azStorageBlob.BlockBlobCommitBlockListResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	u.uploadID = uuid.NewString()
+
+	var leaseConditions *azStorageBlob.LeaseAccessConditions
+	if u.conditions != nil {
+		leaseConditions = u.conditions.LeaseAccessConditions
+	}
+
+	blockSize := u.options.blockSize
+	if blockSize <= 0 {
+		blockSize = chunkSize
+	}
+	concurrency := uploadConcurrency(blockSize, u.options.concurrency)
+	// a buffer is taken for each block in flight and returned when it is
+	// staged, they start empty and are grown by readBlock
+	buffers := make(chan []byte, concurrency)
+	for i := 0; i < concurrency; i++ {
+		buffers <- []byte{}
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; u.err() == nil; n++ {
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-ctx.Done():
+			u.setErr(ctx.Err())
+		}
+		if buf == nil {
+			break
+		}
+
+		buf, readErr := readBlock(reader, buf, blockSize)
+		if readErr != nil && readErr != io.EOF { //nolint https://github.com/golang/go/issues/39155
+			u.setErr(readErr)
+			break
+		}
+		length := len(buf)
+		if length == 0 {
+			break
+		}
+
+		id := u.blockID(n)
+		u.ids = append(u.ids, id)
+		wg.Add(1)
+		go func(buf []byte, length int) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+			_, err := u.client.StageBlock(
+				ctx, id, NewBytesReaderCloser(buf[:length]),
+				&azStorageBlob.BlockBlobStageBlockOptions{LeaseAccessConditions: leaseConditions},
+			)
+			if err != nil {
+				logger.Sugar.Infof("failed to stage block %d: %v", n, err)
+				u.setErr(err)
+				cancel()
+				return
+			}
+			u.blockStaged(length)
+		}(buf, length)
+
+		if readErr != nil {
+			// EOF or a short final block
+			break
+		}
+	}
+	wg.Wait()
+	if err := u.err(); err != nil {
+		// The staged blocks are never committed and azure discards them.
+		return azStorageBlob.BlockBlobCommitBlockListResponse{}, err
+	}
+
+	metadata := u.options.metadata
+	if u.commitMetadata != nil {
+		var err error
+		if metadata, err = u.commitMetadata(); err != nil {
+			return azStorageBlob.BlockBlobCommitBlockListResponse{}, err
+		}
+	}
+	return u.client.CommitBlockList(
+		ctx,
+		u.ids,
+		&azStorageBlob.BlockBlobCommitBlockListOptions{
+			BlobAccessConditions: u.conditions,
+			BlobHTTPHeaders:      blobHTTPHeaders(u.options),
+			Tier:                 accessTierOption(u.options),
+			Metadata:             metadata,
+			BlobTagsMap:          u.options.tags,
+		},
+	)
+}
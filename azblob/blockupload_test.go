@@ -0,0 +1,171 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// uploadServer records the block uploads and the headers of each commit
+type uploadServer struct {
+	mu         sync.Mutex
+	blockSizes []int
+	inFlight   int
+	maxFlight  int
+	commit     http.Header
+}
+
+func (u *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	switch r.URL.Query().Get("comp") {
+	case "block":
+		u.mu.Lock()
+		u.blockSizes = append(u.blockSizes, len(body))
+		u.inFlight++
+		u.maxFlight = max(u.maxFlight, u.inFlight)
+		u.mu.Unlock()
+		defer func() {
+			u.mu.Lock()
+			u.inFlight--
+			u.mu.Unlock()
+		}()
+		w.WriteHeader(http.StatusCreated)
+	case "blocklist", "":
+		if r.Method != http.MethodPut {
+			// the container exists
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		u.mu.Lock()
+		u.commit = r.Header.Clone()
+		u.mu.Unlock()
+		w.Header().Set("ETag", "\"0x1\"")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestStorerUploadTuning(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := &uploadServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL,
+		WithUploadDefaults(WithBlockSize(1000), WithConcurrency(2), WithAccessTier(AccessTierCool)))
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 250)
+	var progress []int64
+	_, err := azp.Write(ctx, "evidence", bytes.NewReader(content),
+		WithConcurrency(4),
+		WithProgress(func(n int64) { progress = append(progress, n) }),
+		WithContentType("text/plain"),
+		WithCacheControl("max-age=3600"),
+		WithContentDisposition("attachment; filename=evidence.txt"),
+		WithContentMD5([]byte("0123456789abcdef")))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []int{1000, 1000, 500}, fake.blockSizes)
+	assert.LessOrEqual(t, fake.maxFlight, 4)
+	require.Len(t, progress, 3)
+	assert.IsIncreasing(t, progress)
+	assert.Equal(t, int64(len(content)), progress[2])
+
+	assert.Equal(t, "Cool", fake.commit.Get("x-ms-access-tier"))
+	assert.Equal(t, "text/plain", fake.commit.Get("x-ms-blob-content-type"))
+	assert.Equal(t, "max-age=3600", fake.commit.Get("x-ms-blob-cache-control"))
+	assert.Equal(t, "attachment; filename=evidence.txt", fake.commit.Get("x-ms-blob-content-disposition"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")), fake.commit.Get("x-ms-blob-content-md5"))
+
+	// the call overrides the storer defaults, and WriteStream does not set
+	// the content type from the uploaded mime type
+	fake.blockSizes = nil
+	_, err = azp.WriteStream(ctx, "evidence", multipartRequest(t, "evidence.txt", content),
+		WithSizeLimit(-1), WithBlockSize(2000), WithAccessTier(AccessTierHot))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{2000, 500}, fake.blockSizes)
+	assert.Equal(t, "Hot", fake.commit.Get("x-ms-access-tier"))
+	assert.Empty(t, fake.commit.Get("x-ms-blob-content-type"))
+
+	_, err = azp.Write(ctx, "evidence", bytes.NewReader(content), WithBlockSize(maxStageBlockSize+1))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
+
+func TestStorerPutContentHeaders(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := &uploadServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL, WithUploadDefaults(WithAccessTier(AccessTierCool)))
+
+	content := []byte("VALUE")
+	sum := md5.Sum(content)
+	_, err := azp.Put(context.Background(), "evidence", NewBytesReaderCloser(content),
+		WithContentType("text/plain"), WithContentMD5(sum[:]))
+	require.NoError(t, err)
+	assert.Equal(t, "Cool", fake.commit.Get("x-ms-access-tier"))
+	assert.Equal(t, "text/plain", fake.commit.Get("x-ms-blob-content-type"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), fake.commit.Get("Content-MD5"))
+}
+
+func TestUploadBuffers(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	assert.Equal(t, maxUploadBuffers, uploadConcurrency(chunkSize, 0))
+	assert.Equal(t, 8, uploadConcurrency(chunkSize, 8))
+	assert.Equal(t, 5, uploadConcurrency(100*1024*1024, 8))
+	assert.Equal(t, 1, uploadConcurrency(maxStageBlockSize, 8))
+
+	// the buffer only grows as far as the content
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	buf, err := readBlock(bytes.NewReader(content[:10]), []byte{}, maxStageBlockSize)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, content[:10], buf)
+	assert.Equal(t, minBlockBufferSize, cap(buf))
+
+	buf, err = readBlock(bytes.NewReader(content), nil, 4000)
+	require.NoError(t, err)
+	assert.Equal(t, content[:4000], buf)
+	assert.Equal(t, 4000, cap(buf))
+
+	// a reused buffer is not reallocated
+	reader := bytes.NewReader(content)
+	buf = make([]byte, 0, 4000)
+	first := &buf[:1][0]
+	buf, err = readBlock(reader, buf, 4000)
+	require.NoError(t, err)
+	assert.Same(t, first, &buf[0])
+}
+
+func TestStorerUploadLargeBlockSize(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := &uploadServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+
+	// the maximum block size doesn't allocate a block for a small upload
+	_, err := azp.Write(context.Background(), "evidence", bytes.NewReader([]byte("VALUE")),
+		WithBlockSize(maxStageBlockSize), WithConcurrency(64))
+	require.NoError(t, err)
+	assert.Equal(t, []int{5}, fake.blockSizes)
+}
@@ -0,0 +1,326 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// ContainerAccess is the level of anonymous public read access to a container
+type ContainerAccess int
+
+const (
+	// ContainerAccessPrivate allows no anonymous access
+	ContainerAccessPrivate ContainerAccess = iota
+	// ContainerAccessBlob allows anonymous reads of blobs, but not listing
+	ContainerAccessBlob
+	// ContainerAccessContainer allows anonymous reads and listing of blobs
+	ContainerAccessContainer
+)
+
+func (a ContainerAccess) String() string {
+	switch a {
+	case ContainerAccessPrivate:
+		return "private"
+	case ContainerAccessBlob:
+		return "blob"
+	case ContainerAccessContainer:
+		return "container"
+	default:
+		return fmt.Sprintf("ContainerAccess(%d)", a)
+	}
+}
+
+// publicAccessType returns the sdk value for the access level, nil for
+// private
+func (a ContainerAccess) publicAccessType() *azStorageBlob.PublicAccessType {
+	var value azStorageBlob.PublicAccessType
+	switch a {
+	case ContainerAccessBlob:
+		value = azStorageBlob.PublicAccessTypeBlob
+	case ContainerAccessContainer:
+		value = azStorageBlob.PublicAccessTypeContainer
+	default:
+		return nil
+	}
+	return &value
+}
+
+func containerAccessFromType(value *azStorageBlob.PublicAccessType) ContainerAccess {
+	switch {
+	case value == nil:
+		return ContainerAccessPrivate
+	case *value == azStorageBlob.PublicAccessTypeBlob:
+		return ContainerAccessBlob
+	case *value == azStorageBlob.PublicAccessTypeContainer:
+		return ContainerAccessContainer
+	default:
+		return ContainerAccessPrivate
+	}
+}
+
+// ContainerManager is the interface for managing the container of a Storer,
+// and for listing the other containers in the storage account
+type ContainerManager interface {
+	EnsureContainer(ctx context.Context, opts ...Option) error
+	DeleteContainer(ctx context.Context, opts ...Option) error
+	ListContainers(ctx context.Context, opts ...Option) (*ContainersResponse, error)
+	GetContainerMetadata(ctx context.Context) (map[string]string, error)
+	SetContainerMetadata(ctx context.Context, metadata map[string]string, opts ...Option) error
+	GetContainerAccess(ctx context.Context) (ContainerAccess, error)
+	SetContainerAccess(ctx context.Context, access ContainerAccess, opts ...Option) error
+}
+
+type ContainersResponse struct {
+	Marker ListMarker // nil if no more pages
+
+	// Standard request status things
+	StatusCode int
+	Status     string
+
+	Items []*azStorageBlob.ContainerItem
+}
+
+// checkContainer returns an error if the container does not exist. If
+// WithContainerCheckTTL was given, a successful check is remembered for the
+// ttl.
+func (azp *Storer) checkContainer(ctx context.Context) error {
+	if azp.containerCheckTTL > 0 {
+		azp.containerMu.Lock()
+		checked := azp.containerCheckedAt
+		azp.containerMu.Unlock()
+		if !checked.IsZero() && time.Since(checked) < azp.containerCheckTTL {
+			return nil
+		}
+	}
+
+	logger.Sugar.Debugf("Checking container URL %s", azp.containerURL)
+	_, err := azp.containerClient.GetProperties(ctx, nil)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	azp.setContainerChecked(time.Now())
+	return nil
+}
+
+func (azp *Storer) setContainerChecked(checked time.Time) {
+	azp.containerMu.Lock()
+	defer azp.containerMu.Unlock()
+	azp.containerCheckedAt = checked
+}
+
+// forgetContainerCheck discards a remembered container check if err shows
+// the container has since been deleted
+func (azp *Storer) forgetContainerCheck(err error) {
+	if err == nil {
+		return
+	}
+	if ErrorFromError(err).StorageErrorCode() == string(azStorageBlob.StorageErrorCodeContainerNotFound) {
+		azp.setContainerChecked(time.Time{})
+	}
+}
+
+// EnsureContainer creates the container if it does not exist. The options
+// only apply if the container is created.
+//
+// Options:
+//
+//	WithMetadata() - the metadata for the container
+//	WithContainerAccess() - the public access level, private by default
+func (azp *Storer) EnsureContainer(ctx context.Context, opts ...Option) error {
+	logger.Sugar.Infof("EnsureContainer %s", azp.containerURL)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	_, err := azp.containerClient.Create(ctx, &azStorageBlob.ContainerCreateOptions{
+		Access:   options.containerAccess.publicAccessType(),
+		Metadata: options.metadata,
+	})
+	if err != nil {
+		serr := ErrorFromError(err)
+		if serr.StorageErrorCode() != string(azStorageBlob.StorageErrorCodeContainerAlreadyExists) {
+			return serr
+		}
+	}
+	azp.setContainerChecked(time.Now())
+	return nil
+}
+
+// DeleteContainer deletes the container and all of the blobs in it. A
+// container that does not exist is not an error. The name can't be reused
+// until azure has finished deleting the container, which may take some time.
+//
+// Options:
+//
+//	WithLeaseID() - required if the container has an active lease
+//	WithModifiedSince() or WithUnmodifiedSince() - only delete if the container changed or not
+func (azp *Storer) DeleteContainer(ctx context.Context, opts ...Option) error {
+	logger.Sugar.Infof("DeleteContainer %s", azp.containerURL)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.etagCondition != EtagNotUsed {
+		return NewStatusError("etag conditions are not supported for containers", http.StatusBadRequest)
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return err
+	}
+	azp.setContainerChecked(time.Time{})
+	_, err = azp.containerClient.Delete(ctx, &azStorageBlob.ContainerDeleteOptions{
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	})
+	if err != nil {
+		serr := ErrorFromError(err)
+		if serr.StatusCode() == http.StatusNotFound {
+			return nil
+		}
+		return serr
+	}
+	return nil
+}
+
+// ListContainers returns a page of the containers in the storage account.
+//
+// Options:
+//
+//	WithListPrefix() - only containers whose names start with the prefix
+//	WithListMarker() - the marker from the previous page
+//	WithListMaxResults() - the maximum number of containers in the page
+//	WithListMetadata() - include the metadata of each container
+func (azp *Storer) ListContainers(ctx context.Context, opts ...Option) (*ContainersResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if azp.serviceClient == nil {
+		return nil, fmt.Errorf("no service client available to list containers")
+	}
+	o := azStorageBlob.ListContainersOptions{
+		Include:    azStorageBlob.ListContainersDetail{Metadata: options.listIncludeMetadata},
+		Marker:     options.listMarker,
+		MaxResults: listMaxResults(options),
+	}
+	if options.listPrefix != "" {
+		o.Prefix = &options.listPrefix
+	}
+
+	r := &ContainersResponse{}
+	pager := azp.serviceClient.ListContainers(&o)
+	if !pager.NextPage(ctx) {
+		// the pager only returns false on the first page if the request failed
+		if err := pager.Err(); err != nil {
+			return nil, ErrorFromError(err)
+		}
+		return r, nil
+	}
+	resp := pager.PageResponse()
+	r.Status = resp.RawResponse.Status
+	r.StatusCode = resp.RawResponse.StatusCode
+	r.Marker = resp.NextMarker
+	r.Items = resp.ContainerItems
+	return r, nil
+}
+
+// GetContainerMetadata returns the metadata of the container
+func (azp *Storer) GetContainerMetadata(ctx context.Context) (map[string]string, error) {
+	resp, err := azp.containerClient.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return resp.Metadata, nil
+}
+
+// SetContainerMetadata replaces the metadata of the container.
+//
+// Options:
+//
+//	WithLeaseID() - only set the metadata if the container lease is active
+//	WithModifiedSince() - only set the metadata if the container changed since
+func (azp *Storer) SetContainerMetadata(ctx context.Context, metadata map[string]string, opts ...Option) error {
+	logger.Sugar.Debugf("SetContainerMetadata %s", azp.containerURL)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.etagCondition != EtagNotUsed {
+		return NewStatusError("etag conditions are not supported for containers", http.StatusBadRequest)
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return err
+	}
+	_, err = azp.containerClient.SetMetadata(ctx, &azStorageBlob.ContainerSetMetadataOptions{
+		Metadata:                 metadata,
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	})
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// GetContainerAccess returns the public access level of the container
+func (azp *Storer) GetContainerAccess(ctx context.Context) (ContainerAccess, error) {
+	resp, err := azp.containerClient.GetProperties(ctx, nil)
+	if err != nil {
+		return ContainerAccessPrivate, ErrorFromError(err)
+	}
+	return containerAccessFromType(resp.BlobPublicAccess), nil
+}
+
+// SetContainerAccess sets the public access level of the container. The
+// stored access policies of the container are kept.
+//
+// Options:
+//
+//	WithLeaseID() - only set the access if the container lease is active
+//	WithModifiedSince() or WithUnmodifiedSince() - only set the access if the container changed or not
+func (azp *Storer) SetContainerAccess(ctx context.Context, access ContainerAccess, opts ...Option) error {
+	logger.Sugar.Infof("SetContainerAccess %s %s", azp.containerURL, access)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.etagCondition != EtagNotUsed {
+		return NewStatusError("etag conditions are not supported for containers", http.StatusBadRequest)
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return err
+	}
+
+	// setting the access replaces the access policies, so set them again
+	policies, err := azp.containerClient.GetAccessPolicy(ctx, &azStorageBlob.ContainerGetAccessPolicyOptions{
+		LeaseAccessConditions: blobAccessConditions.LeaseAccessConditions,
+	})
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	_, err = azp.containerClient.SetAccessPolicy(ctx, &azStorageBlob.ContainerSetAccessPolicyOptions{
+		AccessConditions: &azStorageBlob.ContainerAccessConditions{
+			LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+			ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+		},
+		Access:       access.publicAccessType(),
+		ContainerACL: policies.SignedIdentifiers,
+	})
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
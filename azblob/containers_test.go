@@ -0,0 +1,183 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// containerServer emulates the container operations for a single container
+type containerServer struct {
+	mu       sync.Mutex
+	exists   bool
+	access   string
+	metadata map[string]string
+	checks   atomic.Int32
+}
+
+func (c *containerServer) notFound(w http.ResponseWriter) {
+	w.Header().Set(xMsErrorCodeHeader, string(azStorageBlob.StorageErrorCodeContainerNotFound))
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (c *containerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	q := r.URL.Query()
+	if q.Get("comp") == "list" {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="%s">`+
+			`<Containers><Container><Name>devcontainer</Name><Properties><Etag>"0x1"</Etag></Properties></Container></Containers>`+
+			`<NextMarker/></EnumerationResults>`, r.Host)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "":
+		if c.exists {
+			w.Header().Set(xMsErrorCodeHeader, string(azStorageBlob.StorageErrorCodeContainerAlreadyExists))
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		c.exists = true
+		c.access = r.Header.Get("x-ms-blob-public-access")
+		c.metadata = map[string]string{}
+		for name := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-ms-meta-") {
+				c.metadata[name] = r.Header.Get(name)
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	case !c.exists:
+		c.notFound(w)
+	case r.Method == http.MethodGet && q.Get("comp") == "":
+		c.checks.Add(1)
+		for name, value := range c.metadata {
+			w.Header().Set(name, value)
+		}
+		if c.access != "" {
+			w.Header().Set("x-ms-blob-public-access", c.access)
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete:
+		c.exists = false
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet && q.Get("comp") == "acl":
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><SignedIdentifiers/>`)
+	case r.Method == http.MethodPut && q.Get("comp") == "acl":
+		c.access = r.Header.Get("x-ms-blob-public-access")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newContainerTestStorer(t *testing.T, url string, options ...StorerOption) *Storer {
+	cred, err := azStorageBlob.NewSharedKeyCredential(azuriteWellKnownAccount, azuriteWellKnownKey)
+	require.NoError(t, err)
+	azp := &Storer{
+		Container:    "devcontainer",
+		credential:   cred,
+		rootURL:      url + "/devstoreaccount1/",
+		containerURL: url + "/devstoreaccount1/devcontainer",
+	}
+	for _, option := range options {
+		option(azp)
+	}
+	azp.serviceClient, err = azStorageBlob.NewServiceClientWithSharedKey(azp.rootURL, cred, nil)
+	require.NoError(t, err)
+	azp.containerClient, err = azp.serviceClient.NewContainerClient("devcontainer")
+	require.NoError(t, err)
+	return azp
+}
+
+func TestStorerContainerLifecycle(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := &containerServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+	ctx := context.Background()
+
+	err := azp.checkContainer(ctx)
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+	require.NoError(t, azp.EnsureContainer(ctx,
+		WithContainerAccess(ContainerAccessBlob), WithMetadata(map[string]string{"purpose": "evidence"})))
+	require.NoError(t, azp.EnsureContainer(ctx), "an existing container is not an error")
+	require.NoError(t, azp.checkContainer(ctx))
+
+	metadata, err := azp.GetContainerMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "evidence", metadata["Purpose"])
+	access, err := azp.GetContainerAccess(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ContainerAccessBlob, access)
+
+	require.NoError(t, azp.SetContainerAccess(ctx, ContainerAccessPrivate))
+	access, err = azp.GetContainerAccess(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ContainerAccessPrivate, access)
+
+	err = azp.SetContainerMetadata(ctx, nil, WithEtagMatch("\"0x1\""))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+	containers, err := azp.ListContainers(ctx, WithListPrefix("dev"))
+	require.NoError(t, err)
+	require.Len(t, containers.Items, 1)
+	assert.Equal(t, "devcontainer", *containers.Items[0].Name)
+
+	require.NoError(t, azp.DeleteContainer(ctx))
+	require.NoError(t, azp.DeleteContainer(ctx), "a missing container is not an error")
+	err = azp.checkContainer(ctx)
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+}
+
+func TestStorerContainerCheckTTL(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := &containerServer{exists: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctx := context.Background()
+
+	// by default every check is a round trip
+	azp := newContainerTestStorer(t, srv.URL)
+	require.NoError(t, azp.checkContainer(ctx))
+	require.NoError(t, azp.checkContainer(ctx))
+	assert.Equal(t, int32(2), fake.checks.Load())
+
+	fake.checks.Store(0)
+	azp = newContainerTestStorer(t, srv.URL, WithContainerCheckTTL(time.Minute))
+	require.NoError(t, azp.checkContainer(ctx))
+	require.NoError(t, azp.checkContainer(ctx))
+	assert.Equal(t, int32(1), fake.checks.Load())
+
+	// a write that finds the container missing forgets the check
+	azp.forgetContainerCheck(newStorageCodeError(
+		azStorageBlob.StorageErrorCodeContainerNotFound, http.StatusNotFound, "container not found"))
+	require.NoError(t, azp.checkContainer(ctx))
+	assert.Equal(t, int32(2), fake.checks.Load())
+}
+
+func TestContainerAccessString(t *testing.T) {
+	assert.Equal(t, "private", ContainerAccessPrivate.String())
+	assert.Equal(t, "blob", ContainerAccessBlob.String())
+	assert.Equal(t, "container", ContainerAccessContainer.String())
+	assert.Equal(t, "ContainerAccess(7)", ContainerAccess(7).String())
+	assert.Equal(t, "ContainerAccess(-1)", ContainerAccess(-1).String())
+}
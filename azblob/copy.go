@@ -0,0 +1,281 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	copyAbortTimeoutSecs = 5
+)
+
+// Copier is the interface for copying blobs within the storage account. The
+// copy is done by the storage service, the content is not read by the caller.
+type Copier interface {
+	Copy(ctx context.Context, source string, destination string, opts ...Option) (*WriteResponse, error)
+	Move(ctx context.Context, source string, destination string, opts ...Option) (*WriteResponse, error)
+}
+
+// copySourceClient returns the client for the source of a copy, which may be
+// in another container
+func (azp *Storer) copySourceClient(source string, options *StorerOptions) (*azStorageBlob.BlobClient, error) {
+	if options.copySourceContainer == "" || options.copySourceContainer == azp.Container {
+		return azp.blobClient(source, options)
+	}
+	if azp.serviceClient == nil {
+		return nil, errors.New("no service client available for copy")
+	}
+	containerClient, err := azp.serviceClient.NewContainerClient(options.copySourceContainer)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	blobClient, err := containerClient.NewBlobClient(source)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return selectBlobVersion(blobClient, options)
+}
+
+// Copy copies the source blob to the destination blob in this container,
+// replacing it if it exists. Copies within a storage account are normally
+// complete on return from the service, otherwise Copy waits for the copy to
+// complete. If ctx is done while waiting the copy is aborted.
+//
+// The metadata and tags of the source are copied unless overridden.
+//
+// Options:
+//
+//	WithCopySourceContainer() - the container of the source, by default this one
+//	WithSnapshot() or WithVersionID() - copy a snapshot or version of the source
+//	WithCopySourceEtagMatch() - only copy if the source etag matches
+//	WithMetadata() - the metadata for the destination
+//	WithTags() - the tags for the destination
+//	WithLeaseID() - required if the destination is leased
+//	WithEtagMatch() etc. - access conditions on the destination
+func (azp *Storer) Copy(ctx context.Context, source string, destination string, opts ...Option) (*WriteResponse, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Debugf("Copy %s container '%s' to %s", source, options.copySourceContainer, destination)
+	sourceClient, err := azp.copySourceClient(source, options)
+	if err != nil {
+		return nil, err
+	}
+	blobClient, err := azp.blobClient(destination, &StorerOptions{})
+	if err != nil {
+		return nil, err
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// a copy doesn't include the tags, so copy them explicitly
+	tags := options.tags
+	if tags == nil {
+		tags, err = blobTags(ctx, sourceClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var sourceConditions *azStorageBlob.SourceModifiedAccessConditions
+	if options.copySourceEtag != "" {
+		sourceConditions = &azStorageBlob.SourceModifiedAccessConditions{
+			SourceIfMatch: &options.copySourceEtag,
+		}
+	}
+
+	r, err := blobClient.StartCopyFromURL(ctx, sourceClient.URL(), &azStorageBlob.BlobStartCopyOptions{
+		Metadata:                       options.metadata,
+		TagsMap:                        tags,
+		SourceModifiedAccessConditions: sourceConditions,
+		LeaseAccessConditions:          blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions:       blobAccessConditions.ModifiedAccessConditions,
+	})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	w := &WriteResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		StatusCode:   r.RawResponse.StatusCode,
+		Status:       r.RawResponse.Status,
+	}
+	if r.VersionID != nil {
+		w.VersionID = *r.VersionID
+	}
+	if r.CopyID != nil {
+		w.CopyID = *r.CopyID
+	}
+	if r.CopyStatus != nil && *r.CopyStatus == azStorageBlob.CopyStatusTypeSuccess {
+		return w, nil
+	}
+
+	err = waitForCopy(ctx, blobClient, r.CopyID)
+	if err != nil && ctx.Err() != nil && r.CopyID != nil {
+		azp.abortCopy(blobClient, destination, *r.CopyID, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// abortCopy aborts a pending copy, leaving an empty destination blob. As for
+// ReleaseLease, it does not depend on the request context.
+func (azp *Storer) abortCopy(blobClient *azStorageBlob.BlobClient, destination string, copyID string, options *StorerOptions) {
+	logger.Sugar.Infof("Abort copy %s to %s", copyID, destination)
+
+	ctx, cancel := context.WithTimeout(context.Background(), copyAbortTimeoutSecs*time.Second)
+	defer cancel()
+	abortOptions := &azStorageBlob.BlobAbortCopyOptions{}
+	if options.leaseID != "" {
+		abortOptions.LeaseAccessConditions = &azStorageBlob.LeaseAccessConditions{
+			LeaseID: &options.leaseID,
+		}
+	}
+	if _, err := blobClient.AbortCopyFromURL(ctx, copyID, abortOptions); err != nil {
+		logger.Sugar.Infof("failed to abort copy %s to %s: %v", copyID, destination, err)
+	}
+}
+
+// Move copies the source blob to the destination blob, see Copy, and then
+// deletes the source. The source is only copied and deleted if its etag is
+// unchanged, so a concurrent write to the source is never lost. If the source
+// is written after the copy, the source is not deleted and the error has
+// status 412.
+//
+// A leased source, or one with snapshots, can't be moved. This is checked
+// before the copy, the error has status 412 or 409 respectively. If the
+// source still can't be deleted after the copy, the new destination is
+// deleted so the blob isn't left in both places.
+//
+// Options are as for Copy, except that a snapshot or version can't be moved.
+// Without WithCopySourceEtagMatch() the current etag of the source is used.
+func (azp *Storer) Move(ctx context.Context, source string, destination string, opts ...Option) (*WriteResponse, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Infof("Move %s container '%s' to %s", source, options.copySourceContainer, destination)
+	if err := checkMoveOptions(azp.Container, source, destination, options); err != nil {
+		return nil, err
+	}
+	sourceClient, err := azp.copySourceClient(source, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the source can be deleted before changing anything
+	props, err := sourceClient.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if props.LeaseStatus != nil && *props.LeaseStatus == azStorageBlob.LeaseStatusTypeLocked {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseIDMissing, http.StatusPreconditionFailed,
+			fmt.Sprintf("blob %s has an active lease", source))
+	}
+	snapshots, err := azp.hasSnapshots(ctx, source, options)
+	if err != nil {
+		return nil, err
+	}
+	if snapshots {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeSnapshotsPresent, http.StatusConflict,
+			fmt.Sprintf("blob %s has snapshots", source))
+	}
+	etag := options.copySourceEtag
+	if etag == "" {
+		if props.ETag == nil {
+			return nil, fmt.Errorf("no etag for blob %s", source)
+		}
+		etag = *props.ETag
+	}
+
+	w, err := azp.Copy(ctx, source, destination, append(opts[:len(opts):len(opts)], WithCopySourceEtagMatch(etag))...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = sourceClient.Delete(ctx, &azStorageBlob.BlobDeleteOptions{
+		BlobAccessConditions: &azStorageBlob.BlobAccessConditions{
+			ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{
+				IfMatch: &etag,
+			},
+		},
+	})
+	if err != nil {
+		logger.Sugar.Infof("failed to delete %s after copy to %s: %v", source, destination, err)
+		azp.undoMoveCopy(ctx, destination, w, options)
+		return nil, ErrorFromError(err)
+	}
+	return w, nil
+}
+
+// undoMoveCopy deletes the destination of a move whose source couldn't be
+// deleted, unless it has been written since the copy
+func (azp *Storer) undoMoveCopy(ctx context.Context, destination string, w *WriteResponse, options *StorerOptions) {
+	if w.ETag == nil {
+		return
+	}
+	opts := []Option{WithEtagMatch(*w.ETag)}
+	if options.leaseID != "" {
+		opts = append(opts, WithLeaseID(options.leaseID))
+	}
+	if err := azp.Delete(ctx, destination, opts...); err != nil {
+		logger.Sugar.Infof("failed to delete %s after failed move: %v", destination, err)
+	}
+}
+
+// hasSnapshots returns true if the source of a copy has any snapshots
+func (azp *Storer) hasSnapshots(ctx context.Context, source string, options *StorerOptions) (bool, error) {
+	containerClient := azp.containerClient
+	if options.copySourceContainer != "" && options.copySourceContainer != azp.Container {
+		if azp.serviceClient == nil {
+			return false, errors.New("no service client available for copy")
+		}
+		var err error
+		containerClient, err = azp.serviceClient.NewContainerClient(options.copySourceContainer)
+		if err != nil {
+			return false, ErrorFromError(err)
+		}
+	}
+	if containerClient == nil {
+		return false, errors.New("no container client available for copy")
+	}
+	pager := containerClient.ListBlobsFlat(&azStorageBlob.ContainerListBlobsFlatOptions{
+		Prefix:  &source,
+		Include: []azStorageBlob.ListBlobsIncludeItem{azStorageBlob.ListBlobsIncludeItemSnapshots},
+	})
+	for pager.NextPage(ctx) {
+		for _, item := range pager.PageResponse().Segment.BlobItems {
+			if item.Name != nil && *item.Name == source && item.Snapshot != nil && *item.Snapshot != "" {
+				return true, nil
+			}
+		}
+	}
+	if err := pager.Err(); err != nil {
+		return false, ErrorFromError(err)
+	}
+	return false, nil
+}
+
+// checkMoveOptions rejects moves that would delete the blob just copied
+func checkMoveOptions(container string, source string, destination string, options *StorerOptions) error {
+	if options.snapshot != "" || options.versionID != "" {
+		return NewStatusError("a snapshot or version can't be moved", http.StatusBadRequest)
+	}
+	sameContainer := options.copySourceContainer == "" || options.copySourceContainer == container
+	if sameContainer && source == destination {
+		return NewStatusError(fmt.Sprintf("can't move blob %s to itself", source), http.StatusBadRequest)
+	}
+	return nil
+}
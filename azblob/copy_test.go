@@ -0,0 +1,392 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const blobServerPath = "/devstoreaccount1/devcontainer"
+
+// fakeBlob is a blob held by blobServer
+type fakeBlob struct {
+	data      []byte
+	etag      string
+	metadata  map[string]string
+	tags      map[string]string
+	leaseID   string
+	snapshots []string
+	deleted   bool
+}
+
+// blobServer emulates the blob operations used by Copy, Move, Delete,
+// Undelete and MarkScanned for the blobs of a single container. Leased blobs
+// have the lease id in fakeBlob.leaseID.
+type blobServer struct {
+	mu    sync.Mutex
+	seq   int
+	blobs map[string]*fakeBlob
+	// methods records the method of each request for a blob, eg. "PUT a"
+	methods []string
+	// leaseOnCopy acquires a lease on the named source when it is copied, as
+	// if by a concurrent caller
+	leaseOnCopy string
+}
+
+func newBlobServer() *blobServer {
+	return &blobServer{blobs: map[string]*fakeBlob{}}
+}
+
+// put adds a blob directly, returning its etag
+func (b *blobServer) put(name string, data string, metadata map[string]string, tags map[string]string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	blob := &fakeBlob{data: []byte(data), etag: b.nextETag(), metadata: metadata, tags: tags}
+	b.blobs[name] = blob
+	return blob.etag
+}
+
+// update changes a blob directly, eg. to lease it
+func (b *blobServer) update(name string, change func(blob *fakeBlob)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	change(b.blobs[name])
+}
+
+func (b *blobServer) blob(name string) *fakeBlob {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	blob, ok := b.blobs[name]
+	if !ok || blob.deleted {
+		return nil
+	}
+	c := *blob
+	return &c
+}
+
+func (b *blobServer) requests() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, b.methods...)
+}
+
+func (b *blobServer) nextETag() string {
+	b.seq++
+	return fmt.Sprintf("\"0x%X\"", b.seq)
+}
+
+func storageError(w http.ResponseWriter, code azStorageBlob.StorageErrorCode, status int) {
+	w.Header().Set(xMsErrorCodeHeader, string(code))
+	w.WriteHeader(status)
+}
+
+// checkConditions applies the etag, tags and lease conditions of the request
+// to blob, which is nil if it does not exist. It writes the error response
+// and returns false if they aren't met.
+func (b *blobServer) checkConditions(w http.ResponseWriter, r *http.Request, blob *fakeBlob, write bool) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (blob == nil || blob.etag != ifMatch) {
+		storageError(w, azStorageBlob.StorageErrorCodeConditionNotMet, http.StatusPreconditionFailed)
+		return false
+	}
+	if where := r.Header.Get("x-ms-if-tags"); where != "" {
+		filter, err := parseTagsFilter(where)
+		if err != nil || blob == nil || !filter.match("devcontainer", blob.tags) {
+			storageError(w, azStorageBlob.StorageErrorCodeConditionNotMet, http.StatusPreconditionFailed)
+			return false
+		}
+	}
+	if write && blob != nil && blob.leaseID != "" && r.Header.Get("x-ms-lease-id") != blob.leaseID {
+		storageError(w, azStorageBlob.StorageErrorCodeLeaseIDMissing, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func (b *blobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := r.URL.Query()
+	if r.URL.Path == blobServerPath && q.Get("comp") == "list" {
+		b.list(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, blobServerPath+"/")
+	b.methods = append(b.methods, r.Method+" "+name)
+	blob := b.blobs[name]
+	if r.Method == http.MethodPut && q.Get("comp") == "undelete" {
+		if blob == nil {
+			storageError(w, azStorageBlob.StorageErrorCodeBlobNotFound, http.StatusNotFound)
+			return
+		}
+		blob.deleted = false
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if blob != nil && blob.deleted {
+		blob = nil
+	}
+	if r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "" {
+		b.copy(w, r, name, blob)
+		return
+	}
+	if blob == nil {
+		storageError(w, azStorageBlob.StorageErrorCodeBlobNotFound, http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodHead:
+		if !b.checkConditions(w, r, blob, false) {
+			return
+		}
+		w.Header().Set("ETag", blob.etag)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob.data)))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.Header().Set("x-ms-lease-status", "unlocked")
+		if blob.leaseID != "" {
+			w.Header().Set("x-ms-lease-status", "locked")
+		}
+		for k, v := range blob.metadata {
+			w.Header().Set("x-ms-meta-"+k, v)
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && q.Get("comp") == "":
+		if !b.checkConditions(w, r, blob, false) {
+			return
+		}
+		b.download(w, r, blob)
+	case r.Method == http.MethodGet && q.Get("comp") == "tags":
+		keys := make([]string, 0, len(blob.tags))
+		for k := range blob.tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Tags><TagSet>`)
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", k, blob.tags[k])
+		}
+		fmt.Fprint(w, `</TagSet></Tags>`)
+	case r.Method == http.MethodDelete:
+		if !b.checkConditions(w, r, blob, true) {
+			return
+		}
+		if len(blob.snapshots) > 0 && r.Header.Get("x-ms-delete-snapshots") == "" {
+			storageError(w, azStorageBlob.StorageErrorCodeSnapshotsPresent, http.StatusConflict)
+			return
+		}
+		blob.snapshots = nil
+		blob.deleted = true
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// download writes the content of the blob, or the range of it in x-ms-range
+func (b *blobServer) download(w http.ResponseWriter, r *http.Request, blob *fakeBlob) {
+	data := blob.data
+	status := http.StatusOK
+	if rng := r.Header.Get("x-ms-range"); rng != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil || end >= len(data) || start > end {
+			storageError(w, azStorageBlob.StorageErrorCodeInvalidRange, http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("ETag", blob.etag)
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+func (b *blobServer) copy(w http.ResponseWriter, r *http.Request, name string, blob *fakeBlob) {
+	source, err := url.Parse(r.Header.Get("x-ms-copy-source"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sourceName := strings.TrimPrefix(source.Path, blobServerPath+"/")
+	src := b.blobs[sourceName]
+	if src == nil || src.deleted {
+		storageError(w, azStorageBlob.StorageErrorCodeBlobNotFound, http.StatusNotFound)
+		return
+	}
+	if ifMatch := r.Header.Get("x-ms-source-if-match"); ifMatch != "" && ifMatch != src.etag {
+		storageError(w, azStorageBlob.StorageErrorCodeSourceConditionNotMet, http.StatusPreconditionFailed)
+		return
+	}
+	if !b.checkConditions(w, r, blob, true) {
+		return
+	}
+
+	copied := &fakeBlob{data: src.data, etag: b.nextETag(), metadata: src.metadata}
+	if blob != nil {
+		copied.leaseID = blob.leaseID
+		copied.snapshots = blob.snapshots
+	}
+	metadata := map[string]string{}
+	for k := range r.Header {
+		if key, ok := strings.CutPrefix(strings.ToLower(k), "x-ms-meta-"); ok {
+			metadata[key] = r.Header.Get(k)
+		}
+	}
+	if len(metadata) > 0 {
+		copied.metadata = metadata
+	}
+	tags, err := url.ParseQuery(r.Header.Get("x-ms-tags"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	copied.tags = map[string]string{}
+	for k := range tags {
+		copied.tags[k] = tags.Get(k)
+	}
+	b.blobs[name] = copied
+	if sourceName == b.leaseOnCopy {
+		src.leaseID = "concurrent"
+	}
+
+	w.Header().Set("ETag", copied.etag)
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	w.Header().Set("x-ms-copy-id", fmt.Sprintf("copy-%d", b.seq))
+	w.Header().Set("x-ms-copy-status", string(azStorageBlob.CopyStatusTypeSuccess))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (b *blobServer) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	names := make([]string, 0, len(b.blobs))
+	for name, blob := range b.blobs {
+		if !blob.deleted && strings.HasPrefix(name, q.Get("prefix")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	lastModified := time.Now().UTC().Format(http.TimeFormat)
+	item := func(name string, snapshot string, blob *fakeBlob) {
+		fmt.Fprintf(w, "<Blob><Name>%s</Name>", name)
+		if snapshot != "" {
+			fmt.Fprintf(w, "<Snapshot>%s</Snapshot>", snapshot)
+		}
+		fmt.Fprintf(w, "<Properties><Last-Modified>%s</Last-Modified><Etag>%s</Etag>"+
+			"<Content-Length>%d</Content-Length><BlobType>BlockBlob</BlobType></Properties></Blob>",
+			lastModified, blob.etag, len(blob.data))
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="http://%s" ContainerName="devcontainer"><Blobs>`, r.Host)
+	for _, name := range names {
+		blob := b.blobs[name]
+		if strings.Contains(q.Get("include"), "snapshots") {
+			for _, snapshot := range blob.snapshots {
+				item(name, snapshot, blob)
+			}
+		}
+		item(name, "", blob)
+	}
+	fmt.Fprint(w, `</Blobs><NextMarker/></EnumerationResults>`)
+}
+
+func TestStorerCopy(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := newBlobServer()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+	ctx := context.Background()
+
+	etag := fake.put("a", "VALUE", map[string]string{"owner": "alice"}, map[string]string{"kind": "evidence"})
+
+	w, err := azp.Copy(ctx, "a", "b", WithCopySourceEtagMatch(etag))
+	require.NoError(t, err)
+	assert.NotEmpty(t, w.CopyID)
+	b := fake.blob("b")
+	require.NotNil(t, b)
+	assert.Equal(t, "VALUE", string(b.data))
+	assert.Equal(t, map[string]string{"owner": "alice"}, b.metadata)
+	assert.Equal(t, map[string]string{"kind": "evidence"}, b.tags, "the tags are copied explicitly")
+
+	_, err = azp.Copy(ctx, "a", "c", WithMetadata(map[string]string{"owner": "bob"}), WithTags(map[string]string{}))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "bob"}, fake.blob("c").metadata)
+	assert.Empty(t, fake.blob("c").tags)
+
+	_, err = azp.Copy(ctx, "a", "d", WithCopySourceEtagMatch("\"0xFF\""))
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	_, err = azp.Copy(ctx, "a", "b", WithEtagMatch("\"0xFF\""))
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	_, err = azp.Copy(ctx, "missing", "d")
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+	assert.Nil(t, fake.blob("d"))
+}
+
+func TestStorerMove(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := newBlobServer()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+	ctx := context.Background()
+
+	fake.put("a", "VALUE", nil, map[string]string{"kind": "evidence"})
+	_, err := azp.Move(ctx, "a", "b")
+	require.NoError(t, err)
+	assert.Nil(t, fake.blob("a"))
+	require.NotNil(t, fake.blob("b"))
+	assert.Equal(t, map[string]string{"kind": "evidence"}, fake.blob("b").tags)
+
+	_, err = azp.Move(ctx, "b", "b")
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+	// leased and snapshotted sources are refused before anything is copied
+	fake.put("leased", "VALUE", nil, nil)
+	fake.update("leased", func(blob *fakeBlob) { blob.leaseID = "lease" })
+	fake.put("snapshotted", "VALUE", nil, nil)
+	fake.update("snapshotted", func(blob *fakeBlob) { blob.snapshots = []string{"2024-01-01T00:00:00.0000000Z"} })
+	fake.put("snapshotted-other", "VALUE", nil, nil)
+
+	_, err = azp.Move(ctx, "leased", "c")
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	assert.Equal(t, string(azStorageBlob.StorageErrorCodeLeaseIDMissing), ErrorFromError(err).StorageErrorCode())
+	_, err = azp.Move(ctx, "snapshotted", "c")
+	assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+	assert.Equal(t, string(azStorageBlob.StorageErrorCodeSnapshotsPresent), ErrorFromError(err).StorageErrorCode())
+	assert.Nil(t, fake.blob("c"))
+	assert.NotContains(t, fake.requests(), "PUT c")
+
+	_, err = azp.Move(ctx, "snapshotted-other", "c")
+	require.NoError(t, err, "the snapshots of another blob with the prefix don't matter")
+
+	// if the source can't be deleted after the copy, the copy is deleted
+	fake.put("contended", "VALUE", nil, nil)
+	fake.mu.Lock()
+	fake.leaseOnCopy = "contended"
+	fake.mu.Unlock()
+	_, err = azp.Move(ctx, "contended", "d")
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	assert.NotNil(t, fake.blob("contended"))
+	assert.Nil(t, fake.blob("d"))
+	assert.Contains(t, fake.requests(), "DELETE d")
+}
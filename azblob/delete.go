@@ -3,25 +3,76 @@ package azblob
 import (
 	"context"
 	"errors"
+	"net/http"
 
 	msazblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/datatrails/go-datatrails-common/logger"
 )
 
-// Delete the identified blob
+// checkDeleteOptions rejects a snapshots policy for the delete of a single
+// snapshot or version, which azure does not allow
+func checkDeleteOptions(options *StorerOptions) error {
+	if options.deleteSnapshots != DeleteSnapshotsNone && (options.snapshot != "" || options.versionID != "") {
+		return NewStatusError("a snapshots policy can't be used to delete a snapshot or version", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// deleteSnapshotsOption returns the sdk value for the snapshots policy, nil
+// for DeleteSnapshotsNone
+func deleteSnapshotsOption(policy DeleteSnapshots) *msazblob.DeleteSnapshotsOptionType {
+	var value msazblob.DeleteSnapshotsOptionType
+	switch policy {
+	case DeleteSnapshotsInclude:
+		value = msazblob.DeleteSnapshotsOptionTypeInclude
+	case DeleteSnapshotsOnly:
+		value = msazblob.DeleteSnapshotsOptionTypeOnly
+	default:
+		return nil
+	}
+	return &value
+}
+
+// Delete the identified blob. A blob that does not exist is not an error.
+//
+// The conditions are applied by azure as part of the delete, so a delete
+// conditional on the etag can't remove a blob written since it was read.
+//
+// Options:
+//
+//	WithEtagMatch() etc. - the usual access conditions
+//	WithLeaseID() - required if the blob has an active lease
+//	WithDeleteSnapshots() - required if the blob has snapshots
+//	WithSnapshot() or WithVersionID() - delete just that snapshot or version
 func (azp *Storer) Delete(
 	ctx context.Context,
 	identity string,
+	opts ...Option,
 ) error {
 	logger.Sugar.Debugf("Delete blob %s", identity)
 
-	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(identity)
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkDeleteOptions(options); err != nil {
+		return err
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
 	if err != nil {
-		logger.Sugar.Infof("Cannot get block blob client blob: %v", err)
-		return ErrorFromError(err)
+		return err
+	}
+
+	blobClient, err := azp.blobClient(identity, options)
+	if err != nil {
+		logger.Sugar.Infof("Cannot get blob client blob: %v", err)
+		return err
 	}
 
-	_, err = blockBlobClient.Delete(ctx, nil)
+	_, err = blobClient.Delete(ctx, &msazblob.BlobDeleteOptions{
+		DeleteSnapshots:      deleteSnapshotsOption(options.deleteSnapshots),
+		BlobAccessConditions: &blobAccessConditions,
+	})
 	var terr *msazblob.StorageError
 	if errors.As(err, &terr) {
 		resp := terr.Response()
@@ -35,3 +86,20 @@ func (azp *Storer) Delete(
 
 	return err
 }
+
+// Undelete restores the soft deleted blob, and its soft deleted snapshots,
+// within the retention period of the storage account. It is not an error if
+// the blob was not deleted.
+func (azp *Storer) Undelete(ctx context.Context, identity string) error {
+	logger.Sugar.Infof("Undelete blob %s", identity)
+
+	blobClient, err := azp.blobClient(identity, &StorerOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = blobClient.Undelete(ctx, nil)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
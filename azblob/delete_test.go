@@ -0,0 +1,51 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestStorerDeleteUndelete(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := newBlobServer()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+	ctx := context.Background()
+
+	require.NoError(t, azp.Delete(ctx, "missing"), "a missing blob is not an error")
+
+	etag := fake.put("a", "VALUE", nil, nil)
+	err := azp.Delete(ctx, "a", WithEtagMatch("\"0xFF\""))
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	require.NoError(t, azp.Delete(ctx, "a", WithEtagMatch(etag)))
+	assert.Nil(t, fake.blob("a"))
+
+	require.NoError(t, azp.Undelete(ctx, "a"))
+	assert.NotNil(t, fake.blob("a"))
+	require.NoError(t, azp.Undelete(ctx, "a"), "a blob that isn't deleted is not an error")
+
+	fake.update("a", func(blob *fakeBlob) { blob.leaseID = "lease" })
+	err = azp.Delete(ctx, "a")
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	require.NoError(t, azp.Delete(ctx, "a", WithLeaseID("lease")))
+
+	fake.put("b", "VALUE", nil, nil)
+	fake.update("b", func(blob *fakeBlob) { blob.snapshots = []string{"2024-01-01T00:00:00.0000000Z"} })
+	err = azp.Delete(ctx, "b")
+	assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+	require.NoError(t, azp.Delete(ctx, "b", WithDeleteSnapshots(DeleteSnapshotsInclude)))
+	assert.Nil(t, fake.blob("b"))
+
+	err = azp.Delete(ctx, "b", WithSnapshot("2024-01-01T00:00:00.0000000Z"), WithDeleteSnapshots(DeleteSnapshotsOnly))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
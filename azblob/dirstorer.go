@@ -0,0 +1,158 @@
+package azblob
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	dirStorerDataExt  = ".data"
+	dirStorerPropsExt = ".json"
+)
+
+// DirStorer implements Store using a directory on the local filesystem. It
+// honours the same etag, lease, metadata and tag semantics as the azure
+// Storer, and is intended for tests and local tooling.
+//
+// Each blob is stored as two files in root/container. The content is in
+// <name>.data and the etag, metadata, tags and lease state are in
+// <name>.json, where name is the query escaped blob identity. Access is
+// serialised within a process only; sharing a directory between processes is
+// not supported.
+type DirStorer struct {
+	*localStorer
+	Dir string
+}
+
+// NewDirStorer returns a store for the named container, creating the
+// directory root/container if necessary.
+func NewDirStorer(root string, container string) (*DirStorer, error) {
+	if container == "" {
+		return nil, ErrUnspecifiedContainer
+	}
+	dir := filepath.Join(root, container)
+	logger.Sugar.Debugf("New DirStorer: %s", dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &DirStorer{
+		localStorer: newLocalStorer(container, &dirRecords{dir: dir}),
+		Dir:         dir,
+	}
+	// the other containers are the sibling directories
+	s.containerRecords = func(container string) (localRecords, error) {
+		dir := filepath.Join(root, container)
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			return nil, localContainerNotFound(container)
+		}
+		return &dirRecords{dir: dir}, nil
+	}
+	return s, nil
+}
+
+type dirRecords struct {
+	dir string
+}
+
+func (d *dirRecords) path(identity string, ext string) string {
+	return filepath.Join(d.dir, url.QueryEscape(identity)+ext)
+}
+
+func (d *dirRecords) load(identity string, withData bool) (*localBlob, error) {
+	props, err := os.ReadFile(d.path(identity, dirStorerPropsExt))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blob := &localBlob{}
+	if err = json.Unmarshal(props, blob); err != nil {
+		return nil, fmt.Errorf("corrupt properties for blob %s: %w", identity, err)
+	}
+	if !withData {
+		return blob, nil
+	}
+	blob.Data, err = os.ReadFile(d.path(identity, dirStorerDataExt))
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// store writes the content before the properties, the properties file is what
+// makes the blob exist.
+func (d *dirRecords) store(identity string, blob *localBlob) error {
+	props, err := json.Marshal(blob)
+	if err != nil {
+		return err
+	}
+	if blob.Data != nil {
+		if err = d.writeFile(d.path(identity, dirStorerDataExt), blob.Data); err != nil {
+			return err
+		}
+	}
+	return d.writeFile(d.path(identity, dirStorerPropsExt), props)
+}
+
+// writeFile replaces the file atomically
+func (d *dirRecords) writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (d *dirRecords) remove(identity string) error {
+	err := os.Remove(d.path(identity, dirStorerPropsExt))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = os.Remove(d.path(identity, dirStorerDataExt))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *dirRecords) names() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		escaped, ok := strings.CutSuffix(entry.Name(), dirStorerPropsExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		name, err := url.QueryUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -12,6 +12,11 @@ import (
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 )
 
+const (
+	// CountToEnd specifies, with WithRange, that the range extends to the end of the blob
+	CountToEnd = azStorageBlob.CountToEnd
+)
+
 const (
 	// metadata keys
 	ContentKey = "content_type"
@@ -19,21 +24,29 @@ const (
 	MimeKey    = "mime_type"
 	SizeKey    = "size"
 	TimeKey    = "time_accepted"
+
+	// EscapedKey marks the content metadata of blobs written with the values
+	// escaped, see ContentMetadata
+	EscapedKey = "metadata_escaped"
 )
 
 // getTags gets tags from blob storage
 func (azp *Storer) getTags(
 	ctx context.Context,
 	identity string,
+	options *StorerOptions,
 ) (map[string]string, error) {
 
 	var err error
 
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	blobClient, err := azp.blobClient(identity, options)
 	if err != nil {
 		return nil, ErrorFromError(err)
 	}
+	return blobTags(ctx, blobClient)
+}
 
+func blobTags(ctx context.Context, blobClient *azStorageBlob.BlobClient) (map[string]string, error) {
 	resp, err := blobClient.GetTags(ctx, nil)
 	if err != nil {
 		return nil, ErrorFromError(err)
@@ -45,23 +58,24 @@ func (azp *Storer) getTags(
 	return tags, nil
 }
 
-// getMetadata gets metadata from blob storage
-func (azp *Storer) getMetadata(
+// getProperties gets the metadata and other properties from blob storage
+func (azp *Storer) getProperties(
 	ctx context.Context,
 	identity string,
-) (map[string]string, error) {
+	options *StorerOptions,
+) (azStorageBlob.BlobGetPropertiesResponse, error) {
 
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	blobClient, err := azp.blobClient(identity, options)
 	if err != nil {
-		return nil, ErrorFromError(err)
+		return azStorageBlob.BlobGetPropertiesResponse{}, ErrorFromError(err)
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	resp, err := blobClient.GetProperties(ctx, nil)
 	if err != nil {
-		return nil, ErrorFromError(err)
+		return azStorageBlob.BlobGetPropertiesResponse{}, ErrorFromError(err)
 	}
-	return resp.Metadata, nil
+	return resp, nil
 }
 
 // Reader creates a reader.
@@ -77,6 +91,9 @@ func (azp *Storer) Reader(
 	for _, opt := range opts {
 		opt(options)
 	}
+	if err = checkRangeOptions(options); err != nil {
+		return nil, err
+	}
 
 	resp := &ReaderResponse{setReadResponseScannedStatus: azp.setReadResponseScannedStatus}
 	blobAccessConditions, err := storerOptionConditions(options)
@@ -88,6 +105,7 @@ func (azp *Storer) Reader(
 		tags, tagsErr := azp.getTags(
 			ctx,
 			identity,
+			options,
 		)
 		if tagsErr != nil {
 			return nil, tagsErr
@@ -98,27 +116,26 @@ func (azp *Storer) Reader(
 	// XXX: TODO this should be done with access conditions. this is racy as it
 	// stands. azure guarantees the tags for a blob read after write is
 	// consistent. we can't take advantage of that while this remains racy.
-	for k, requiredValue := range options.tags {
-		blobValue, ok := resp.Tags[k]
-		if !ok {
-			return nil, NewStatusError(fmt.Sprintf("tag %s is not specified on blob", k), http.StatusNotFound)
-		}
-		if blobValue != requiredValue {
-			return nil, NewStatusError(fmt.Sprintf("blob has different Tag %s than required %s", blobValue, requiredValue), http.StatusNotFound)
-		}
+	if err = checkRequiredTags(resp.Tags, options.tags); err != nil {
+		return nil, err
 	}
 
 	// If we are *only* getting metadata, issue a distinct request. Otherwise we
 	// get it from the download response.
 	if options.getMetadata == OnlyMetadata {
-		metaData, metadataErr := azp.getMetadata(
+		props, metadataErr := azp.getProperties(
 			ctx,
 			identity,
+			options,
 		)
 		if metadataErr != nil {
 			return nil, metadataErr
 		}
-		if parseErr := readerResponseMetadata(resp, metaData); parseErr != nil {
+		propertiesTier(props, resp)
+		if parseErr := readerResponseMetadata(resp, props.Metadata); parseErr != nil {
+			return nil, parseErr
+		}
+		if err = typedReaderMetadata(resp, options); err != nil {
 			return nil, err
 		}
 	}
@@ -133,16 +150,16 @@ func (azp *Storer) Reader(
 		return nil, errors.New("no container client available for reader")
 	}
 
-	resp.BlobClient, err = azp.containerClient.NewBlobClient(identity)
+	resp.BlobClient, err = azp.blobClient(identity, options)
 	if err != nil {
 		return nil, ErrorFromError(err)
 	}
-	countToEnd := int64(azStorageBlob.CountToEnd)
 	get, err := resp.BlobClient.Download(
 		ctx,
 		&azStorageBlob.BlobDownloadOptions{
 			BlobAccessConditions: &blobAccessConditions,
-			Count:                &countToEnd,
+			Offset:               &options.rangeOffset,
+			Count:                &options.rangeCount,
 		},
 	)
 
@@ -157,19 +174,68 @@ func (azp *Storer) Reader(
 		if err != nil {
 			return resp, err
 		}
+		if resp.Ok() {
+			// checked against the metadata of the content being read
+			if err = checkScanState(identity, resp.Metadata, options); err != nil {
+				get.RawResponse.Body.Close()
+				return nil, err
+			}
+		}
 
 		// for backwards compat, we only process the metadata on request
 		if options.getMetadata == BothMetadataAndBlob {
 			_ = readerResponseMetadata(resp, resp.Metadata) // the parse error is benign
+			if err = typedReaderMetadata(resp, options); err != nil {
+				get.RawResponse.Body.Close()
+				return nil, err
+			}
 		}
 	}
 
 	if get.RawResponse != nil {
-		resp.Reader = get.Body(nil)
+		// with the default of zero retries this is the raw response body
+		resp.Reader = get.Body(&azStorageBlob.RetryReaderOptions{
+			MaxRetryRequests: options.readRetries,
+		})
+		if options.verifyHash && resp.StatusCode == http.StatusOK {
+			verifyReaderResponse(identity, resp)
+		}
 	}
 	return resp, err
 }
 
+// checkRequiredTags returns a 404 error unless tags has each of the required
+// tags, see WithTags
+func checkRequiredTags(tags map[string]string, required map[string]string) error {
+	for k, requiredValue := range required {
+		blobValue, ok := tags[k]
+		if !ok {
+			return NewStatusError(fmt.Sprintf("tag %s is not specified on blob", k), http.StatusNotFound)
+		}
+		if blobValue != requiredValue {
+			return NewStatusError(fmt.Sprintf("blob has different Tag %s than required %s", blobValue, requiredValue), http.StatusNotFound)
+		}
+	}
+	return nil
+}
+
+// checkRangeOptions validates the WithRange and WithResumable options
+func checkRangeOptions(options *StorerOptions) error {
+	if options.rangeOffset < 0 || options.rangeCount < 0 {
+		return NewStatusError(
+			fmt.Sprintf("invalid range offset %d count %d", options.rangeOffset, options.rangeCount),
+			http.StatusBadRequest)
+	}
+	if options.verifyHash && (options.rangeOffset != 0 || options.rangeCount != CountToEnd) {
+		return NewStatusError("the hash can't be verified for a range", http.StatusBadRequest)
+	}
+	if options.readRetries < 0 {
+		return NewStatusError(
+			fmt.Sprintf("invalid read retries %d", options.readRetries), http.StatusBadRequest)
+	}
+	return nil
+}
+
 func (r *ReaderResponse) DownloadToWriter(w io.Writer) error {
 	defer r.Reader.Close()
 	_, err := io.Copy(w, r.Reader)
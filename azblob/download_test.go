@@ -0,0 +1,92 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// breakingServer serves a single blob, breaking the body of the first
+// download part way through
+type breakingServer struct {
+	content []byte
+	etag    string
+
+	mu       sync.Mutex
+	requests []http.Header
+}
+
+func (b *breakingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	b.requests = append(b.requests, r.Header.Clone())
+	first := len(b.requests) == 1
+	b.mu.Unlock()
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != b.etag {
+		storageError(w, "ConditionNotMet", http.StatusPreconditionFailed)
+		return
+	}
+	offset := 0
+	status := http.StatusOK
+	if rng := r.Header.Get("x-ms-range"); rng != "" {
+		start, _, _ := strings.Cut(strings.TrimPrefix(rng, "bytes="), "-")
+		offset, _ = strconv.Atoi(start)
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(b.content)-1, len(b.content)))
+	}
+	body := b.content[offset:]
+	w.Header().Set("ETag", b.etag)
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if !first {
+		_, _ = w.Write(body)
+		return
+	}
+	_, _ = w.Write(body[:len(body)/2])
+	w.(http.Flusher).Flush()
+	// drop the connection with the body incomplete
+	panic(http.ErrAbortHandler)
+}
+
+func TestStorerReaderResumable(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := &breakingServer{content: []byte(strings.Repeat("0123456789", 1000)), etag: "\"0x1\""}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+
+	rr, err := azp.Reader(context.Background(), "evidence", WithResumable(2))
+	require.NoError(t, err)
+	data, err := io.ReadAll(rr.Reader)
+	require.NoError(t, err)
+	require.NoError(t, rr.Reader.Close())
+	assert.Equal(t, fake.content, data)
+
+	require.Len(t, fake.requests, 2)
+	assert.Empty(t, fake.requests[0].Get("If-Match"))
+	assert.Equal(t, fake.etag, fake.requests[1].Get("If-Match"))
+	assert.Equal(t, fmt.Sprintf("bytes=%d-", len(fake.content)/2), fake.requests[1].Get("x-ms-range"))
+
+	// without retries the break is returned to the caller
+	fake.requests = nil
+	rr, err = azp.Reader(context.Background(), "evidence")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rr.Reader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Len(t, fake.requests, 1)
+}
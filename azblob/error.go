@@ -20,6 +20,7 @@ type HTTPError interface {
 type Error struct {
 	err        error
 	statusCode int
+	errorCode  azStorageBlob.StorageErrorCode
 }
 
 func NewStatusError(text string, statusCode int) *Error {
@@ -29,6 +30,18 @@ func NewStatusError(text string, statusCode int) *Error {
 	}
 }
 
+// newStorageCodeError returns an error carrying both a status code and an
+// azure storage error code. It is used by the backends that are not azure
+// so that callers can check the result in the same way regardless of the
+// storage in use.
+func newStorageCodeError(code azStorageBlob.StorageErrorCode, statusCode int, text string) *Error {
+	return &Error{
+		err:        errors.New(text),
+		statusCode: statusCode,
+		errorCode:  code,
+	}
+}
+
 func ErrorFromError(err error) *Error {
 	return &Error{err: err}
 }
@@ -56,6 +69,10 @@ func (e *Error) StatusCode() int {
 		logger.Sugar.Debugf("Return statusCode %d", e.statusCode)
 		return e.statusCode
 	}
+	var lerr *Error
+	if errors.As(e.err, &lerr) {
+		return lerr.StatusCode()
+	}
 	logger.Sugar.Debugf("Return InternalServerError")
 	return http.StatusInternalServerError
 }
@@ -68,6 +85,13 @@ func (e *Error) StorageErrorCode() string {
 			return string(terr.ErrorCode)
 		}
 	}
+	if e.errorCode != "" {
+		return string(e.errorCode)
+	}
+	var lerr *Error
+	if errors.As(e.err, &lerr) {
+		return lerr.StorageErrorCode()
+	}
 	return ""
 }
 
@@ -76,3 +100,11 @@ func (e *Error) StorageErrorCode() string {
 func (e *Error) IsConditionNotMet() bool {
 	return e.StorageErrorCode() == string(azStorageBlob.StorageErrorCodeConditionNotMet)
 }
+
+// IsAppendConditionNotMet returns true if the err is the storage code
+// indicating that an append position or max size condition was not met
+func (e *Error) IsAppendConditionNotMet() bool {
+	code := azStorageBlob.StorageErrorCode(e.StorageErrorCode())
+	return code == azStorageBlob.StorageErrorCodeAppendPositionConditionNotMet ||
+		code == azStorageBlob.StorageErrorCodeMaxBlobSizeConditionNotMet
+}
@@ -3,6 +3,7 @@
 package azblob
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"testing"
@@ -154,3 +155,42 @@ func Test_ReadIfNoneMatch(t *testing.T) {
 		t.Fatalf("failed put original value: %v", err)
 	}
 }
+
+// TestWriteIfMatch checks the handling of WithEtagMatch on the streaming Write path
+func TestWriteIfMatch(t *testing.T) {
+
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	testName := uniqueTestName("WriteIfMatch", t)
+
+	storer, err := NewDev(NewDevConfigFromEnv(), "devcontainer")
+	if err != nil {
+		t.Fatalf("failed to connect to blob store emulator: %v", err)
+	}
+	client := storer.GetServiceClient()
+	// This will error if it exists and that is fine
+	_, _ = client.CreateContainer(context.Background(), "devcontainer", nil)
+
+	blobName := fmt.Sprintf("tests/blobs/%s-%d", testName, 1)
+
+	wr, err := storer.Write(context.Background(), blobName, bytes.NewReader([]byte("ORIGINAL_VALUE")))
+	if err != nil {
+		t.Fatalf("failed write original value: %v", err)
+	}
+
+	_, err = storer.Write(
+		context.Background(), blobName, bytes.NewReader([]byte("SECOND_VALUE")), WithEtagMatch(*wr.ETag))
+	if err != nil {
+		t.Fatalf("failed write second value: %v", err)
+	}
+
+	wr3, err := storer.Write(
+		context.Background(), blobName, bytes.NewReader([]byte("THIRD_VALUE")), WithEtagMatch(*wr.ETag))
+	if err == nil {
+		t.Fatalf("overwrote second value with wrong etag")
+	}
+	if !ErrorFromError(err).IsConditionNotMet() || !wr3.ConditionNotMet() {
+		t.Fatalf("expected ConditionNotMet err, got: %v", err)
+	}
+}
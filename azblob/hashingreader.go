@@ -1,8 +1,12 @@
 package azblob
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"hash"
 	"io"
+	"net/textproto"
 
 	"github.com/datatrails/go-datatrails-common/logger"
 )
@@ -39,3 +43,50 @@ func (up *hashingReader) Read(bytes []byte) (int, error) {
 	}
 	return length, nil
 }
+
+// verifyingReader checks the content read against the expected hex sha256
+// once the underlying reader is exhausted.
+type verifyingReader struct {
+	hashingReader
+	identity string
+	expected string
+	closer   io.Closer
+}
+
+// verifyReaderResponse replaces the response Reader with one that verifies the
+// content against the hash metadata. It does nothing if the blob has no hash
+// metadata.
+func verifyReaderResponse(identity string, resp *ReaderResponse) {
+	expected := resp.Metadata[textproto.CanonicalMIMEHeaderKey(HashKey)]
+	if expected == "" || resp.Reader == nil {
+		logger.Sugar.Debugf("no hash to verify for %s", identity)
+		return
+	}
+	resp.Reader = &verifyingReader{
+		hashingReader: hashingReader{
+			hasher: sha256.New(),
+			part:   resp.Reader,
+		},
+		identity: identity,
+		expected: expected,
+		closer:   resp.Reader,
+	}
+}
+
+func (vr *verifyingReader) Read(bytes []byte) (int, error) {
+	length, err := vr.hashingReader.Read(bytes)
+	if err != io.EOF { //nolint https://github.com/golang/go/issues/39155
+		return length, err
+	}
+	var h [sha256.Size]byte
+	vr.hasher.Sum(h[:0])
+	if hex.EncodeToString(h[:]) != vr.expected {
+		logger.Sugar.Infof("hash mismatch for %s after %d bytes", vr.identity, vr.size)
+		return length, ErrorFromError(fmt.Errorf("%w: blob %s", ErrHashMismatch, vr.identity))
+	}
+	return length, err
+}
+
+func (vr *verifyingReader) Close() error {
+	return vr.closer.Close()
+}
@@ -1,14 +1,17 @@
 package azblob
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/datatrails/go-datatrails-common/logger"
 )
@@ -51,3 +54,38 @@ func TestHashingReader(t *testing.T) {
 		hex.EncodeToString(h[:]), "30dff912c17003a5122f09c9a0320a4077614e8b6f107c795c9792b7d963544d",
 	)
 }
+
+func TestLocalStorerVerifyHash(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	store := NewMemStorer("devcontainer")
+	content := []byte("Spam, spam, spam, egg and spam")
+	sum := sha256.Sum256(content)
+
+	_, err := store.Put(ctx, "good", NewBytesReaderCloser(content),
+		WithMetadata(map[string]string{HashKey: hex.EncodeToString(sum[:])}))
+	require.NoError(t, err)
+	_, err = store.Put(ctx, "bad", NewBytesReaderCloser(content),
+		WithMetadata(map[string]string{HashKey: "not the hash"}))
+	require.NoError(t, err)
+	_, err = store.Put(ctx, "none", NewBytesReaderCloser(content))
+	require.NoError(t, err)
+
+	for _, identity := range []string{"good", "none"} {
+		rr, err := store.Reader(ctx, identity, WithVerifyHash())
+		require.NoError(t, err)
+		assert.Equal(t, content, readAll(t, rr))
+	}
+
+	rr, err := store.Reader(ctx, "bad", WithVerifyHash())
+	require.NoError(t, err)
+	data, err := io.ReadAll(rr.Reader)
+	assert.ErrorIs(t, err, ErrHashMismatch)
+	assert.Equal(t, content, data)
+	assert.NoError(t, rr.Reader.Close())
+
+	_, err = store.Reader(ctx, "good", WithVerifyHash(), WithRange(1, 2))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
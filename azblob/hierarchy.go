@@ -0,0 +1,155 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// DefaultListDelim is the virtual directory separator used by Walk and Tree
+	// if WithListDelim() is not specified
+	DefaultListDelim = "/"
+)
+
+var (
+	// SkipDir may be returned by a WalkFunc for a virtual directory to skip
+	// everything below it. As for filepath.WalkDir, returned for a blob it
+	// skips the rest of the directory containing the blob. It is not
+	// returned as an error by Walk.
+	SkipDir = errors.New("skip this directory")
+)
+
+// WalkFunc is called by Walk for each virtual directory and blob. For a
+// virtual directory, name ends with the delimiter and item is nil.
+type WalkFunc func(name string, item *azStorageBlob.BlobItemInternal) error
+
+// Walk visits the hierarchy of virtual directories below prefix, depth first
+// and in lexical order, calling fn for each directory before its contents.
+// Each level is listed with the delimiter, so directories that fn skips are
+// never listed.
+//
+// Options:
+//
+//	WithListDelim() - the directory separator, default DefaultListDelim
+//	WithListMaxResults() - the page size for each level
+//	WithListTags(), WithListMetadata() - included in each blob item
+//
+// If fn returns an error other than SkipDir the walk stops and Walk returns it.
+//
+// example, visiting each tenant without listing their blobs:
+//
+//	err := azblob.Walk(ctx, store, "tenant/", func(name string, item *azStorageBlob.BlobItemInternal) error {
+//		if item == nil {
+//			fmt.Println(name)
+//			return azblob.SkipDir
+//		}
+//		return nil
+//	})
+func Walk(ctx context.Context, r Reader, prefix string, fn WalkFunc, opts ...Option) error {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	delim := options.listDelim
+	if delim == "" {
+		delim = DefaultListDelim
+	}
+	// the level options override any prefix, delim or marker in opts
+	levelOpts := append(opts[:len(opts):len(opts)], WithListDelim(delim), WithListMarker(nil))
+	return walk(ctx, r, prefix, fn, levelOpts)
+}
+
+func walk(ctx context.Context, r Reader, prefix string, fn WalkFunc, opts []Option) error {
+	var err error
+	allPages(ctx, append(opts[:len(opts):len(opts)], WithListPrefix(prefix)), func(opts []Option) ([]walkEntry, ListMarker, error) {
+		resp, err := r.List(ctx, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return walkEntries(resp), resp.Marker, nil
+	}, func(entry walkEntry, pageErr error) bool {
+		if pageErr != nil {
+			err = pageErr
+			return false
+		}
+		if entry.item != nil {
+			err = fn(entry.name, entry.item)
+			if errors.Is(err, SkipDir) {
+				err = nil
+				return false
+			}
+			return err == nil
+		}
+		err = fn(entry.name, nil)
+		if errors.Is(err, SkipDir) {
+			err = nil
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		err = walk(ctx, r, entry.name, fn, opts)
+		return err == nil
+	})
+	return err
+}
+
+type walkEntry struct {
+	name string
+	item *azStorageBlob.BlobItemInternal // nil for a directory
+}
+
+// walkEntries merges the blobs and directories in a page into lexical order
+func walkEntries(resp *ListerResponse) []walkEntry {
+	entries := make([]walkEntry, 0, len(resp.Items)+len(resp.Prefixes))
+	items, prefixes := resp.Items, resp.Prefixes
+	for len(items) > 0 || len(prefixes) > 0 {
+		if len(prefixes) == 0 || (len(items) > 0 && *items[0].Name < *prefixes[0].Name) {
+			entries = append(entries, walkEntry{name: *items[0].Name, item: items[0]})
+			items = items[1:]
+			continue
+		}
+		entries = append(entries, walkEntry{name: *prefixes[0].Name})
+		prefixes = prefixes[1:]
+	}
+	return entries
+}
+
+// Tree returns the virtual directories below prefix, in the order Walk visits
+// them, down to maxDepth levels. A maxDepth of 1 returns only the immediate
+// sub directories of prefix, 0 or less returns all of them. The options are as
+// for Walk.
+//
+// example, the ids of all the tenants:
+//
+//	dirs, err := azblob.Tree(ctx, store, "tenant/", 1)
+func Tree(ctx context.Context, r Reader, prefix string, maxDepth int, opts ...Option) ([]string, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	delim := options.listDelim
+	if delim == "" {
+		delim = DefaultListDelim
+	}
+
+	var dirs []string
+	err := Walk(ctx, r, prefix, func(name string, item *azStorageBlob.BlobItemInternal) error {
+		if item != nil {
+			return nil
+		}
+		dirs = append(dirs, name)
+		depth := strings.Count(name[len(prefix):], delim)
+		if maxDepth > 0 && depth >= maxDepth {
+			return SkipDir
+		}
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
@@ -0,0 +1,102 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorerHierarchy(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for _, blob := range []string{
+				"tenant/1/massifs/0",
+				"tenant/1/massifs/1",
+				"tenant/1/seals/0",
+				"tenant/2/massifs/0",
+				"tenant/index",
+				"tenant.txt",
+				"other",
+			} {
+				_, err := store.Write(ctx, blob, bytes.NewReader([]byte(blob)))
+				require.NoError(t, err)
+			}
+
+			r, err := store.List(ctx, WithListPrefix("tenant/"), WithListDelim("/"))
+			require.NoError(t, err)
+			require.Len(t, r.Items, 1)
+			assert.Equal(t, "tenant/index", *r.Items[0].Name)
+			require.Len(t, r.Prefixes, 2)
+			assert.Equal(t, "tenant/1/", *r.Prefixes[0].Name)
+			assert.Equal(t, "tenant/2/", *r.Prefixes[1].Name)
+
+			// the directories count towards the page size
+			r, err = store.List(ctx, WithListPrefix("tenant/"), WithListDelim("/"), WithListMaxResults(1))
+			require.NoError(t, err)
+			assert.Len(t, r.Prefixes, 1)
+			assert.Empty(t, r.Items)
+			require.NotNil(t, r.Marker)
+
+			var visited []string
+			err = Walk(ctx, store, "", func(name string, item *azStorageBlob.BlobItemInternal) error {
+				visited = append(visited, name)
+				if name == "tenant/1/" {
+					return SkipDir
+				}
+				return nil
+			}, WithListMaxResults(2))
+			require.NoError(t, err)
+			assert.Equal(t, []string{
+				"other",
+				"tenant.txt",
+				"tenant/",
+				"tenant/1/",
+				"tenant/2/",
+				"tenant/2/massifs/",
+				"tenant/2/massifs/0",
+				"tenant/index",
+			}, visited)
+
+			// SkipDir for a blob skips the rest of its directory
+			visited = nil
+			err = Walk(ctx, store, "tenant/", func(name string, item *azStorageBlob.BlobItemInternal) error {
+				visited = append(visited, name)
+				if name == "tenant/1/massifs/0" {
+					return SkipDir
+				}
+				return nil
+			}, WithListMaxResults(1))
+			require.NoError(t, err)
+			assert.Equal(t, []string{
+				"tenant/1/",
+				"tenant/1/massifs/",
+				"tenant/1/massifs/0",
+				"tenant/1/seals/",
+				"tenant/1/seals/0",
+				"tenant/2/",
+				"tenant/2/massifs/",
+				"tenant/2/massifs/0",
+				"tenant/index",
+			}, visited)
+
+			dirs, err := Tree(ctx, store, "tenant/", 1)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"tenant/1/", "tenant/2/"}, dirs)
+
+			dirs, err = Tree(ctx, store, "tenant/", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"tenant/1/", "tenant/1/massifs/", "tenant/1/seals/", "tenant/2/", "tenant/2/massifs/"}, dirs)
+		})
+	}
+}
@@ -0,0 +1,251 @@
+// Package leaderelection elects a single leader from a number of replicas
+// using a lease on a well known blob.
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/textproto"
+	"sync/atomic"
+	"time"
+
+	"github.com/datatrails/go-datatrails-common/azblob"
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	// metadata keys on the election blob, so operators can see who leads
+	LeaderKey      = "leader"
+	LeaderSinceKey = "leader_since"
+
+	DefaultLeaseDuration = 30 // seconds
+	DefaultRetryPeriod   = 10 * time.Second
+)
+
+var (
+	ErrNoIdentity = errors.New("a leader identity is required")
+)
+
+// Store is what the election needs from the blob store
+type Store interface {
+	azblob.Reader
+	azblob.Writer
+	azblob.Leaser
+}
+
+// Callbacks are called as this replica gains and loses leadership
+type Callbacks struct {
+	// OnStartedLeading is called when this replica becomes the leader. ctx is
+	// cancelled when leadership is lost and the function must then return
+	// promptly. If it returns of its own accord, leadership is given up and
+	// the replica competes for it again.
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading is called once OnStartedLeading has returned
+	OnStoppedLeading func()
+}
+
+// LeaderElector competes for leadership on behalf of one replica
+type LeaderElector struct {
+	store         Store
+	blobName      string
+	identity      string
+	callbacks     Callbacks
+	leaseDuration int32
+	retryPeriod   time.Duration
+	renewInterval time.Duration
+
+	leading atomic.Bool
+}
+
+type Option func(*LeaderElector)
+
+// WithLeaseDuration specifies the lease duration in seconds, 15 to 60. A
+// leader that dies is replaced within about this long. Default 30.
+func WithLeaseDuration(seconds int32) Option {
+	return func(le *LeaderElector) {
+		le.leaseDuration = seconds
+	}
+}
+
+// WithRetryPeriod specifies the average time between attempts to become
+// leader. Each wait is randomised by +/-50% so that replicas don't retry in
+// lock step. Default 10s.
+func WithRetryPeriod(period time.Duration) Option {
+	return func(le *LeaderElector) {
+		le.retryPeriod = period
+	}
+}
+
+// WithRenewInterval specifies how often the leader renews the lease. The
+// default is a third of the lease duration.
+func WithRenewInterval(interval time.Duration) Option {
+	return func(le *LeaderElector) {
+		le.renewInterval = interval
+	}
+}
+
+// New returns an elector for the replica identified by identity, typically
+// the pod name. All the replicas must use the same blobName.
+func New(store Store, blobName string, identity string, callbacks Callbacks, opts ...Option) (*LeaderElector, error) {
+	if identity == "" {
+		return nil, ErrNoIdentity
+	}
+	le := &LeaderElector{
+		store:         store,
+		blobName:      blobName,
+		identity:      identity,
+		callbacks:     callbacks,
+		leaseDuration: DefaultLeaseDuration,
+		retryPeriod:   DefaultRetryPeriod,
+	}
+	for _, opt := range opts {
+		opt(le)
+	}
+	return le, nil
+}
+
+// IsLeader returns true while this replica is the leader
+func (le *LeaderElector) IsLeader() bool {
+	return le.leading.Load()
+}
+
+// Run competes for leadership until ctx is cancelled, calling the callbacks
+// each time leadership is gained and lost. It returns ctx.Err().
+func (le *LeaderElector) Run(ctx context.Context) error {
+	for {
+		err := le.lead(ctx)
+		if err != nil {
+			logger.Sugar.Debugf("%s did not lead %s: %v", le.identity, le.blobName, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(le.jitter()):
+		}
+	}
+}
+
+// jitter returns the retry period randomised by +/-50%
+func (le *LeaderElector) jitter() time.Duration {
+	if le.retryPeriod <= 0 {
+		return 0
+	}
+	return le.retryPeriod/2 + rand.N(le.retryPeriod)
+}
+
+// lead makes one attempt to become the leader, and if it succeeds, leads
+// until leadership is lost or given up.
+func (le *LeaderElector) lead(ctx context.Context) error {
+	keeper, err := le.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		le.clearLeader(keeper)
+		if err := keeper.Close(); err != nil {
+			logger.Sugar.Infof("%s failed to release %s: %v", le.identity, le.blobName, err)
+		}
+	}()
+
+	if err = le.setLeader(ctx, keeper); err != nil {
+		return err
+	}
+
+	logger.Sugar.Infof("%s started leading %s", le.identity, le.blobName)
+	le.leading.Store(true)
+	defer func() {
+		le.leading.Store(false)
+		logger.Sugar.Infof("%s stopped leading %s", le.identity, le.blobName)
+		if le.callbacks.OnStoppedLeading != nil {
+			le.callbacks.OnStoppedLeading()
+		}
+	}()
+
+	leaderCtx, cancel := context.WithCancel(keeper.Context())
+	defer cancel()
+	if le.callbacks.OnStartedLeading != nil {
+		le.callbacks.OnStartedLeading(leaderCtx)
+	} else {
+		<-leaderCtx.Done()
+	}
+	return keeper.Err()
+}
+
+// acquire gets the lease, creating the election blob if necessary
+func (le *LeaderElector) acquire(ctx context.Context) (*azblob.LeaseKeeper, error) {
+	var opts []azblob.LeaseKeeperOption
+	if le.renewInterval > 0 {
+		opts = append(opts, azblob.WithLeaseRenewInterval(le.renewInterval))
+	}
+	keeper, err := azblob.NewLeaseKeeper(ctx, le.store, le.blobName, le.leaseDuration, opts...)
+	if err == nil || azblob.ErrorFromError(err).StatusCode() != http.StatusNotFound {
+		return keeper, err
+	}
+
+	// the first replica to run creates the blob. A conflict means another
+	// replica beat us to it, which is fine.
+	_, err = le.store.Put(ctx, le.blobName, azblob.NewBytesReaderCloser([]byte{}), azblob.WithEtagNoneMatch("*"))
+	if err != nil && azblob.ErrorFromError(err).StatusCode() != http.StatusConflict {
+		return nil, err
+	}
+	return azblob.NewLeaseKeeper(ctx, le.store, le.blobName, le.leaseDuration, opts...)
+}
+
+func (le *LeaderElector) setLeader(ctx context.Context, keeper *azblob.LeaseKeeper) error {
+	_, err := le.store.Put(
+		ctx, le.blobName, azblob.NewBytesReaderCloser([]byte{}),
+		azblob.WithLeaseID(keeper.LeaseID()),
+		azblob.WithMetadata(map[string]string{
+			LeaderKey:      le.identity,
+			LeaderSinceKey: time.Now().UTC().Format(time.RFC3339),
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record leader %s on %s: %w", le.identity, le.blobName, err)
+	}
+	return nil
+}
+
+// clearLeader removes the leader metadata, if the lease is still held
+func (le *LeaderElector) clearLeader(keeper *azblob.LeaseKeeper) {
+	if keeper.Err() != nil {
+		return
+	}
+	// the leader context may be cancelled, but the lease is still ours
+	_, err := le.store.Put(
+		context.WithoutCancel(keeper.Context()), le.blobName, azblob.NewBytesReaderCloser([]byte{}),
+		azblob.WithLeaseID(keeper.LeaseID()),
+	)
+	if err != nil {
+		logger.Sugar.Infof("%s failed to clear leader on %s: %v", le.identity, le.blobName, err)
+	}
+}
+
+// Leader returns the identity of the current leader of the election on
+// blobName, and when it became leader, as recorded in the blob metadata. The
+// identity is empty if there is no leader. A leader that died without
+// releasing the lease is reported until another replica takes over.
+func Leader(ctx context.Context, reader azblob.Reader, blobName string) (string, time.Time, error) {
+	rr, err := reader.Reader(ctx, blobName, azblob.WithGetMetadata(azblob.BothMetadataAndBlob))
+	if azblob.ErrorFromError(err).StatusCode() == http.StatusNotFound {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if rr.Reader != nil {
+		_ = rr.Reader.Close()
+	}
+	identity, since := leaderMetadata(rr.Metadata)
+	return identity, since, nil
+}
+
+// leaderMetadata finds the leader keys, which azure returns canonicalised
+func leaderMetadata(metadata map[string]string) (string, time.Time) {
+	identity := metadata[textproto.CanonicalMIMEHeaderKey(LeaderKey)]
+	since, _ := time.Parse(time.RFC3339, metadata[textproto.CanonicalMIMEHeaderKey(LeaderSinceKey)])
+	return identity, since
+}
@@ -0,0 +1,95 @@
+package leaderelection
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/azblob"
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+type leadership struct {
+	mu      sync.Mutex
+	started chan string
+	leading map[string]bool
+}
+
+func (l *leadership) callbacks(t *testing.T, identity string) Callbacks {
+	return Callbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			l.mu.Lock()
+			for other, leading := range l.leading {
+				assert.False(t, leading, "%s and %s both leading", identity, other)
+			}
+			l.leading[identity] = true
+			l.mu.Unlock()
+			l.started <- identity
+			<-ctx.Done()
+		},
+		OnStoppedLeading: func() {
+			l.mu.Lock()
+			l.leading[identity] = false
+			l.mu.Unlock()
+		},
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	store := azblob.NewMemStorer("devcontainer")
+	l := &leadership{started: make(chan string, 2), leading: map[string]bool{}}
+
+	ctx := context.Background()
+	identity, _, err := Leader(ctx, store, "election")
+	require.NoError(t, err)
+	assert.Empty(t, identity)
+
+	_, err = New(store, "election", "", Callbacks{})
+	assert.ErrorIs(t, err, ErrNoIdentity)
+
+	cancels := map[string]context.CancelFunc{}
+	electors := map[string]*LeaderElector{}
+	var wg sync.WaitGroup
+	for _, id := range []string{"replica-0", "replica-1"} {
+		le, err := New(store, "election", id, l.callbacks(t, id),
+			WithLeaseDuration(15), WithRetryPeriod(10*time.Millisecond), WithRenewInterval(5*time.Millisecond))
+		require.NoError(t, err)
+		runCtx, cancel := context.WithCancel(ctx)
+		cancels[id] = cancel
+		electors[id] = le
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = le.Run(runCtx)
+		}()
+	}
+
+	first := <-l.started
+	assert.True(t, electors[first].IsLeader())
+	identity, since, err := Leader(ctx, store, "election")
+	require.NoError(t, err)
+	assert.Equal(t, first, identity)
+	assert.False(t, since.IsZero())
+
+	// stopping the leader hands over to the other replica
+	cancels[first]()
+	second := <-l.started
+	assert.NotEqual(t, first, second)
+	assert.False(t, electors[first].IsLeader())
+	identity, _, err = Leader(ctx, store, "election")
+	require.NoError(t, err)
+	assert.Equal(t, second, identity)
+
+	cancels[second]()
+	wg.Wait()
+	identity, _, err = Leader(ctx, store, "election")
+	require.NoError(t, err)
+	assert.Empty(t, identity)
+}
@@ -5,6 +5,7 @@ package azblob
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -16,6 +17,10 @@ import (
 
 const (
 	leaseReleaseTimeoutSecs = 5
+
+	// InfiniteLease is the lease duration for a lease that never expires. It
+	// must be released or broken.
+	InfiniteLease int32 = -1
 )
 
 type LeaseRenewer func(ctx context.Context) error
@@ -142,3 +147,194 @@ func (azp *Storer) ReleaseLease(ctx context.Context, objectname string, leaseID
 	}
 	return nil
 }
+
+// blobLeaseClient returns a lease client for the blob. If leaseID is nil the
+// client has a new lease id, which is fine for operations that don't need one.
+func (azp *Storer) blobLeaseClient(objectname string, leaseID *string) (*azStorageBlob.BlobLeaseClient, error) {
+	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(objectname)
+	if err != nil {
+		logger.Sugar.Infof("cannot create block Blob client %s: %v", objectname, err)
+		return nil, ErrorFromError(err)
+	}
+	leaseBlobClient, err := blockBlobClient.NewBlobLeaseClient(leaseID)
+	if err != nil {
+		logger.Sugar.Infof("cannot create lease Blob %s: %v", objectname, err)
+		return nil, ErrorFromError(err)
+	}
+	return leaseBlobClient, nil
+}
+
+// breakPeriodOption returns nil for a negative break period, which lets the
+// lease run to the end of its current period
+func breakPeriodOption(breakPeriod int32) *int32 {
+	if breakPeriod < 0 {
+		return nil
+	}
+	return &breakPeriod
+}
+
+// BreakLease breaks the lease on a blob, whoever holds it, so that another
+// lease can be acquired once the break period has passed. This is how a lease
+// held by a crashed process is recovered without waiting for it to expire.
+//
+// breakPeriod is in seconds, 0 to 60, and is cut short if the lease expires
+// first. 0 breaks the lease immediately. A negative breakPeriod lets the lease
+// run to the end of its current period, or breaks an infinite lease
+// immediately.
+//
+// Returns the number of seconds until the lease is broken. Until then the
+// holder can still use or release the lease but can't renew or change it.
+func (azp *Storer) BreakLease(ctx context.Context, objectname string, breakPeriod int32) (int32, error) {
+	logger.Sugar.Infof("BreakLease: %v period %d", objectname, breakPeriod)
+
+	leaseBlobClient, err := azp.blobLeaseClient(objectname, nil)
+	if err != nil {
+		return 0, err
+	}
+	r, err := leaseBlobClient.BreakLease(ctx, &azStorageBlob.BlobBreakLeaseOptions{
+		BreakPeriod: breakPeriodOption(breakPeriod),
+	})
+	if err != nil {
+		logger.Sugar.Infof("failed to break lease %s: %v", objectname, err)
+		return 0, ErrorFromError(err)
+	}
+	if r.LeaseTime == nil {
+		return 0, nil
+	}
+	return *r.LeaseTime, nil
+}
+
+// ChangeLease changes the id of an active lease on a blob from leaseID to
+// proposedLeaseID, which hands the lease over to whoever knows the new id. If
+// proposedLeaseID is empty a new id is generated. The new id, which must be a
+// GUID, is returned.
+func (azp *Storer) ChangeLease(
+	ctx context.Context, objectname string, leaseID string, proposedLeaseID string,
+) (string, error) {
+	logger.Sugar.Debugf("ChangeLease: %v", objectname)
+
+	leaseBlobClient, err := azp.blobLeaseClient(objectname, &leaseID)
+	if err != nil {
+		return "", err
+	}
+	options := &azStorageBlob.BlobChangeLeaseOptions{}
+	if proposedLeaseID != "" {
+		options.ProposedLeaseID = &proposedLeaseID
+	}
+	r, err := leaseBlobClient.ChangeLease(ctx, options)
+	if err != nil {
+		logger.Sugar.Infof("failed to change lease %s: %v", objectname, err)
+		return "", ErrorFromError(err)
+	}
+	return *r.LeaseID, nil
+}
+
+func (azp *Storer) containerLeaseClient(leaseID *string) (*azStorageBlob.ContainerLeaseClient, error) {
+	if azp.containerClient == nil {
+		return nil, errors.New("no container client available")
+	}
+	leaseClient, err := azp.containerClient.NewContainerLeaseClient(leaseID)
+	if err != nil {
+		logger.Sugar.Infof("cannot create lease client for container %s: %v", azp.Container, err)
+		return nil, ErrorFromError(err)
+	}
+	return leaseClient, nil
+}
+
+// AcquireContainerLease gets a lease on the container. The lease duration is
+// in seconds, 15 to 60, or InfiniteLease. A container lease only prevents the
+// container being deleted, the blobs in it are unaffected.
+func (azp *Storer) AcquireContainerLease(ctx context.Context, leaseTimeout int32) (string, error) {
+	logger.Sugar.Debugf("AcquireContainerLease: %v", azp.Container)
+
+	leaseClient, err := azp.containerLeaseClient(nil)
+	if err != nil {
+		return "", err
+	}
+	r, err := leaseClient.AcquireLease(ctx, &azStorageBlob.ContainerAcquireLeaseOptions{
+		Duration: &leaseTimeout,
+	})
+	if err != nil {
+		logger.Sugar.Infof("failed to acquire lease on container %s: %v", azp.Container, err)
+		return "", ErrorFromError(err)
+	}
+	return *r.LeaseID, nil
+}
+
+// RenewContainerLease renews the lease on the container for the duration it
+// was acquired with
+func (azp *Storer) RenewContainerLease(ctx context.Context, leaseID string) error {
+	logger.Sugar.Debugf("RenewContainerLease: %v", azp.Container)
+
+	leaseClient, err := azp.containerLeaseClient(&leaseID)
+	if err != nil {
+		return err
+	}
+	_, err = leaseClient.RenewLease(ctx, nil)
+	if err != nil {
+		logger.Sugar.Infof("failed to renew lease on container %s: %v", azp.Container, err)
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// ReleaseContainerLease releases the lease on the container. As for
+// ReleaseLease, the release is attempted even if ctx is done.
+func (azp *Storer) ReleaseContainerLease(ctx context.Context, leaseID string) error {
+	logger.Sugar.Debugf("ReleaseContainerLease: %v", azp.Container)
+
+	leaseClient, err := azp.containerLeaseClient(&leaseID)
+	if err != nil {
+		return err
+	}
+	newCtx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeoutSecs*time.Second)
+	defer cancel()
+	_, err = leaseClient.ReleaseLease(newCtx, nil)
+	if err != nil {
+		logger.Sugar.Infof("failed to release lease on container %s: %v", azp.Container, err)
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// BreakContainerLease breaks the lease on the container, see BreakLease
+func (azp *Storer) BreakContainerLease(ctx context.Context, breakPeriod int32) (int32, error) {
+	logger.Sugar.Infof("BreakContainerLease: %v period %d", azp.Container, breakPeriod)
+
+	leaseClient, err := azp.containerLeaseClient(nil)
+	if err != nil {
+		return 0, err
+	}
+	r, err := leaseClient.BreakLease(ctx, &azStorageBlob.ContainerBreakLeaseOptions{
+		BreakPeriod: breakPeriodOption(breakPeriod),
+	})
+	if err != nil {
+		logger.Sugar.Infof("failed to break lease on container %s: %v", azp.Container, err)
+		return 0, ErrorFromError(err)
+	}
+	if r.LeaseTime == nil {
+		return 0, nil
+	}
+	return *r.LeaseTime, nil
+}
+
+// ChangeContainerLease changes the id of the active lease on the container,
+// see ChangeLease
+func (azp *Storer) ChangeContainerLease(ctx context.Context, leaseID string, proposedLeaseID string) (string, error) {
+	logger.Sugar.Debugf("ChangeContainerLease: %v", azp.Container)
+
+	leaseClient, err := azp.containerLeaseClient(&leaseID)
+	if err != nil {
+		return "", err
+	}
+	options := &azStorageBlob.ContainerChangeLeaseOptions{}
+	if proposedLeaseID != "" {
+		options.ProposedLeaseID = &proposedLeaseID
+	}
+	r, err := leaseClient.ChangeLease(ctx, options)
+	if err != nil {
+		logger.Sugar.Infof("failed to change lease on container %s: %v", azp.Container, err)
+		return "", ErrorFromError(err)
+	}
+	return *r.LeaseID, nil
+}
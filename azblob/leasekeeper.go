@@ -0,0 +1,195 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+var (
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+const (
+	// the lease is renewed this many times per lease duration, so a renewal
+	// that fails, or is slow, is retried before the lease expires
+	leaseRenewalsPerDuration = 3
+)
+
+// LeaseKeeper holds a lease on a blob, renewing it in the background until it
+// is closed. Work that must only be done while the lease is held should use
+// Context(), which is cancelled as soon as the lease is lost.
+//
+// A renewal that fails because the lease is no longer held loses the lease at
+// once. Other failures, eg. a timeout or a busy service, are retried at the
+// next renewal, unless the lease would expire before then.
+//
+// example, a singleton worker:
+//
+//	keeper, err := azblob.NewLeaseKeeper(ctx, store, "workers/indexer", 30)
+//	if err != nil {
+//		return err // some other instance has the lease
+//	}
+//	defer keeper.Close()
+//	return worker.Run(keeper.Context())
+type LeaseKeeper struct {
+	leaser        Leaser
+	objectname    string
+	leaseID       string
+	leaseDuration time.Duration
+	renewInterval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type LeaseKeeperOption func(*LeaseKeeper)
+
+// WithLeaseRenewInterval specifies how often the lease is renewed. The default
+// is a third of the lease duration. It must be greater than zero and less than
+// the lease duration, and is ignored for an infinite lease.
+func WithLeaseRenewInterval(interval time.Duration) LeaseKeeperOption {
+	return func(k *LeaseKeeper) {
+		k.renewInterval = interval
+	}
+}
+
+// NewLeaseKeeper acquires a lease on the blob and starts renewing it. The
+// lease duration is in seconds, 15 to 60, or -1 for an infinite lease which
+// never needs renewing. The blob must exist, unlike AcquireLease it is not
+// created.
+//
+// The keeper's context is derived from ctx. Cancelling ctx stops the renewals,
+// but Close must still be called to release the lease.
+func NewLeaseKeeper(
+	ctx context.Context,
+	leaser Leaser,
+	objectname string,
+	leaseTimeout int32,
+	opts ...LeaseKeeperOption,
+) (*LeaseKeeper, error) {
+
+	k := &LeaseKeeper{
+		leaser:     leaser,
+		objectname: objectname,
+		done:       make(chan struct{}),
+	}
+	if leaseTimeout > 0 {
+		k.leaseDuration = time.Duration(leaseTimeout) * time.Second
+		k.renewInterval = k.leaseDuration / leaseRenewalsPerDuration
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	if leaseTimeout > 0 && (k.renewInterval <= 0 || k.renewInterval >= k.leaseDuration) {
+		return nil, NewStatusError(
+			fmt.Sprintf("lease renew interval %v must be greater than 0 and less than the lease duration %v", k.renewInterval, k.leaseDuration),
+			http.StatusBadRequest,
+		)
+	}
+
+	// the lease lasts for the duration from some time after this
+	acquired := time.Now()
+	leaseID, renewer, err := leaser.AcquireLeaseRenewable(ctx, objectname, leaseTimeout)
+	if err != nil {
+		return nil, err
+	}
+	logger.Sugar.Infof("LeaseKeeper acquired lease on %s", objectname)
+	k.leaseID = leaseID
+	k.ctx, k.cancel = context.WithCancelCause(ctx)
+
+	if leaseTimeout < 0 {
+		close(k.done)
+		return k, nil
+	}
+	go k.renew(renewer, acquired)
+	return k, nil
+}
+
+// leaseNotHeld returns true if a renewal failed because the lease is no longer
+// held, rather than because the request failed
+func leaseNotHeld(err error) bool {
+	switch ErrorFromError(err).StatusCode() {
+	case http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (k *LeaseKeeper) renew(renewer LeaseRenewer, renewed time.Time) {
+	defer close(k.done)
+
+	ticker := time.NewTicker(k.renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		attempted := time.Now()
+		err := renewer(k.ctx)
+		if err == nil {
+			renewed = attempted
+			continue
+		}
+		if k.ctx.Err() != nil {
+			// closed or cancelled while renewing
+			return
+		}
+		expires := renewed.Add(k.leaseDuration)
+		if !leaseNotHeld(err) && time.Now().Add(k.renewInterval).Before(expires) {
+			logger.Sugar.Infof("LeaseKeeper failed to renew lease on %s, expiring at %v: %v", k.objectname, expires, err)
+			continue
+		}
+		logger.Sugar.Infof("LeaseKeeper lost lease on %s: %v", k.objectname, err)
+		k.cancel(fmt.Errorf("%w on %s: %w", ErrLeaseLost, k.objectname, err))
+		return
+	}
+}
+
+// LeaseID returns the id of the lease, for use with WithLeaseID()
+func (k *LeaseKeeper) LeaseID() string {
+	return k.leaseID
+}
+
+// Context returns a context that is cancelled when the lease is lost, the
+// keeper is closed, or the context the keeper was created with is cancelled.
+func (k *LeaseKeeper) Context() context.Context {
+	return k.ctx
+}
+
+// Err returns an error wrapping ErrLeaseLost if the lease has been lost, and
+// nil otherwise.
+func (k *LeaseKeeper) Err() error {
+	if err := context.Cause(k.ctx); errors.Is(err, ErrLeaseLost) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the renewals and releases the lease, unless it was lost. It is
+// safe to call more than once.
+func (k *LeaseKeeper) Close() error {
+	k.closeOnce.Do(func() {
+		lost := k.Err()
+		k.cancel(nil)
+		<-k.done
+		if lost != nil {
+			return
+		}
+		// ReleaseLease does not depend on the, now cancelled, context
+		k.closeErr = k.leaser.ReleaseLease(k.ctx, k.objectname, k.leaseID)
+	})
+	return k.closeErr
+}
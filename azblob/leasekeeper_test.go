@@ -0,0 +1,158 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLeaseKeeper(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	store := NewMemStorer("devcontainer")
+
+	_, err := NewLeaseKeeper(ctx, store, "workers/indexer", 15)
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+	_, err = store.Put(ctx, "workers/indexer", NewBytesReaderCloser([]byte{}))
+	require.NoError(t, err)
+
+	keeper, err := NewLeaseKeeper(ctx, store, "workers/indexer", 15, WithLeaseRenewInterval(5*time.Millisecond))
+	require.NoError(t, err)
+
+	// someone else can't get the lease while it is kept
+	_, err = NewLeaseKeeper(ctx, store, "workers/indexer", 15)
+	assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, keeper.Context().Err())
+	assert.NoError(t, keeper.Err())
+
+	require.NoError(t, keeper.Close())
+	assert.Error(t, keeper.Context().Err())
+	assert.NoError(t, keeper.Err())
+	assert.NoError(t, keeper.Close())
+
+	// released, so it can be acquired again
+	keeper, err = NewLeaseKeeper(ctx, store, "workers/indexer", 15, WithLeaseRenewInterval(5*time.Millisecond))
+	require.NoError(t, err)
+
+	// the lease is lost if it is released behind our back
+	require.NoError(t, store.ReleaseLease(ctx, "workers/indexer", keeper.LeaseID()))
+	select {
+	case <-keeper.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease loss not detected")
+	}
+	assert.ErrorIs(t, keeper.Err(), ErrLeaseLost)
+	assert.NoError(t, keeper.Close())
+}
+
+func TestLeaseKeeperRenewInterval(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	store := NewMemStorer("devcontainer")
+	_, err := store.Put(ctx, "workers/indexer", NewBytesReaderCloser([]byte{}))
+	require.NoError(t, err)
+
+	for _, interval := range []time.Duration{0, -time.Second, 15 * time.Second, time.Minute} {
+		_, err = NewLeaseKeeper(ctx, store, "workers/indexer", 15, WithLeaseRenewInterval(interval))
+		assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode(), interval)
+	}
+
+	// the rejected keepers did not acquire the lease
+	keeper, err := NewLeaseKeeper(ctx, store, "workers/indexer", 15, WithLeaseRenewInterval(14*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, keeper.Close())
+
+	// an infinite lease is never renewed, so the interval is ignored
+	keeper, err = NewLeaseKeeper(ctx, store, "workers/indexer", -1, WithLeaseRenewInterval(0))
+	require.NoError(t, err)
+	require.NoError(t, keeper.Close())
+}
+
+// failingLeaser fails the renewals of its leases with the status while
+// failures is positive, counting them down
+type failingLeaser struct {
+	*MemStorer
+	status   int
+	failures atomic.Int32
+}
+
+func (l *failingLeaser) AcquireLeaseRenewable(
+	ctx context.Context, objectname string, leaseTimeout int32,
+) (string, LeaseRenewer, error) {
+	leaseID, renewer, err := l.MemStorer.AcquireLeaseRenewable(ctx, objectname, leaseTimeout)
+	if err != nil {
+		return "", nil, err
+	}
+	return leaseID, func(ctx context.Context) error {
+		if l.failures.Add(-1) >= 0 {
+			return NewStatusError("renewal failed", l.status)
+		}
+		return renewer(ctx)
+	}, nil
+}
+
+func TestLeaseKeeperRenewalFailures(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	store := NewMemStorer("devcontainer")
+	_, err := store.Put(ctx, "workers/indexer", NewBytesReaderCloser([]byte{}))
+	require.NoError(t, err)
+	leaser := &failingLeaser{MemStorer: store, status: http.StatusServiceUnavailable}
+	withDuration := func(d time.Duration) LeaseKeeperOption {
+		return func(k *LeaseKeeper) { k.leaseDuration = d }
+	}
+
+	// failures are retried while the lease has not expired
+	leaser.failures.Store(3)
+	keeper, err := NewLeaseKeeper(ctx, leaser, "workers/indexer", 15, WithLeaseRenewInterval(5*time.Millisecond))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return leaser.failures.Load() < 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, keeper.Err())
+	require.NoError(t, keeper.Close())
+
+	// until the lease would expire before the next renewal
+	leaser.failures.Store(1000)
+	keeper, err = NewLeaseKeeper(ctx, leaser, "workers/indexer", 15,
+		WithLeaseRenewInterval(5*time.Millisecond), withDuration(50*time.Millisecond))
+	require.NoError(t, err)
+	select {
+	case <-keeper.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease expiry not detected")
+	}
+	assert.ErrorIs(t, keeper.Err(), ErrLeaseLost)
+	// retried, but not more often than the renewal interval
+	assert.Less(t, leaser.failures.Load(), int32(999))
+	assert.Greater(t, leaser.failures.Load(), int32(980))
+	assert.NoError(t, keeper.Close())
+	require.NoError(t, store.ReleaseLease(ctx, "workers/indexer", keeper.LeaseID()))
+
+	// a lease that is no longer held is lost at once
+	leaser.status = http.StatusConflict
+	leaser.failures.Store(1)
+	keeper, err = NewLeaseKeeper(ctx, leaser, "workers/indexer", 15, WithLeaseRenewInterval(5*time.Millisecond))
+	require.NoError(t, err)
+	select {
+	case <-keeper.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease loss not detected")
+	}
+	assert.ErrorIs(t, keeper.Err(), ErrLeaseLost)
+	assert.Equal(t, int32(0), leaser.failures.Load())
+}
@@ -12,22 +12,7 @@ import (
 
 // Count counts the number of blobs filtered by the given tags filter
 func (azp *Storer) Count(ctx context.Context, tagsFilter string, opts ...Option) (int64, error) {
-
-	var count int64
-	var m ListMarker
-
-	for {
-		r, err := azp.FilteredList(ctx, tagsFilter, append(opts, WithListMarker(m))...)
-		if err != nil {
-			return 0, err
-		}
-		count += int64(len(r.Items))
-		if r.Marker == nil || *r.Marker == "" {
-			break
-		}
-		m = r.Marker
-	}
-	return count, nil
+	return countFiltered(ctx, azp, tagsFilter, opts...)
 }
 
 type FilterResponse struct {
@@ -114,44 +99,58 @@ type ListerResponse struct {
 	Status     string
 
 	Items []*azStorageBlob.BlobItemInternal
+
+	// TypedMetadata has the struct decoded by WithMetadataSchema() from the
+	// metadata of each of the Items, in the same order. Only set with
+	// WithListMetadata(). The entry is nil for an item whose metadata can't
+	// be decoded, and the error is the entry of TypedMetadataErrors.
+	TypedMetadata       []any
+	TypedMetadataErrors []error
+
+	// Prefixes are the virtual directories at this level of the hierarchy,
+	// each ending in the delimiter. Only set for WithListDelim().
+	Prefixes []*azStorageBlob.BlobPrefix
 }
 
+// List returns a page of the blobs in the container. If WithListDelim() is
+// specified the blobs are listed as a hierarchy: Items has only the blobs
+// directly under the prefix and Prefixes the virtual directories below it.
 func (azp *Storer) List(ctx context.Context, opts ...Option) (*ListerResponse, error) {
 
-	span, ctx := tracing.StartSpanFromContext(ctx, "ListBlobsFlat")
-	defer span.Finish()
-
 	options := &StorerOptions{}
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.listDelim != "" {
+		return azp.listHierarchy(ctx, options)
+	}
+
+	span, ctx := tracing.StartSpanFromContext(ctx, "ListBlobsFlat")
+	defer span.Finish()
+
 	if options.listMarker != nil {
 		span.SetTag("marker", *options.listMarker)
 	}
 	o := azStorageBlob.ContainerListBlobsFlatOptions{
-		Marker: options.listMarker,
+		Marker:     options.listMarker,
+		Include:    listIncludes(options),
+		MaxResults: listMaxResults(options),
 	}
 	if options.listPrefix != "" {
 		o.Prefix = &options.listPrefix
 		span.SetTag("prefix", options.listPrefix)
 	}
-	if options.listIncludeTags {
-		o.Include = append(o.Include, azStorageBlob.ListBlobsIncludeItemTags)
-	}
-	if options.listIncludeMetadata {
-		o.Include = append(o.Include, azStorageBlob.ListBlobsIncludeItemMetadata)
-	}
-	if options.listMaxResults > 0 {
-		o.MaxResults = &options.listMaxResults
+	if o.MaxResults != nil {
 		span.SetTag("maxResults", options.listMaxResults)
 	}
 
-	// TODO: v1.21 feature which would be great
-	// if options.listDelim != "" {
-	// }
 	r := &ListerResponse{}
 	pager := azp.containerClient.ListBlobsFlat(&o)
 	if !pager.NextPage(ctx) {
+		// the pager only returns false on the first page if the request failed
+		if err := pager.Err(); err != nil {
+			return nil, ErrorFromError(err)
+		}
 		return r, nil
 	}
 	resp := pager.PageResponse()
@@ -170,6 +169,79 @@ func (azp *Storer) List(ctx context.Context, opts ...Option) (*ListerResponse, e
 	// Note: we pass on the azure type otherwise we would be copying for no good
 	// reason. let the caller decided how to deal with that
 	r.Items = resp.Segment.BlobItems
+	typedListMetadata(r, options)
 
 	return r, nil
 }
+
+func (azp *Storer) listHierarchy(ctx context.Context, options *StorerOptions) (*ListerResponse, error) {
+
+	span, ctx := tracing.StartSpanFromContext(ctx, "ListBlobsHierarchy")
+	defer span.Finish()
+
+	span.SetTag("delimiter", options.listDelim)
+	if options.listMarker != nil {
+		span.SetTag("marker", *options.listMarker)
+	}
+	o := azStorageBlob.ContainerListBlobsHierarchyOptions{
+		Marker:     options.listMarker,
+		Include:    listIncludes(options),
+		MaxResults: listMaxResults(options),
+	}
+	if options.listPrefix != "" {
+		o.Prefix = &options.listPrefix
+		span.SetTag("prefix", options.listPrefix)
+	}
+	if o.MaxResults != nil {
+		span.SetTag("maxResults", options.listMaxResults)
+	}
+
+	r := &ListerResponse{}
+	pager := azp.containerClient.ListBlobsHierarchy(options.listDelim, &o)
+	if !pager.NextPage(ctx) {
+		if err := pager.Err(); err != nil {
+			return nil, ErrorFromError(err)
+		}
+		return r, nil
+	}
+	resp := pager.PageResponse()
+	r.Status = resp.RawResponse.Status
+	r.StatusCode = resp.RawResponse.StatusCode
+
+	if resp.Prefix != nil {
+		r.Prefix = *resp.Prefix
+	}
+
+	r.Marker = resp.NextMarker
+	if r.Marker != nil {
+		span.SetTag("nextmarker", *r.Marker)
+	}
+
+	r.Items = resp.Segment.BlobItems
+	r.Prefixes = resp.Segment.BlobPrefixes
+	typedListMetadata(r, options)
+
+	return r, nil
+}
+
+func listIncludes(options *StorerOptions) []azStorageBlob.ListBlobsIncludeItem {
+	var include []azStorageBlob.ListBlobsIncludeItem
+	if options.listIncludeTags {
+		include = append(include, azStorageBlob.ListBlobsIncludeItemTags)
+	}
+	if options.listIncludeMetadata {
+		include = append(include, azStorageBlob.ListBlobsIncludeItemMetadata)
+	}
+	if options.listIncludeVersions {
+		include = append(include,
+			azStorageBlob.ListBlobsIncludeItemSnapshots, azStorageBlob.ListBlobsIncludeItemVersions)
+	}
+	return include
+}
+
+func listMaxResults(options *StorerOptions) *int32 {
+	if options.listMaxResults > 0 {
+		return &options.listMaxResults
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package azblob
+
+import (
+	"context"
+	"iter"
+	"net/http"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// AllBlobs returns an iterator over every blob that List returns for opts,
+// fetching the pages lazily as the iteration proceeds. Use WithListMaxResults
+// to set the page size and WithListTags or WithListMetadata to include the
+// tags or metadata in the items. Any WithListMarker option is the starting
+// point.
+//
+// If fetching a page fails, or ctx is done, the error is yielded with a nil
+// item and the iteration stops.
+//
+// The virtual directories of a delimited list are not blobs, so WithListDelim
+// is rejected with a 400 error. Use Walk to visit the directories.
+//
+// example:
+//
+//	for item, err := range azblob.AllBlobs(ctx, store, azblob.WithListPrefix("tenant/")) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(*item.Name)
+//	}
+func AllBlobs(ctx context.Context, r Reader, opts ...Option) iter.Seq2[*azStorageBlob.BlobItemInternal, error] {
+	return func(yield func(*azStorageBlob.BlobItemInternal, error) bool) {
+		options := &StorerOptions{}
+		for _, opt := range opts {
+			opt(options)
+		}
+		if options.listDelim != "" {
+			yield(nil, NewStatusError("AllBlobs does not list directories, use Walk", http.StatusBadRequest))
+			return
+		}
+		allPages(ctx, opts, func(opts []Option) ([]*azStorageBlob.BlobItemInternal, ListMarker, error) {
+			resp, err := r.List(ctx, opts...)
+			if err != nil {
+				return nil, nil, err
+			}
+			return resp.Items, resp.Marker, nil
+		}, yield)
+	}
+}
+
+// AllFiltered returns an iterator over every blob that FilteredList returns
+// for tagsFilter, fetching the pages lazily as the iteration proceeds. The
+// options and the error handling are as for AllBlobs.
+func AllFiltered(ctx context.Context, r Reader, tagsFilter string, opts ...Option) iter.Seq2[*azStorageBlob.FilterBlobItem, error] {
+	return func(yield func(*azStorageBlob.FilterBlobItem, error) bool) {
+		allPages(ctx, opts, func(opts []Option) ([]*azStorageBlob.FilterBlobItem, ListMarker, error) {
+			resp, err := r.FilteredList(ctx, tagsFilter, opts...)
+			if err != nil {
+				return nil, nil, err
+			}
+			return resp.Items, resp.Marker, nil
+		}, yield)
+	}
+}
+
+// allPages calls page with the marker for each page in turn, until there are
+// no more pages or yield returns false.
+func allPages[T any](
+	ctx context.Context,
+	opts []Option,
+	page func(opts []Option) ([]T, ListMarker, error),
+	yield func(T, error) bool,
+) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	marker := options.listMarker
+
+	var zero T
+	for {
+		if err := ctx.Err(); err != nil {
+			yield(zero, err)
+			return
+		}
+		items, next, err := page(append(opts[:len(opts):len(opts)], WithListMarker(marker)))
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+		if next == nil || *next == "" {
+			return
+		}
+		marker = next
+	}
+}
+
+// countFiltered counts the blobs that FilteredList returns for tagsFilter
+func countFiltered(ctx context.Context, r Reader, tagsFilter string, opts ...Option) (int64, error) {
+	var count int64
+	for _, err := range AllFiltered(ctx, r, tagsFilter, opts...) {
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,77 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestAllBlobs(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	store := NewMemStorer("devcontainer")
+	for i := 0; i < 7; i++ {
+		_, err := store.Write(ctx, fmt.Sprintf("tenant/%d", i), bytes.NewReader([]byte("data")),
+			WithTags(map[string]string{"even": fmt.Sprint(i%2 == 0)}),
+			WithMetadata(map[string]string{"index": fmt.Sprint(i)}))
+		require.NoError(t, err)
+	}
+	_, err := store.Write(ctx, "other", bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+
+	var names []string
+	for item, err := range AllBlobs(ctx, store, WithListPrefix("tenant/"), WithListMaxResults(2), WithListMetadata()) {
+		require.NoError(t, err)
+		require.NotNil(t, item.Metadata)
+		names = append(names, *item.Name)
+	}
+	assert.Equal(t, []string{"tenant/0", "tenant/1", "tenant/2", "tenant/3", "tenant/4", "tenant/5", "tenant/6"}, names)
+
+	// stopping early
+	names = nil
+	for item, err := range AllBlobs(ctx, store, WithListMaxResults(3)) {
+		require.NoError(t, err)
+		names = append(names, *item.Name)
+		if len(names) == 4 {
+			break
+		}
+	}
+	assert.Len(t, names, 4)
+
+	var even int
+	for item, err := range AllFiltered(ctx, store, `even='true'`, WithListMaxResults(1)) {
+		require.NoError(t, err)
+		require.NotNil(t, item.Tags)
+		even++
+	}
+	assert.Equal(t, 4, even)
+
+	for _, err := range AllFiltered(ctx, store, `even=`) {
+		assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	}
+
+	// the directories of a delimited list are not blobs
+	var yielded int
+	for item, err := range AllBlobs(ctx, store, WithListDelim("/")) {
+		yielded++
+		assert.Nil(t, item)
+		assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	}
+	assert.Equal(t, 1, yielded)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for item, err := range AllBlobs(cancelled, store) {
+		assert.Nil(t, item)
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+}
@@ -0,0 +1,84 @@
+package azblob
+
+import (
+	"context"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// DeleteMany deletes the blobs one at a time. See Storer.DeleteMany for the
+// results and options.
+func (s *localStorer) DeleteMany(ctx context.Context, identities []string, opts ...Option) ([]BatchResult, error) {
+	logger.Sugar.Infof("DeleteMany %d blobs", len(identities))
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	results := newBatchResults(identities)
+	for i, identity := range identities {
+		switch {
+		case ctx.Err() != nil:
+			results[i].Err = ErrorFromError(ctx.Err())
+		case options.dryRun:
+			logger.Sugar.Infof("DeleteMany dry run: would delete %s", identity)
+		default:
+			results[i].Err = batchItemError(s.Delete(ctx, identity, WithDeleteSnapshots(options.deleteSnapshots)))
+		}
+	}
+	return results, ctx.Err()
+}
+
+// SetTagsMany replaces the tags of each of the blobs. See Storer.SetTagsMany
+// for the results and options.
+func (s *localStorer) SetTagsMany(
+	ctx context.Context, identities []string, tags map[string]string, opts ...Option,
+) ([]BatchResult, error) {
+	logger.Sugar.Infof("SetTagsMany %d blobs", len(identities))
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	results := newBatchResults(identities)
+	for i, identity := range identities {
+		switch {
+		case ctx.Err() != nil:
+			results[i].Err = ErrorFromError(ctx.Err())
+		case options.dryRun:
+			logger.Sugar.Infof("SetTagsMany dry run: would tag %s with %v", identity, tags)
+		default:
+			results[i].Err = batchItemError(s.setTags(identity, tags, options))
+		}
+	}
+	return results, ctx.Err()
+}
+
+// setTags replaces the tags of the blob. As for azure, the etag and last
+// modified time are unchanged, and a leased blob can be tagged without the
+// lease id.
+func (s *localStorer) setTags(identity string, tags map[string]string, options *StorerOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(identity, false)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if blob == nil {
+		return localNotFound(identity)
+	}
+	if options.leaseID != "" {
+		if err = s.checkLease(identity, blob, options.leaseID); err != nil {
+			return err
+		}
+	}
+	if err = s.checkWriteConditions(identity, blob, options); err != nil {
+		return err
+	}
+	blob.Tags = copyStringMap(tags)
+	if err = s.records.store(identity, blob); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func localContainerNotFound(container string) *Error {
+	return newStorageCodeError(
+		azStorageBlob.StorageErrorCodeContainerNotFound, http.StatusNotFound,
+		fmt.Sprintf("container %s not found", container))
+}
+
+// sourceRecords returns the records holding the source of a copy. Only a
+// DirStorer can copy from another container.
+func (s *localStorer) sourceRecords(options *StorerOptions) (localRecords, error) {
+	if options.copySourceContainer == "" || options.copySourceContainer == s.container {
+		return s.records, nil
+	}
+	if s.containerRecords == nil {
+		return nil, localContainerNotFound(options.copySourceContainer)
+	}
+	return s.containerRecords(options.copySourceContainer)
+}
+
+// Copy copies the source blob to the destination blob. See Storer.Copy for
+// the options. The copy always completes immediately.
+func (s *localStorer) Copy(ctx context.Context, source string, destination string, opts ...Option) (*WriteResponse, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Debugf("Copy %s container '%s' to %s", source, options.copySourceContainer, destination)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.sourceRecords(options)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := s.loadCopySource(records, source, options)
+	if err != nil {
+		return nil, err
+	}
+	return s.copyBlob(blob, destination, options)
+}
+
+// loadCopySource loads the source blob, with its content, applying the source
+// etag condition
+func (s *localStorer) loadCopySource(records localRecords, source string, options *StorerOptions) (*localBlob, error) {
+	key, err := localVersionKey(source, options)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := records.load(key, true)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if blob == nil {
+		return nil, localNotFound(source)
+	}
+	if options.copySourceEtag != "" && options.copySourceEtag != "*" && options.copySourceEtag != blob.ETag {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeSourceConditionNotMet, http.StatusPreconditionFailed,
+			fmt.Sprintf("condition not met for source blob %s", source))
+	}
+	return blob, nil
+}
+
+// copyBlob writes source to the destination. The caller must hold s.mu.
+func (s *localStorer) copyBlob(source *localBlob, destination string, options *StorerOptions) (*WriteResponse, error) {
+	existing, err := s.records.load(destination, false)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if err = s.checkLease(destination, existing, options.leaseID); err != nil {
+		return nil, err
+	}
+	if err = s.checkWriteConditions(destination, existing, options); err != nil {
+		return nil, err
+	}
+
+	blob := &localBlob{
+		Data:         source.Data,
+		Size:         source.Size,
+		ETag:         s.nextETag(),
+		LastModified: s.now(),
+		Metadata:     copyStringMap(source.Metadata),
+		Tags:         copyStringMap(source.Tags),
+		BlobType:     source.BlobType,
+		Blocks:       source.Blocks,
+	}
+	if options.metadata != nil {
+		blob.Metadata = copyStringMap(options.metadata)
+	}
+	if options.tags != nil {
+		blob.Tags = copyStringMap(options.tags)
+	}
+	if existing != nil {
+		blob.localLease = existing.localLease
+	}
+	if err = s.records.store(destination, blob); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	wr := localWriteResponse(blob)
+	wr.StatusCode = http.StatusAccepted
+	wr.Status = "202 Accepted"
+	wr.CopyID = uuid.NewString()
+	return wr, nil
+}
+
+// Move copies the source blob to the destination blob and deletes the
+// source. See Storer.Move for the options. Unlike Storer, the copy and delete
+// are atomic.
+func (s *localStorer) Move(ctx context.Context, source string, destination string, opts ...Option) (*WriteResponse, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Infof("Move %s container '%s' to %s", source, options.copySourceContainer, destination)
+	if err := checkMoveOptions(s.container, source, destination, options); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.sourceRecords(options)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := s.loadCopySource(records, source, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the source can be deleted before changing anything
+	if err = s.checkLease(source, blob, ""); err != nil {
+		return nil, err
+	}
+	snapshots, err := localSnapshotNames(records, source)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) > 0 {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeSnapshotsPresent, http.StatusConflict,
+			fmt.Sprintf("blob %s has snapshots", source))
+	}
+
+	wr, err := s.copyBlob(blob, destination, options)
+	if err != nil {
+		return nil, err
+	}
+	if err = records.remove(source); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return wr, nil
+}
@@ -0,0 +1,135 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorerCopy(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Copy(ctx, "missing", "copy")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			w, err := store.Put(ctx, "source", NewBytesReaderCloser([]byte("VALUE")),
+				WithMetadata(map[string]string{"colour": "red"}), WithTags(map[string]string{"shape": "round"}))
+			require.NoError(t, err)
+
+			// the metadata and tags are copied
+			cw, err := store.Copy(ctx, "source", "copy")
+			require.NoError(t, err)
+			assert.NotEmpty(t, cw.CopyID)
+			assert.NotEqual(t, *w.ETag, *cw.ETag)
+			rr, err := store.Reader(ctx, "copy", WithGetMetadata(BothMetadataAndBlob), WithGetTags())
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+			assert.Equal(t, "red", rr.Metadata["Colour"])
+			assert.Equal(t, map[string]string{"shape": "round"}, rr.Tags)
+
+			// or overridden
+			_, err = store.Copy(ctx, "source", "copy",
+				WithMetadata(map[string]string{"colour": "blue"}), WithTags(map[string]string{"shape": "square"}))
+			require.NoError(t, err)
+			rr, err = store.Reader(ctx, "copy", WithGetMetadata(BothMetadataAndBlob), WithGetTags())
+			require.NoError(t, err)
+			readAll(t, rr)
+			assert.Equal(t, "blue", rr.Metadata["Colour"])
+			assert.Equal(t, map[string]string{"shape": "square"}, rr.Tags)
+
+			// conditions on the source and on the destination
+			_, err = store.Copy(ctx, "source", "copy", WithCopySourceEtagMatch("\"stale\""))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+			_, err = store.Copy(ctx, "source", "copy", WithEtagNoneMatch("*"))
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+			_, err = store.Copy(ctx, "source", "copy", WithCopySourceEtagMatch(*w.ETag))
+			require.NoError(t, err)
+
+			// a snapshot can be copied
+			sw, err := store.Snapshot(ctx, "source")
+			require.NoError(t, err)
+			_, err = store.Put(ctx, "source", NewBytesReaderCloser([]byte("CHANGED")))
+			require.NoError(t, err)
+			_, err = store.Copy(ctx, "source", "copy", WithSnapshot(sw.Snapshot))
+			require.NoError(t, err)
+			rr, err = store.Reader(ctx, "copy", WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+
+			_, err = store.Copy(ctx, "source", "copy", WithCopySourceContainer("nosuchcontainer"))
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+		})
+	}
+}
+
+func TestLocalStorerMove(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			w, err := store.Put(ctx, "source", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+
+			_, err = store.Move(ctx, "source", "source")
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+			// a stale etag means the source was written since it was read
+			_, err = store.Move(ctx, "source", "moved", WithCopySourceEtagMatch("\"stale\""))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			// a leased source is not moved, and the destination is untouched
+			leaseID, err := store.AcquireLease(ctx, "source", 15)
+			require.NoError(t, err)
+			_, err = store.Move(ctx, "source", "moved")
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+			_, err = store.Reader(ctx, "moved")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			require.NoError(t, store.ReleaseLease(ctx, "source", leaseID))
+
+			_, err = store.Move(ctx, "source", "moved", WithCopySourceEtagMatch(*w.ETag))
+			require.NoError(t, err)
+			_, err = store.Reader(ctx, "source")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			rr, err := store.Reader(ctx, "moved", WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+		})
+	}
+}
+
+func TestDirStorerCopyBetweenContainers(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	root := t.TempDir()
+	incoming, err := NewDirStorer(root, "incoming")
+	require.NoError(t, err)
+	accepted, err := NewDirStorer(root, "accepted")
+	require.NoError(t, err)
+
+	_, err = incoming.Put(ctx, "upload", NewBytesReaderCloser([]byte("VALUE")))
+	require.NoError(t, err)
+
+	_, err = accepted.Move(ctx, "upload", "upload", WithCopySourceContainer("incoming"))
+	require.NoError(t, err)
+
+	_, err = incoming.Reader(ctx, "upload")
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+	rr, err := accepted.Reader(ctx, "upload", WithGetMetadata(BothMetadataAndBlob))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+}
@@ -0,0 +1,153 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// Delete the identified blob. See Storer.Delete for the options. Deleted
+// blobs and snapshots are kept, until they are next deleted, so that they can
+// be restored by Undelete.
+func (s *localStorer) Delete(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) error {
+	logger.Sugar.Debugf("Delete blob %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkDeleteOptions(options); err != nil {
+		return err
+	}
+	key, err := localVersionKey(identity, options)
+	if err != nil {
+		// there are no versions, so there is nothing to delete
+		if ErrorFromError(err).StatusCode() == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(key, false)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if blob == nil {
+		return nil
+	}
+	// snapshots can't be leased
+	if options.snapshot == "" {
+		if err = s.checkLease(identity, blob, options.leaseID); err != nil {
+			return err
+		}
+	}
+	if err = s.checkWriteConditions(identity, blob, options); err != nil {
+		return err
+	}
+	if options.snapshot != "" {
+		return s.softDelete(key)
+	}
+
+	snapshots, err := s.snapshotNames(identity)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 && options.deleteSnapshots == DeleteSnapshotsNone {
+		return newStorageCodeError(
+			azStorageBlob.StorageErrorCodeSnapshotsPresent, http.StatusConflict,
+			fmt.Sprintf("blob %s has snapshots", identity))
+	}
+	for _, snapshot := range snapshots {
+		if err = s.softDelete(snapshot); err != nil {
+			return err
+		}
+	}
+	if options.deleteSnapshots == DeleteSnapshotsOnly {
+		return nil
+	}
+	return s.softDelete(identity)
+}
+
+// softDelete moves the record to its deleted name, replacing any earlier
+// deleted record of the same name. The caller must hold s.mu.
+func (s *localStorer) softDelete(key string) error {
+	blob, err := s.records.load(key, true)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if blob == nil {
+		return nil
+	}
+	if blob.Data == nil {
+		blob.Data = []byte{}
+	}
+	blob.localLease = localLease{}
+	if err = s.records.store(key+localDeletedSep, blob); err != nil {
+		return ErrorFromError(err)
+	}
+	if err = s.records.remove(key); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// Undelete restores the deleted blob and its deleted snapshots. As for azure,
+// a deleted record is not restored over one that exists. It is not an error if
+// the blob was not deleted, but it is if it does not exist at all.
+func (s *localStorer) Undelete(ctx context.Context, identity string) error {
+	logger.Sugar.Infof("Undelete blob %s", identity)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.records.names()
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	found := false
+	for _, name := range names {
+		if name == identity {
+			found = true
+			continue
+		}
+		if name != identity+localDeletedSep &&
+			!(strings.HasPrefix(name, identity+localSnapshotSep) && strings.HasSuffix(name, localDeletedSep)) {
+			continue
+		}
+		found = true
+		key := strings.TrimSuffix(name, localDeletedSep)
+		existing, err := s.records.load(key, false)
+		if err != nil {
+			return ErrorFromError(err)
+		}
+		if existing != nil {
+			continue
+		}
+		blob, err := s.records.load(name, true)
+		if err != nil {
+			return ErrorFromError(err)
+		}
+		if err = s.records.store(key, blob); err != nil {
+			return ErrorFromError(err)
+		}
+		if err = s.records.remove(name); err != nil {
+			return ErrorFromError(err)
+		}
+	}
+	if !found {
+		return localNotFound(identity)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorerDeleteConditions(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			w, err := store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("VALUE")),
+				WithTags(map[string]string{"retain": "false"}))
+			require.NoError(t, err)
+
+			// a concurrent write changed the blob since it was read
+			_, err = store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("CHANGED")),
+				WithTags(map[string]string{"retain": "false"}))
+			require.NoError(t, err)
+			err = store.Delete(ctx, "evidence", WithEtagMatch(*w.ETag))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			err = store.Delete(ctx, "evidence", WithWhereTags("retain='true'"))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			leaseID, err := store.AcquireLease(ctx, "evidence", 15)
+			require.NoError(t, err)
+			err = store.Delete(ctx, "evidence")
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+			require.NoError(t, store.Delete(ctx, "evidence", WithLeaseID(leaseID), WithWhereTags("retain='false'")))
+
+			_, err = store.Reader(ctx, "evidence")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			// as before, deleting a blob that does not exist is not an error
+			require.NoError(t, store.Delete(ctx, "evidence", WithEtagMatch(*w.ETag)))
+		})
+	}
+}
+
+func TestLocalStorerDeleteSnapshots(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+			first, err := store.Snapshot(ctx, "evidence")
+			require.NoError(t, err)
+			second, err := store.Snapshot(ctx, "evidence")
+			require.NoError(t, err)
+
+			err = store.Delete(ctx, "evidence", WithSnapshot(first.Snapshot), WithDeleteSnapshots(DeleteSnapshotsInclude))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+			require.NoError(t, store.Delete(ctx, "evidence", WithSnapshot(first.Snapshot)))
+			versions, err := store.ListVersions(ctx, "evidence")
+			require.NoError(t, err)
+			require.Len(t, versions, 2)
+			assert.Equal(t, second.Snapshot, *versions[0].Snapshot)
+
+			require.NoError(t, store.Delete(ctx, "evidence", WithDeleteSnapshots(DeleteSnapshotsOnly)))
+			versions, err = store.ListVersions(ctx, "evidence")
+			require.NoError(t, err)
+			assert.Len(t, versions, 1)
+
+			_, err = store.Snapshot(ctx, "evidence")
+			require.NoError(t, err)
+			require.NoError(t, store.Delete(ctx, "evidence", WithDeleteSnapshots(DeleteSnapshotsInclude)))
+			versions, err = store.ListVersions(ctx, "evidence")
+			require.NoError(t, err)
+			assert.Len(t, versions, 0)
+		})
+	}
+}
+
+func TestLocalStorerUndelete(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			err := store.Undelete(ctx, "evidence")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			_, err = store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("VALUE")),
+				WithTags(map[string]string{"retain": "true"}))
+			require.NoError(t, err)
+			require.NoError(t, store.Undelete(ctx, "evidence"), "not deleted")
+			sw, err := store.Snapshot(ctx, "evidence")
+			require.NoError(t, err)
+			require.NoError(t, store.Delete(ctx, "evidence", WithDeleteSnapshots(DeleteSnapshotsInclude)))
+
+			// deleted blobs are not listed or counted
+			r, err := store.List(ctx)
+			require.NoError(t, err)
+			assert.Len(t, r.Items, 0)
+			count, err := store.Count(ctx, "retain='true'")
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), count)
+
+			require.NoError(t, store.Undelete(ctx, "evidence"))
+			rr, err := store.Reader(ctx, "evidence", WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+			rr, err = store.Reader(ctx, "evidence", WithSnapshot(sw.Snapshot))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+			count, err = store.Count(ctx, "retain='true'")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+		})
+	}
+}
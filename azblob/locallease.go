@@ -0,0 +1,303 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	localMinLeaseSecs = 15
+	localMaxLeaseSecs = 60
+	localMaxBreakSecs = 60
+)
+
+// localLease is the state of a lease on a blob or on the container for the
+// non azure backends, following the azure lease state diagram.
+//
+// LeaseID is empty if there has never been a lease or it was released. A zero
+// LeaseExpires with a LeaseID means the lease is infinite. LeaseBroken is set
+// when the lease is broken, to the end of the break period.
+type localLease struct {
+	LeaseID       string    `json:"leaseId,omitempty"`
+	LeaseDuration int32     `json:"leaseDuration,omitempty"`
+	LeaseExpires  time.Time `json:"leaseExpires,omitempty"`
+	LeaseBroken   time.Time `json:"leaseBroken,omitempty"`
+}
+
+func (l *localLease) leaseState(now time.Time) azStorageBlob.LeaseStateType {
+	switch {
+	case l.LeaseID == "":
+		return azStorageBlob.LeaseStateTypeAvailable
+	case !l.LeaseBroken.IsZero() && now.Before(l.LeaseBroken):
+		return azStorageBlob.LeaseStateTypeBreaking
+	case !l.LeaseBroken.IsZero():
+		return azStorageBlob.LeaseStateTypeBroken
+	case !l.LeaseExpires.IsZero() && !now.Before(l.LeaseExpires):
+		return azStorageBlob.LeaseStateTypeExpired
+	default:
+		return azStorageBlob.LeaseStateTypeLeased
+	}
+}
+
+// leaseActive is true if the lease id is required to modify the blob, which
+// remains the case while the lease is breaking
+func (l *localLease) leaseActive(now time.Time) bool {
+	state := l.leaseState(now)
+	return state == azStorageBlob.LeaseStateTypeLeased || state == azStorageBlob.LeaseStateTypeBreaking
+}
+
+// localLeaseExpiry returns the expiry of a lease acquired now
+func localLeaseExpiry(leaseTimeout int32, now time.Time) (time.Time, error) {
+	if leaseTimeout == InfiniteLease {
+		return time.Time{}, nil
+	}
+	if leaseTimeout < localMinLeaseSecs || leaseTimeout > localMaxLeaseSecs {
+		return time.Time{}, NewStatusError(
+			fmt.Sprintf("lease duration %d must be -1 or between %d and %d seconds",
+				leaseTimeout, localMinLeaseSecs, localMaxLeaseSecs),
+			http.StatusBadRequest)
+	}
+	return now.Add(time.Duration(leaseTimeout) * time.Second), nil
+}
+
+func localLeaseMismatch(what string) *Error {
+	return newStorageCodeError(
+		azStorageBlob.StorageErrorCodeLeaseIDMismatchWithLeaseOperation, http.StatusConflict,
+		fmt.Sprintf("lease id does not match the lease for %s", what))
+}
+
+// acquire takes a new lease. what describes the leased object for errors.
+func (l *localLease) acquire(what string, leaseTimeout int32, now time.Time) (string, error) {
+	expires, err := localLeaseExpiry(leaseTimeout, now)
+	if err != nil {
+		return "", err
+	}
+	switch l.leaseState(now) {
+	case azStorageBlob.LeaseStateTypeLeased:
+		return "", newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseAlreadyPresent, http.StatusConflict,
+			fmt.Sprintf("%s already has an active lease", what))
+	case azStorageBlob.LeaseStateTypeBreaking:
+		return "", newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseIsBreakingAndCannotBeAcquired, http.StatusConflict,
+			fmt.Sprintf("the lease on %s is breaking", what))
+	default:
+	}
+	*l = localLease{
+		LeaseID:       uuid.NewString(),
+		LeaseDuration: leaseTimeout,
+		LeaseExpires:  expires,
+	}
+	return l.LeaseID, nil
+}
+
+// renew extends the lease by the duration it was acquired with
+func (l *localLease) renew(what string, leaseID string, now time.Time) error {
+	if l.LeaseID != leaseID {
+		return localLeaseMismatch(what)
+	}
+	switch l.leaseState(now) {
+	case azStorageBlob.LeaseStateTypeBreaking, azStorageBlob.LeaseStateTypeBroken:
+		return newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseIsBrokenAndCannotBeRenewed, http.StatusConflict,
+			fmt.Sprintf("the lease on %s is broken", what))
+	default:
+	}
+	expires, err := localLeaseExpiry(l.LeaseDuration, now)
+	if err != nil {
+		return err
+	}
+	l.LeaseExpires = expires
+	return nil
+}
+
+// change replaces the id of an active lease
+func (l *localLease) change(what string, leaseID string, proposedLeaseID string, now time.Time) (string, error) {
+	if proposedLeaseID == "" {
+		proposedLeaseID = uuid.NewString()
+	}
+	if _, err := uuid.Parse(proposedLeaseID); err != nil {
+		return "", NewStatusError(
+			fmt.Sprintf("proposed lease id %s is not a GUID", proposedLeaseID), http.StatusBadRequest)
+	}
+	state := l.leaseState(now)
+	if state == azStorageBlob.LeaseStateTypeLeased && l.LeaseID == proposedLeaseID {
+		// azure treats changing to the current id as success
+		return l.LeaseID, nil
+	}
+	if l.LeaseID != leaseID {
+		return "", localLeaseMismatch(what)
+	}
+	switch state {
+	case azStorageBlob.LeaseStateTypeBreaking:
+		return "", newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseIsBreakingAndCannotBeChanged, http.StatusConflict,
+			fmt.Sprintf("the lease on %s is breaking", what))
+	case azStorageBlob.LeaseStateTypeLeased:
+	default:
+		return "", newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseNotPresentWithLeaseOperation, http.StatusConflict,
+			fmt.Sprintf("%s has no active lease", what))
+	}
+	l.LeaseID = proposedLeaseID
+	return l.LeaseID, nil
+}
+
+// release ends the lease, in any state, provided the id matches
+func (l *localLease) release(what string, leaseID string) error {
+	if l.LeaseID != leaseID {
+		return localLeaseMismatch(what)
+	}
+	*l = localLease{}
+	return nil
+}
+
+// breakLease breaks the lease, see Storer.BreakLease for the semantics of
+// breakPeriod. Returns the seconds remaining until the lease is broken.
+func (l *localLease) breakLease(what string, breakPeriod int32, now time.Time) (int32, error) {
+	if breakPeriod > localMaxBreakSecs {
+		return 0, NewStatusError(
+			fmt.Sprintf("break period %d must be between 0 and %d seconds", breakPeriod, localMaxBreakSecs),
+			http.StatusBadRequest)
+	}
+	switch l.leaseState(now) {
+	case azStorageBlob.LeaseStateTypeAvailable:
+		return 0, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseNotPresentWithLeaseOperation, http.StatusConflict,
+			fmt.Sprintf("%s has no lease to break", what))
+	case azStorageBlob.LeaseStateTypeExpired:
+		l.LeaseBroken = now
+		return 0, nil
+	case azStorageBlob.LeaseStateTypeBroken:
+		return 0, nil
+	default:
+	}
+
+	breakAt := l.LeaseExpires
+	if breakPeriod >= 0 {
+		breakAt = now.Add(time.Duration(breakPeriod) * time.Second)
+		if !l.LeaseExpires.IsZero() && l.LeaseExpires.Before(breakAt) {
+			breakAt = l.LeaseExpires
+		}
+	}
+	if breakAt.IsZero() || breakAt.Before(now) {
+		// an infinite lease broken without a period
+		breakAt = now
+	}
+	// breaking again can only shorten the break period
+	if l.LeaseBroken.IsZero() || breakAt.Before(l.LeaseBroken) {
+		l.LeaseBroken = breakAt
+	}
+	return int32(math.Ceil(l.LeaseBroken.Sub(now).Seconds())), nil
+}
+
+// BreakLease breaks the lease on a blob, see Storer.BreakLease
+func (s *localStorer) BreakLease(ctx context.Context, objectname string, breakPeriod int32) (int32, error) {
+	logger.Sugar.Infof("BreakLease: %v period %d", objectname, breakPeriod)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(objectname, false)
+	if err != nil {
+		return 0, ErrorFromError(err)
+	}
+	if blob == nil {
+		return 0, localNotFound(objectname)
+	}
+	remaining, err := blob.breakLease("blob "+objectname, breakPeriod, time.Now())
+	if err != nil {
+		logger.Sugar.Infof("failed to break lease %s: %v", objectname, err)
+		return 0, err
+	}
+	if err = s.records.store(objectname, blob); err != nil {
+		return 0, ErrorFromError(err)
+	}
+	return remaining, nil
+}
+
+// ChangeLease changes the id of the active lease on a blob, see
+// Storer.ChangeLease
+func (s *localStorer) ChangeLease(
+	ctx context.Context, objectname string, leaseID string, proposedLeaseID string,
+) (string, error) {
+	logger.Sugar.Debugf("ChangeLease: %v", objectname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(objectname, false)
+	if err != nil {
+		return "", ErrorFromError(err)
+	}
+	if blob == nil {
+		return "", localNotFound(objectname)
+	}
+	newID, err := blob.change("blob "+objectname, leaseID, proposedLeaseID, time.Now())
+	if err != nil {
+		logger.Sugar.Infof("failed to change lease %s: %v", objectname, err)
+		return "", err
+	}
+	if err = s.records.store(objectname, blob); err != nil {
+		return "", ErrorFromError(err)
+	}
+	return newID, nil
+}
+
+func (s *localStorer) containerLeaseFor() string {
+	return "container " + s.container
+}
+
+// AcquireContainerLease gets a lease on the container, see
+// Storer.AcquireContainerLease
+func (s *localStorer) AcquireContainerLease(ctx context.Context, leaseTimeout int32) (string, error) {
+	logger.Sugar.Debugf("AcquireContainerLease: %v", s.container)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containerLease.acquire(s.containerLeaseFor(), leaseTimeout, time.Now())
+}
+
+// RenewContainerLease renews the lease on the container
+func (s *localStorer) RenewContainerLease(ctx context.Context, leaseID string) error {
+	logger.Sugar.Debugf("RenewContainerLease: %v", s.container)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containerLease.renew(s.containerLeaseFor(), leaseID, time.Now())
+}
+
+// ReleaseContainerLease releases the lease on the container
+func (s *localStorer) ReleaseContainerLease(ctx context.Context, leaseID string) error {
+	logger.Sugar.Debugf("ReleaseContainerLease: %v", s.container)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containerLease.release(s.containerLeaseFor(), leaseID)
+}
+
+// BreakContainerLease breaks the lease on the container, see Storer.BreakLease
+func (s *localStorer) BreakContainerLease(ctx context.Context, breakPeriod int32) (int32, error) {
+	logger.Sugar.Infof("BreakContainerLease: %v period %d", s.container, breakPeriod)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containerLease.breakLease(s.containerLeaseFor(), breakPeriod, time.Now())
+}
+
+// ChangeContainerLease changes the id of the active lease on the container
+func (s *localStorer) ChangeContainerLease(ctx context.Context, leaseID string, proposedLeaseID string) (string, error) {
+	logger.Sugar.Debugf("ChangeContainerLease: %v", s.container)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containerLease.change(s.containerLeaseFor(), leaseID, proposedLeaseID, time.Now())
+}
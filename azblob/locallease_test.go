@@ -0,0 +1,163 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalLeaseStates(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	now := time.Now()
+	l := &localLease{}
+	assert.Equal(t, azStorageBlob.LeaseStateTypeAvailable, l.leaseState(now))
+
+	_, err := l.breakLease("blob b", 0, now)
+	assert.Equal(t, string(azStorageBlob.StorageErrorCodeLeaseNotPresentWithLeaseOperation), ErrorFromError(err).StorageErrorCode())
+
+	leaseID, err := l.acquire("blob b", 30, now)
+	require.NoError(t, err)
+	assert.Equal(t, azStorageBlob.LeaseStateTypeLeased, l.leaseState(now))
+
+	// the break period is cut short by the expiry of the lease
+	remaining, err := l.breakLease("blob b", 60, now)
+	require.NoError(t, err)
+	assert.Equal(t, int32(30), remaining)
+	assert.Equal(t, azStorageBlob.LeaseStateTypeBreaking, l.leaseState(now))
+	assert.True(t, l.leaseActive(now))
+
+	// breaking again can only shorten the period
+	remaining, err = l.breakLease("blob b", 45, now)
+	require.NoError(t, err)
+	assert.Equal(t, int32(30), remaining)
+	remaining, err = l.breakLease("blob b", 10, now)
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), remaining)
+
+	_, err = l.acquire("blob b", 15, now)
+	assert.Equal(t, string(azStorageBlob.StorageErrorCodeLeaseIsBreakingAndCannotBeAcquired), ErrorFromError(err).StorageErrorCode())
+	err = l.renew("blob b", leaseID, now)
+	assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+	_, err = l.change("blob b", leaseID, "", now)
+	assert.Equal(t, string(azStorageBlob.StorageErrorCodeLeaseIsBreakingAndCannotBeChanged), ErrorFromError(err).StorageErrorCode())
+
+	later := now.Add(10 * time.Second)
+	assert.Equal(t, azStorageBlob.LeaseStateTypeBroken, l.leaseState(later))
+	assert.False(t, l.leaseActive(later))
+	err = l.renew("blob b", leaseID, later)
+	assert.Equal(t, string(azStorageBlob.StorageErrorCodeLeaseIsBrokenAndCannotBeRenewed), ErrorFromError(err).StorageErrorCode())
+
+	_, err = l.acquire("blob b", InfiniteLease, later)
+	require.NoError(t, err)
+	assert.Equal(t, azStorageBlob.LeaseStateTypeLeased, l.leaseState(later.Add(time.Hour)))
+
+	// an infinite lease broken without a period is broken immediately
+	remaining, err = l.breakLease("blob b", -1, later)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), remaining)
+	assert.Equal(t, azStorageBlob.LeaseStateTypeBroken, l.leaseState(later))
+
+	_, err = l.breakLease("blob b", 61, later)
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
+
+func TestLocalStorerBreakChangeLease(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.BreakLease(ctx, "missing", 0)
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			// a crashed holder's infinite lease is recovered by breaking it
+			leaseID, err := store.AcquireLease(ctx, "leased", InfiniteLease)
+			require.NoError(t, err)
+			remaining, err := store.BreakLease(ctx, "leased", 0)
+			require.NoError(t, err)
+			assert.Equal(t, int32(0), remaining)
+
+			_, err = store.Put(ctx, "leased", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(leaseID))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			leaseID, err = store.AcquireLease(ctx, "leased", 15)
+			require.NoError(t, err)
+
+			// hand the lease over to a new id
+			_, err = store.ChangeLease(ctx, "leased", "not-the-lease", "")
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+			_, err = store.ChangeLease(ctx, "leased", leaseID, "not-a-guid")
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+			newID, err := store.ChangeLease(ctx, "leased", leaseID, "")
+			require.NoError(t, err)
+			assert.NotEqual(t, leaseID, newID)
+
+			_, err = store.Put(ctx, "leased", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(leaseID))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+			_, err = store.Put(ctx, "leased", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(newID))
+			require.NoError(t, err)
+
+			// while breaking, the lease is still required to write
+			remaining, err = store.BreakLease(ctx, "leased", 15)
+			require.NoError(t, err)
+			assert.Greater(t, remaining, int32(0))
+			_, err = store.Put(ctx, "leased", NewBytesReaderCloser([]byte("VALUE")))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			r, err := store.List(ctx, WithListPrefix("leased"))
+			require.NoError(t, err)
+			require.Len(t, r.Items, 1)
+			assert.Equal(t, azStorageBlob.LeaseStateTypeBreaking, *r.Items[0].Properties.LeaseState)
+
+			require.NoError(t, store.ReleaseLease(ctx, "leased", newID))
+			require.NoError(t, store.Delete(ctx, "leased"))
+		})
+	}
+}
+
+func TestLocalStorerContainerLease(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.AcquireContainerLease(ctx, 10)
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+			leaseID, err := store.AcquireContainerLease(ctx, 15)
+			require.NoError(t, err)
+			_, err = store.AcquireContainerLease(ctx, 15)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+			require.NoError(t, store.RenewContainerLease(ctx, leaseID))
+
+			newID, err := store.ChangeContainerLease(ctx, leaseID, "")
+			require.NoError(t, err)
+			err = store.RenewContainerLease(ctx, leaseID)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			_, err = store.BreakContainerLease(ctx, 0)
+			require.NoError(t, err)
+			err = store.RenewContainerLease(ctx, newID)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			leaseID, err = store.AcquireContainerLease(ctx, InfiniteLease)
+			require.NoError(t, err)
+			require.NoError(t, store.ReleaseContainerLease(ctx, leaseID))
+			_, err = store.BreakContainerLease(ctx, 0)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package azblob
+
+import (
+	"context"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// MarkScanned records the scan result in the metadata and tags of the blob.
+// See Storer.MarkScanned for the options.
+func (s *localStorer) MarkScanned(ctx context.Context, identity string, result ScanResult, opts ...Option) (*WriteResponse, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Infof("MarkScanned %s %s", identity, result.State)
+	if err := checkScanOptions(options); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(identity, true)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if blob == nil {
+		return nil, localNotFound(identity)
+	}
+	copyOptions := *options
+	copyOptions.metadata, copyOptions.tags = scanResultMetadata(blob.Metadata, blob.Tags, result)
+	return s.copyBlob(blob, identity, &copyOptions)
+}
@@ -0,0 +1,96 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorerMarkScanned(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.MarkScanned(ctx, "missing", ScanResult{State: ScanClean})
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			w, err := store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("VALUE")),
+				WithMetadata(map[string]string{"origin": "test"}), WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+
+			// unscanned blobs are pending, and can only be read if allowed
+			rr, err := store.Reader(ctx, "evidence", WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			readAll(t, rr)
+			assert.Equal(t, ScanPending, rr.ScanResult().State)
+			assert.Empty(t, rr.ScannedStatus, "the scanned status is only set with WithSetScannedStatus")
+			_, err = store.Reader(ctx, "evidence", WithScanStates(ScanClean))
+			assert.True(t, errors.Is(err, ErrScanStateNotAllowed))
+			assert.Equal(t, http.StatusForbidden, ErrorFromError(err).StatusCode())
+			rr, err = store.Reader(ctx, "evidence", WithScanStates(ScanPending, ScanClean))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+
+			// the etag of the scanned content must still match
+			_, err = store.MarkScanned(ctx, "evidence", ScanResult{State: ScanBad}, WithEtagMatch("\"stale\""))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			mw, err := store.MarkScanned(ctx, "evidence",
+				ScanResult{State: ScanBad, BadReason: "EICAR"}, WithEtagMatch(*w.ETag))
+			require.NoError(t, err)
+			assert.NotEqual(t, *w.ETag, *mw.ETag)
+
+			rr, err = store.Reader(ctx, "evidence", WithGetMetadata(BothMetadataAndBlob), WithGetTags())
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+			assert.Equal(t, ScanBad, rr.ScanResult().State)
+			assert.Equal(t, "EICAR", rr.ScanResult().BadReason)
+			assert.False(t, rr.ScanResult().Timestamp.IsZero())
+			assert.Equal(t, "test", rr.Metadata["Origin"])
+			assert.Equal(t, map[string]string{"owner": "tenant1", ScannedStatusTag: "bad"}, rr.Tags)
+
+			// a rescan replaces the result, and the reason is dropped
+			_, err = store.MarkScanned(ctx, "evidence", ScanResult{State: ScanClean})
+			require.NoError(t, err)
+			rr, err = store.Reader(ctx, "evidence", WithScanStates(ScanClean), WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+			assert.Equal(t, ScanClean, rr.ScanResult().State)
+			assert.Empty(t, rr.ScanResult().BadReason)
+
+			fr, err := store.FilteredList(ctx, `"scanned_status"='clean'`)
+			require.NoError(t, err)
+			require.Len(t, fr.Items, 1)
+			assert.Equal(t, "evidence", *fr.Items[0].Name)
+		})
+	}
+}
+
+func TestLocalStorerDownloadScanStates(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	store := NewMemStorer("devcontainer")
+	_, err := store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("VALUE")))
+	require.NoError(t, err)
+
+	w := &writerAtBuffer{data: make([]byte, 5)}
+	_, err = store.DownloadToWriterAt(ctx, "evidence", w, WithScanStates(ScanClean))
+	assert.True(t, errors.Is(err, ErrScanStateNotAllowed))
+
+	_, err = store.MarkScanned(ctx, "evidence", ScanResult{State: ScanClean})
+	require.NoError(t, err)
+	_, err = store.DownloadToWriterAt(ctx, "evidence", w, WithScanStates(ScanClean))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("VALUE"), w.data)
+}
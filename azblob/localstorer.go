@@ -0,0 +1,1063 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	localDefaultMaxResults = 5000
+)
+
+// localBlob is the stored state of a single blob for the non azure backends.
+type localBlob struct {
+	Data         []byte            `json:"-"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"lastModified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+
+	// BlobType is empty for a block blob. Blocks is only maintained for
+	// append blobs.
+	BlobType azStorageBlob.BlobType `json:"blobType,omitempty"`
+	Blocks   int32                  `json:"blocks,omitempty"`
+
+	// AccessTier is empty for the default tier, hot. Rehydration from the
+	// archive tier is immediate, so there is no archive status.
+	AccessTier AccessTier `json:"accessTier,omitempty"`
+
+	localLease
+}
+
+// localRecords persists blobs for a localStorer. Implementations do not need
+// to be safe for concurrent use, localStorer serialises all access.
+type localRecords interface {
+	// load returns nil, nil if the blob does not exist. If withData is false
+	// the returned Data may be nil.
+	load(identity string, withData bool) (*localBlob, error)
+	// store creates or replaces the blob. If blob.Data is nil the stored
+	// content is left unchanged.
+	store(identity string, blob *localBlob) error
+	remove(identity string) error
+	// names returns the identities of all blobs in lexical order
+	names() ([]string, error)
+}
+
+// localStorer implements Store with the etag, lease, metadata and tag semantics
+// of azure blob storage on top of a localRecords implementation.
+type localStorer struct {
+	container string
+	records   localRecords
+	// containerRecords returns the records of another container, for copies
+	// between containers. nil if the backend has no other containers.
+	containerRecords func(container string) (localRecords, error)
+
+	mu           sync.Mutex
+	seq          uint64
+	lastSnapshot time.Time
+
+	// containerLease is not persisted, so for a DirStorer it only applies
+	// within this process
+	containerLease localLease
+}
+
+func newLocalStorer(container string, records localRecords) *localStorer {
+	return &localStorer{
+		container: container,
+		records:   records,
+	}
+}
+
+// now returns the current time truncated to the one second resolution azure
+// uses for Last-Modified.
+func (s *localStorer) now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// nextETag returns a new etag in the same quoted form that azure uses.
+func (s *localStorer) nextETag() string {
+	s.seq++
+	return fmt.Sprintf("\"0x%X%04X\"", time.Now().UnixNano(), s.seq&0xffff)
+}
+
+func localNotFound(identity string) *Error {
+	return newStorageCodeError(
+		azStorageBlob.StorageErrorCodeBlobNotFound, http.StatusNotFound,
+		fmt.Sprintf("blob %s not found", identity))
+}
+
+func localConditionNotMet(identity string) *Error {
+	return newStorageCodeError(
+		azStorageBlob.StorageErrorCodeConditionNotMet, http.StatusPreconditionFailed,
+		fmt.Sprintf("condition not met for blob %s", identity))
+}
+
+// checkLease applies the lease access condition for an operation that
+// modifies the blob.
+func (s *localStorer) checkLease(identity string, blob *localBlob, leaseID string) error {
+	active := blob != nil && blob.leaseActive(time.Now())
+	switch {
+	case active && leaseID == "":
+		return newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseIDMissing, http.StatusPreconditionFailed,
+			fmt.Sprintf("blob %s has an active lease and no lease id was specified", identity))
+	case active && leaseID != blob.LeaseID:
+		return newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseIDMismatchWithBlobOperation, http.StatusPreconditionFailed,
+			fmt.Sprintf("lease id does not match the active lease for blob %s", identity))
+	case !active && leaseID != "":
+		return newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseNotPresentWithBlobOperation, http.StatusPreconditionFailed,
+			fmt.Sprintf("blob %s has no active lease", identity))
+	}
+	return nil
+}
+
+// checkWriteConditions applies the etag, tags and since conditions for an
+// operation that modifies the blob. blob is nil if it does not exist.
+func (s *localStorer) checkWriteConditions(identity string, blob *localBlob, options *StorerOptions) error {
+	if options.etag == "" && options.etagCondition != EtagNotUsed {
+		return fmt.Errorf("etag value missing")
+	}
+	switch options.etagCondition {
+	case ETagMatch:
+		if blob == nil || (options.etag != "*" && options.etag != blob.ETag) {
+			return localConditionNotMet(identity)
+		}
+	case ETagNoneMatch:
+		if blob != nil && options.etag == "*" {
+			return newStorageCodeError(
+				azStorageBlob.StorageErrorCodeBlobAlreadyExists, http.StatusConflict,
+				fmt.Sprintf("blob %s already exists", identity))
+		}
+		if blob != nil && options.etag == blob.ETag {
+			return localConditionNotMet(identity)
+		}
+	case TagsWhere:
+		if blob == nil {
+			return localConditionNotMet(identity)
+		}
+		ok, err := s.matchTags(options.etag, blob)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return localConditionNotMet(identity)
+		}
+	default:
+	}
+	if blob == nil || options.since == nil {
+		return nil
+	}
+	switch options.sinceCondition {
+	case IfConditionModifiedSince:
+		if !blob.LastModified.After(*options.since) {
+			return localConditionNotMet(identity)
+		}
+	case IfConditionUnmodifiedSince:
+		if blob.LastModified.After(*options.since) {
+			return localConditionNotMet(identity)
+		}
+	default:
+	}
+	return nil
+}
+
+// checkReadConditions applies the access conditions for a read. A nil error
+// with notModified true corresponds to the 304 azure returns for If-None-Match
+// and If-Modified-Since.
+func (s *localStorer) checkReadConditions(
+	identity string, blob *localBlob, options *StorerOptions,
+) (notModified bool, err error) {
+	if options.leaseID != "" && (!blob.leaseActive(time.Now()) || blob.LeaseID != options.leaseID) {
+		return false, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeLeaseIDMismatchWithBlobOperation, http.StatusPreconditionFailed,
+			fmt.Sprintf("lease id does not match the active lease for blob %s", identity))
+	}
+	if options.etag == "" && options.etagCondition != EtagNotUsed {
+		return false, fmt.Errorf("etag value missing")
+	}
+	switch options.etagCondition {
+	case ETagMatch:
+		if options.etag != "*" && options.etag != blob.ETag {
+			return false, localConditionNotMet(identity)
+		}
+	case ETagNoneMatch:
+		if options.etag == "*" || options.etag == blob.ETag {
+			notModified = true
+		}
+	case TagsWhere:
+		ok, err := s.matchTags(options.etag, blob)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, localConditionNotMet(identity)
+		}
+	default:
+	}
+	if options.since == nil {
+		return notModified, nil
+	}
+	switch options.sinceCondition {
+	case IfConditionModifiedSince:
+		if !blob.LastModified.After(*options.since) {
+			notModified = true
+		}
+	case IfConditionUnmodifiedSince:
+		if blob.LastModified.After(*options.since) {
+			return false, localConditionNotMet(identity)
+		}
+	default:
+	}
+	return notModified, nil
+}
+
+func (s *localStorer) matchTags(where string, blob *localBlob) (bool, error) {
+	filter, err := parseTagsFilter(where)
+	if err != nil {
+		return false, NewStatusError(err.Error(), http.StatusBadRequest)
+	}
+	return filter.match(s.container, blob.Tags), nil
+}
+
+// canonicalMetadata returns the metadata keyed as azure returns it from a
+// read, ie. as canonical http header keys.
+func canonicalMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	m := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		m[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	return m
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Reader creates a reader.
+func (s *localStorer) Reader(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*ReaderResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return s.read(identity, options)
+}
+
+func (s *localStorer) read(identity string, options *StorerOptions) (*ReaderResponse, error) {
+	if err := checkRangeOptions(options); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := localVersionKey(identity, options)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := s.records.load(key, options.getMetadata != OnlyMetadata)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if blob == nil {
+		return nil, localNotFound(identity)
+	}
+
+	resp := &ReaderResponse{}
+	localResponseTier(blob, resp)
+	if len(options.tags) > 0 || options.getTags {
+		resp.Tags = copyStringMap(blob.Tags)
+		if resp.Tags == nil {
+			resp.Tags = map[string]string{}
+		}
+	}
+	if err = checkRequiredTags(resp.Tags, options.tags); err != nil {
+		return nil, err
+	}
+
+	if options.getMetadata == OnlyMetadata {
+		if parseErr := readerResponseMetadata(resp, canonicalMetadata(blob.Metadata)); parseErr != nil {
+			return nil, parseErr
+		}
+		if err = typedReaderMetadata(resp, options); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	if blob.AccessTier == AccessTierArchive {
+		return nil, localBlobArchived(identity)
+	}
+	notModified, err := s.checkReadConditions(identity, blob, options)
+	if err != nil {
+		return nil, err
+	}
+	etag := blob.ETag
+	lastModified := blob.LastModified
+	resp.ETag = &etag
+	resp.LastModified = &lastModified
+	resp.Metadata = canonicalMetadata(blob.Metadata)
+	if notModified {
+		resp.StatusCode = http.StatusNotModified
+		resp.Status = "304 " + string(azStorageBlob.StorageErrorCodeConditionNotMet)
+		resp.XMsErrorCode = string(azStorageBlob.StorageErrorCodeConditionNotMet)
+		resp.Reader = io.NopCloser(bytes.NewReader(nil))
+		return resp, nil
+	}
+
+	if err = checkScanState(identity, resp.Metadata, options); err != nil {
+		return nil, err
+	}
+	data, err := localRange(identity, blob.Data, options, resp)
+	if err != nil {
+		return nil, err
+	}
+	resp.ContentLength = int64(len(data))
+	if options.getMetadata == BothMetadataAndBlob {
+		_ = readerResponseMetadata(resp, resp.Metadata) // the parse error is benign
+		if err = typedReaderMetadata(resp, options); err != nil {
+			return nil, err
+		}
+	}
+	resp.Reader = io.NopCloser(bytes.NewReader(data))
+	if options.verifyHash {
+		verifyReaderResponse(identity, resp)
+	}
+	return resp, nil
+}
+
+// localRange returns the requested range of data and sets the status on the
+// response accordingly.
+func localRange(identity string, data []byte, options *StorerOptions, resp *ReaderResponse) ([]byte, error) {
+	if options.rangeOffset == 0 && options.rangeCount == CountToEnd {
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		return data, nil
+	}
+	size := int64(len(data))
+	if options.rangeOffset >= size {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeInvalidRange, http.StatusRequestedRangeNotSatisfiable,
+			fmt.Sprintf("range offset %d is beyond the end of blob %s", options.rangeOffset, identity))
+	}
+	end := size
+	if options.rangeCount != CountToEnd && options.rangeOffset+options.rangeCount < size {
+		end = options.rangeOffset + options.rangeCount
+	}
+	resp.StatusCode = http.StatusPartialContent
+	resp.Status = "206 Partial Content"
+	resp.ContentRange = fmt.Sprintf("bytes %d-%d/%d", options.rangeOffset, end-1, size)
+	return data[options.rangeOffset:end], nil
+}
+
+// DownloadToWriterAt downloads the blob to w in blocks. See
+// Storer.DownloadToWriterAt for the options and verification.
+func (s *localStorer) DownloadToWriterAt(
+	ctx context.Context,
+	identity string,
+	w io.WriterAt,
+	opts ...Option,
+) (*ReaderResponse, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkDownloadOptions(options); err != nil {
+		return nil, err
+	}
+	whole := *options
+	whole.getMetadata = BothMetadataAndBlob
+	whole.rangeOffset = 0
+	whole.rangeCount = CountToEnd
+	whole.verifyHash = false // downloadVerified checks the hash
+
+	// The content read here is a snapshot, which pins the blocks to the
+	// current etag just as for azure.
+	resp, err := s.read(identity, &whole)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Ok() {
+		return resp, nil
+	}
+	data, err := io.ReadAll(resp.Reader)
+	if err != nil {
+		return nil, err
+	}
+	resp.Reader = nil
+	fetch := func(ctx context.Context, offset int64, count int64) ([]byte, error) {
+		return data[offset : offset+count], nil
+	}
+	if err = downloadVerified(ctx, identity, fetch, w, resp, options); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DownloadToFile downloads the blob to the named file, which is created or
+// truncated. The file is removed if the download fails.
+func (s *localStorer) DownloadToFile(
+	ctx context.Context,
+	identity string,
+	path string,
+	opts ...Option,
+) (*ReaderResponse, error) {
+	return downloadToFile(path, func(w io.WriterAt) (*ReaderResponse, error) {
+		return s.DownloadToWriterAt(ctx, identity, w, opts...)
+	})
+}
+
+// Write writes to blob from io.Reader.
+func (s *localStorer) Write(
+	ctx context.Context,
+	identity string,
+	source io.Reader,
+	opts ...Option,
+) (*WriteResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return s.writeStream(ctx, identity, source, options, nil)
+}
+
+// WriteStream writes to blob from http request.
+func (s *localStorer) WriteStream(
+	ctx context.Context,
+	identity string,
+	source *http.Request,
+	opts ...Option,
+) (*WriteResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return streamReader(ctx, s, identity, source, options)
+}
+
+// WriteStreamFiles writes each file part of a http request to the blob named
+// by namer, see Storer.WriteStreamFiles.
+func (s *localStorer) WriteStreamFiles(
+	ctx context.Context,
+	source *http.Request,
+	namer StreamNamer,
+	opts ...Option,
+) (*WriteStreamResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return streamFiles(ctx, s, source, namer, true, options)
+}
+
+// Put creates or replaces a blob
+// metadata and tags are set in the same operation as the content update.
+func (s *localStorer) Put(
+	ctx context.Context,
+	identity string,
+	source io.ReadSeekCloser,
+	opts ...Option,
+) (*WriteResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if pos, err := source.Seek(0, io.SeekCurrent); pos != 0 || err != nil {
+		return nil, fmt.Errorf("bad body for %s: %v", identity, ErrMustSupportSeek0)
+	}
+	data, err := io.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	return s.put(identity, data, options)
+}
+
+// put creates or replaces the blob, replacing any existing metadata and tags
+// in their entirety. An active lease is preserved.
+func (s *localStorer) put(identity string, data []byte, options *StorerOptions) (*WriteResponse, error) {
+	if data == nil {
+		data = []byte{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.records.load(identity, false)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if err = s.checkLease(identity, existing, options.leaseID); err != nil {
+		return nil, err
+	}
+	if err = s.checkWriteConditions(identity, existing, options); err != nil {
+		return nil, err
+	}
+
+	blob := &localBlob{
+		Data:         data,
+		Size:         int64(len(data)),
+		ETag:         s.nextETag(),
+		LastModified: s.now(),
+		Metadata:     copyStringMap(options.metadata),
+		Tags:         copyStringMap(options.tags),
+		AccessTier:   options.accessTier,
+	}
+	if existing != nil {
+		blob.localLease = existing.localLease
+	}
+	if err = s.records.store(identity, blob); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return localWriteResponse(blob), nil
+}
+
+func localWriteResponse(blob *localBlob) *WriteResponse {
+	etag := blob.ETag
+	lastModified := blob.LastModified
+	return &WriteResponse{
+		ETag:         &etag,
+		LastModified: &lastModified,
+		StatusCode:   http.StatusCreated,
+		Status:       "201 Created",
+	}
+}
+
+// Append adds data to the end of the append blob. See Storer.Append for the
+// options.
+func (s *localStorer) Append(
+	ctx context.Context,
+	identity string,
+	data []byte,
+	opts ...Option,
+) (*WriteResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkAppendBlock(data); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(identity, true)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if blob == nil {
+		if !options.createIfAbsent {
+			return nil, localNotFound(identity)
+		}
+		blob = &localBlob{
+			Data:     []byte{},
+			Metadata: copyStringMap(options.metadata),
+			Tags:     copyStringMap(options.tags),
+			BlobType: azStorageBlob.BlobTypeAppendBlob,
+		}
+	}
+	// as for azure, the conditions apply to the append once the blob exists
+	if err = s.checkLease(identity, blob, options.leaseID); err != nil {
+		return nil, err
+	}
+	if err = s.checkWriteConditions(identity, blob, options); err != nil {
+		return nil, err
+	}
+	if blob.BlobType != azStorageBlob.BlobTypeAppendBlob {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeInvalidBlobType, http.StatusConflict,
+			fmt.Sprintf("blob %s is not an append blob", identity))
+	}
+	offset := int64(len(blob.Data))
+	if options.appendPosition != nil && *options.appendPosition != offset {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeAppendPositionConditionNotMet, http.StatusPreconditionFailed,
+			fmt.Sprintf("append position condition not met for blob %s", identity))
+	}
+	if options.appendMaxSize != nil && offset+int64(len(data)) > *options.appendMaxSize {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeMaxBlobSizeConditionNotMet, http.StatusPreconditionFailed,
+			fmt.Sprintf("max blob size condition not met for blob %s", identity))
+	}
+
+	// the loaded Data may be shared with the stored blob, so it is never
+	// appended to in place
+	appended := make([]byte, 0, len(blob.Data)+len(data))
+	appended = append(append(appended, blob.Data...), data...)
+	blob.Data = appended
+	blob.Size = int64(len(appended))
+	blob.Blocks++
+	blob.ETag = s.nextETag()
+	blob.LastModified = s.now()
+	if err = s.records.store(identity, blob); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	wr := localWriteResponse(blob)
+	wr.AppendOffset = &offset
+	blocks := blob.Blocks
+	wr.CommittedBlockCount = &blocks
+	return wr, nil
+}
+
+// CommittedLength returns the committed length of the append blob.
+func (s *localStorer) CommittedLength(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*CommittedLengthResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(identity, false)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if blob == nil {
+		return nil, localNotFound(identity)
+	}
+	if _, err = s.checkReadConditions(identity, blob, options); err != nil {
+		return nil, err
+	}
+	if blob.BlobType != azStorageBlob.BlobTypeAppendBlob {
+		return nil, newStorageCodeError(
+			azStorageBlob.StorageErrorCodeInvalidBlobType, http.StatusConflict,
+			fmt.Sprintf("blob %s is not an append blob", identity))
+	}
+	etag := blob.ETag
+	lastModified := blob.LastModified
+	return &CommittedLengthResponse{
+		Length:              blob.Size,
+		CommittedBlockCount: blob.Blocks,
+		ETag:                &etag,
+		LastModified:        &lastModified,
+	}, nil
+}
+
+// writeStream implements multipartWriter
+func (s *localStorer) writeStream(
+	ctx context.Context,
+	identity string,
+	reader io.Reader,
+	options *StorerOptions,
+	commitMetadata func() (map[string]string, error),
+) (*WriteResponse, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if options.progress != nil {
+		options.progress(int64(len(data)))
+	}
+	if commitMetadata != nil {
+		o := *options
+		if o.metadata, err = commitMetadata(); err != nil {
+			return nil, err
+		}
+		options = &o
+	}
+	wr, err := s.put(identity, data, options)
+	if err != nil {
+		wr = &WriteResponse{}
+		normaliseWriteResponseErr(err, wr)
+		return wr, err
+	}
+	return wr, nil
+}
+
+// List returns a page of the blobs in the container, in lexical order. As for
+// Storer, WithListDelim() lists the blobs as a hierarchy.
+func (s *localStorer) List(ctx context.Context, opts ...Option) (*ListerResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.blobNames()
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	r := &ListerResponse{
+		Prefix:     options.listPrefix,
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+	}
+	now := time.Now()
+	names, dirs := localHierarchy(names, options.listPrefix, options.listDelim)
+	page, next := localPage(names, options, func(name string) bool {
+		return strings.HasPrefix(name, options.listPrefix)
+	})
+	for _, name := range page {
+		if dirs[name] {
+			name := name
+			r.Prefixes = append(r.Prefixes, &azStorageBlob.BlobPrefix{Name: &name})
+			continue
+		}
+		blob, err := s.records.load(name, false)
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		if blob == nil {
+			continue
+		}
+		r.Items = append(r.Items, s.listItem(name, blob, options, now))
+	}
+	r.Marker = next
+	typedListMetadata(r, options)
+	return r, nil
+}
+
+func (s *localStorer) listItem(
+	name string, blob *localBlob, options *StorerOptions, now time.Time,
+) *azStorageBlob.BlobItemInternal {
+
+	deleted := false
+	snapshot := ""
+	etag := blob.ETag
+	lastModified := blob.LastModified
+	blobType := azStorageBlob.BlobTypeBlockBlob
+	if blob.BlobType != "" {
+		blobType = blob.BlobType
+	}
+	leaseState := blob.leaseState(now)
+	leaseStatus := azStorageBlob.LeaseStatusTypeUnlocked
+	if blob.leaseActive(now) {
+		leaseStatus = azStorageBlob.LeaseStatusTypeLocked
+	}
+
+	item := &azStorageBlob.BlobItemInternal{
+		Deleted:  &deleted,
+		Name:     &name,
+		Snapshot: &snapshot,
+		Properties: &azStorageBlob.BlobPropertiesInternal{
+			Etag:          &etag,
+			LastModified:  &lastModified,
+			ContentLength: &blob.Size,
+			BlobType:      &blobType,
+			LeaseState:    &leaseState,
+			LeaseStatus:   &leaseStatus,
+		},
+	}
+	if blob.BlobType == "" {
+		tier, inferred := localTier(blob)
+		item.Properties.AccessTier = &tier
+		item.Properties.AccessTierInferred = &inferred
+	}
+	if options.listIncludeMetadata {
+		item.Metadata = make(map[string]*string, len(blob.Metadata))
+		for k, v := range blob.Metadata {
+			v := v
+			item.Metadata[k] = &v
+		}
+	}
+	if options.listIncludeTags {
+		item.BlobTags = localBlobTags(blob.Tags, nil)
+	}
+	return item
+}
+
+// localBlobTags converts tags to the azure model. If keys is not nil only
+// those keys are included.
+func localBlobTags(tags map[string]string, keys []string) *azStorageBlob.BlobTags {
+	if keys == nil {
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	blobTags := &azStorageBlob.BlobTags{}
+	for _, k := range keys {
+		v, ok := tags[k]
+		if !ok {
+			continue
+		}
+		k := k
+		blobTags.BlobTagSet = append(blobTags.BlobTagSet, &azStorageBlob.BlobTag{Key: &k, Value: &v})
+	}
+	return blobTags
+}
+
+// localHierarchy collapses the sorted names that have a delim after prefix into
+// a single virtual directory entry, ending in delim, for each distinct path
+// segment. The entries remain in lexical order. The returned map identifies
+// the directory entries.
+func localHierarchy(names []string, prefix string, delim string) ([]string, map[string]bool) {
+	if delim == "" {
+		return names, nil
+	}
+	dirs := map[string]bool{}
+	var entries []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		i := strings.Index(name[len(prefix):], delim)
+		if i < 0 {
+			entries = append(entries, name)
+			continue
+		}
+		dir := name[:len(prefix)+i+len(delim)]
+		// names sharing a directory are contiguous
+		if len(entries) > 0 && entries[len(entries)-1] == dir {
+			continue
+		}
+		entries = append(entries, dir)
+		dirs[dir] = true
+	}
+	return entries, dirs
+}
+
+// localPage selects the page of names, satisfying include, that starts at the
+// list marker. The returned marker is nil if there are no more pages.
+func localPage(names []string, options *StorerOptions, include func(name string) bool) ([]string, ListMarker) {
+	maxResults := int(options.listMaxResults)
+	if maxResults <= 0 {
+		maxResults = localDefaultMaxResults
+	}
+	start := ""
+	if options.listMarker != nil {
+		start = *options.listMarker
+	}
+
+	var page []string
+	for _, name := range names {
+		if name < start || !include(name) {
+			continue
+		}
+		if len(page) == maxResults {
+			next := name
+			return page, &next
+		}
+		page = append(page, name)
+	}
+	return page, nil
+}
+
+// FilteredList returns a list of blobs filtered on their tag values.
+// See Storer.FilteredList for the tagsFilter syntax.
+func (s *localStorer) FilteredList(ctx context.Context, tagsFilter string, opts ...Option) (*FilterResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter, err := parseTagsFilter(tagsFilter)
+	if err != nil {
+		return nil, NewStatusError(err.Error(), http.StatusBadRequest)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.blobNames()
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	blobs := map[string]*localBlob{}
+	var loadErr error
+	page, next := localPage(names, options, func(name string) bool {
+		if loadErr != nil {
+			return false
+		}
+		blob, err := s.records.load(name, false)
+		if err != nil {
+			loadErr = err
+			return false
+		}
+		if blob == nil || !filter.match(s.container, blob.Tags) {
+			return false
+		}
+		blobs[name] = blob
+		return true
+	})
+	if loadErr != nil {
+		return nil, ErrorFromError(loadErr)
+	}
+
+	r := &FilterResponse{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Marker:     next,
+	}
+	keys := filter.keys()
+	for _, name := range page {
+		name := name
+		container := s.container
+		r.Items = append(r.Items, &azStorageBlob.FilterBlobItem{
+			ContainerName: &container,
+			Name:          &name,
+			Tags:          localBlobTags(blobs[name].Tags, keys),
+		})
+	}
+	return r, nil
+}
+
+// Count counts the number of blobs filtered by the given tags filter
+func (s *localStorer) Count(ctx context.Context, tagsFilter string, opts ...Option) (int64, error) {
+	return countFiltered(ctx, s, tagsFilter, opts...)
+}
+
+// AcquireLease gets a lease on a blob. As for Storer, the blob is created empty
+// if it does not exist.
+func (s *localStorer) AcquireLease(
+	ctx context.Context, objectname string, leaseTimeout int32,
+) (string, error) {
+
+	logger.Sugar.Debugf("AcquireLease: %v", objectname)
+	leaseID, err := s.acquireLease(objectname, leaseTimeout)
+	if ErrorFromError(err).StatusCode() == http.StatusNotFound {
+		_, err = s.Write(ctx, objectname, bytes.NewReader([]byte{}))
+		if err != nil {
+			logger.Sugar.Infof("failed to create blob %s: %v", objectname, err)
+			return "", err
+		}
+		leaseID, err = s.acquireLease(objectname, leaseTimeout)
+	}
+	if err != nil {
+		logger.Sugar.Infof("failed to acquire lease %s: %v", objectname, err)
+		return "", err
+	}
+	return leaseID, nil
+}
+
+func (s *localStorer) AcquireLeaseRenewable(
+	ctx context.Context, objectname string, leaseTimeout int32,
+) (string, LeaseRenewer, error) {
+	logger.Sugar.Debugf("AcquireLeaseRenewable: %v", objectname)
+
+	leaseID, err := s.acquireLease(objectname, leaseTimeout)
+	if err != nil {
+		logger.Sugar.Infof("failed to acquire lease %s: %v", objectname, err)
+		return "", nil, err
+	}
+
+	renewer := func(ctx context.Context) error {
+		return s.renewLease(objectname, leaseID)
+	}
+	return leaseID, renewer, nil
+}
+
+func (s *localStorer) acquireLease(objectname string, leaseTimeout int32) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(objectname, false)
+	if err != nil {
+		return "", ErrorFromError(err)
+	}
+	if blob == nil {
+		return "", localNotFound(objectname)
+	}
+	leaseID, err := blob.acquire("blob "+objectname, leaseTimeout, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if err = s.records.store(objectname, blob); err != nil {
+		return "", ErrorFromError(err)
+	}
+	return leaseID, nil
+}
+
+// renewLease renews the lease. As for azure, an expired lease can be renewed
+// provided no other lease has been acquired since.
+func (s *localStorer) renewLease(objectname string, leaseID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(objectname, false)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if blob == nil {
+		return localNotFound(objectname)
+	}
+	if err = blob.renew("blob "+objectname, leaseID, time.Now()); err != nil {
+		logger.Sugar.Infof("failed to renew lease %s", objectname)
+		return err
+	}
+	if err = s.records.store(objectname, blob); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// ReleaseLeaseDeferable this is intended to use with defer - doesn't return error so we don't need to check it
+func (s *localStorer) ReleaseLeaseDeferable(ctx context.Context, objectname string, leaseID string) {
+	logger.Sugar.Debugf("ReleaseLeaseDeferable: %v", objectname)
+	err := s.ReleaseLease(ctx, objectname, leaseID)
+	if err != nil {
+		logger.Sugar.Infof("did not release lease %s: %v", objectname, err)
+	}
+}
+
+// ReleaseLease release a lease on a blob
+func (s *localStorer) ReleaseLease(ctx context.Context, objectname string, leaseID string) error {
+	logger.Sugar.Debugf("ReleaseLease: %v", objectname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(objectname, false)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if blob == nil {
+		return localNotFound(objectname)
+	}
+	if err = blob.release("blob "+objectname, leaseID); err != nil {
+		return err
+	}
+	if err = s.records.store(objectname, blob); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
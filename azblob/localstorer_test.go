@@ -0,0 +1,411 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// localStores returns each of the non azure Store implementations, so that
+// the tests confirm they behave the same.
+func localStores(t *testing.T) map[string]Store {
+	dir, err := NewDirStorer(t.TempDir(), "devcontainer")
+	require.NoError(t, err)
+	return map[string]Store{
+		"mem": NewMemStorer("devcontainer"),
+		"dir": dir,
+	}
+}
+
+func readAll(t *testing.T, rr *ReaderResponse) []byte {
+	t.Helper()
+	defer rr.Reader.Close()
+	data, err := io.ReadAll(rr.Reader)
+	require.NoError(t, err)
+	return data
+}
+
+func TestLocalStorerPutRead(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Reader(ctx, "missing")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			wr, err := store.Put(
+				ctx, "a/b", NewBytesReaderCloser([]byte("ORIGINAL_VALUE")),
+				WithMetadata(map[string]string{SizeKey: "14", HashKey: "abc"}),
+				WithTags(map[string]string{"owner": "tenant1"}),
+			)
+			require.NoError(t, err)
+			require.NotNil(t, wr.ETag)
+
+			rr, err := store.Reader(ctx, "a/b",
+				WithGetMetadata(BothMetadataAndBlob), WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+			assert.True(t, rr.Ok())
+			assert.Equal(t, *wr.ETag, *rr.ETag)
+			assert.Equal(t, int64(14), rr.Size)
+			assert.Equal(t, "abc", rr.HashValue)
+			assert.Equal(t, []byte("ORIGINAL_VALUE"), readAll(t, rr))
+
+			_, err = store.Reader(ctx, "a/b", WithTags(map[string]string{"owner": "tenant2"}))
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			rr, err = store.Reader(ctx, "a/b", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Nil(t, rr.Reader)
+			assert.Equal(t, "abc", rr.HashValue)
+		})
+	}
+}
+
+func TestLocalStorerEtags(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			wr, err := store.Put(ctx, "blob", NewBytesReaderCloser([]byte("ORIGINAL_VALUE")))
+			require.NoError(t, err)
+
+			wr2, err := store.Put(
+				ctx, "blob", NewBytesReaderCloser([]byte("SECOND_VALUE")), WithEtagMatch(*wr.ETag))
+			require.NoError(t, err)
+			assert.NotEqual(t, *wr.ETag, *wr2.ETag)
+
+			_, err = store.Put(
+				ctx, "blob", NewBytesReaderCloser([]byte("THIRD_VALUE")), WithEtagMatch(*wr.ETag))
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+
+			_, err = store.Reader(ctx, "blob", WithEtagMatch(*wr.ETag))
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+
+			// none match is not an error on read, the response reports it
+			rr, err := store.Reader(ctx, "blob", WithEtagNoneMatch(*wr2.ETag))
+			require.NoError(t, err)
+			assert.True(t, rr.ConditionNotMet())
+
+			rr, err = store.Reader(ctx, "blob", WithEtagNoneMatch(*wr.ETag))
+			require.NoError(t, err)
+			assert.True(t, rr.Ok())
+			assert.Equal(t, []byte("SECOND_VALUE"), readAll(t, rr))
+
+			// create only if absent
+			_, err = store.Put(
+				ctx, "blob", NewBytesReaderCloser([]byte("THIRD_VALUE")), WithEtagNoneMatch("*"))
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+		})
+	}
+}
+
+func TestLocalStorerRange(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Put(ctx, "log", NewBytesReaderCloser([]byte("0123456789")))
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "log", WithRange(2, 3))
+			require.NoError(t, err)
+			assert.True(t, rr.Ok())
+			assert.Equal(t, http.StatusPartialContent, rr.StatusCode)
+			assert.Equal(t, "bytes 2-4/10", rr.ContentRange)
+			assert.Equal(t, int64(3), rr.ContentLength)
+			assert.Equal(t, []byte("234"), readAll(t, rr))
+
+			rr, err = store.Reader(ctx, "log", WithRange(7, CountToEnd))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("789"), readAll(t, rr))
+
+			_, err = store.Reader(ctx, "log", WithRange(10, CountToEnd))
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, ErrorFromError(err).StatusCode())
+
+			_, err = store.Reader(ctx, "log", WithRange(-1, 2))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+		})
+	}
+}
+
+func TestLocalStorerWriteConditions(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			wr, err := store.Write(ctx, "log", bytes.NewReader([]byte("ORIGINAL_VALUE")), WithEtagNoneMatch("*"))
+			require.NoError(t, err)
+
+			wr2, err := store.Write(ctx, "log", bytes.NewReader([]byte("SECOND_VALUE")), WithEtagMatch(*wr.ETag))
+			require.NoError(t, err)
+
+			wr3, err := store.Write(ctx, "log", bytes.NewReader([]byte("THIRD_VALUE")), WithEtagMatch(*wr.ETag))
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+			require.NotNil(t, wr3)
+			assert.True(t, wr3.ConditionNotMet())
+
+			past := wr2.LastModified.Add(-time.Hour)
+			_, err = store.Write(ctx, "log", bytes.NewReader([]byte("THIRD_VALUE")), WithUnmodifiedSince(&past))
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+
+			future := wr2.LastModified.Add(time.Hour)
+			_, err = store.Write(ctx, "log", bytes.NewReader([]byte("THIRD_VALUE")), WithUnmodifiedSince(&future))
+			require.NoError(t, err)
+		})
+	}
+}
+
+// multipartRequest returns a request with a single file part
+func multipartRequest(t *testing.T, filename string, content []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func TestLocalStorerWriteStream(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	content := []byte("Or Lobster Thermidor aux crevettes with a Mornay sauce")
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var progress int64
+			wr, err := store.WriteStream(
+				ctx, "evidence", multipartRequest(t, "lobster.txt", content),
+				WithSizeLimit(-1),
+				WithMetadata(map[string]string{"origin": "test"}),
+				WithTags(map[string]string{"owner": "tenant1"}),
+				WithProgress(func(n int64) { progress = n }),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(content)), wr.Size)
+			assert.Equal(t, int64(len(content)), progress)
+
+			rr, err := store.Reader(ctx, "evidence",
+				WithGetMetadata(BothMetadataAndBlob), WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+			assert.Equal(t, *wr.ETag, *rr.ETag)
+			assert.Equal(t, wr.HashValue, rr.HashValue)
+			assert.Equal(t, wr.Size, rr.Size)
+			assert.Equal(t, "text/plain; charset=utf-8", rr.MimeType)
+			assert.Equal(t, "test", rr.Metadata["Origin"])
+			assert.Equal(t, content, readAll(t, rr))
+		})
+	}
+}
+
+// multipartFilesRequest returns a request with the form fields followed by a
+// file part for each of the files
+func multipartFilesRequest(t *testing.T, fields map[string]string, files map[string][]byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for name, value := range fields {
+		require.NoError(t, w.WriteField(name, value))
+	}
+	for _, filename := range []string{"lobster.txt", "spam.txt"} {
+		content, ok := files[filename]
+		if !ok {
+			continue
+		}
+		part, err := w.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func TestLocalStorerWriteStreamFiles(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	files := map[string][]byte{
+		"lobster.txt": []byte("Or Lobster Thermidor aux crevettes with a Mornay sauce"),
+		"spam.txt":    []byte("Spam, spam, spam, egg and spam"),
+	}
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			namer := func(file StreamFile) (string, map[string]string, error) {
+				return "evidence/" + file.Fields.Get("event") + "/" + file.FileName,
+					map[string]string{"event": file.Fields.Get("event")}, nil
+			}
+			resp, err := store.WriteStreamFiles(ctx,
+				multipartFilesRequest(t, map[string]string{"event": "e1"}, files), namer,
+				WithSizeLimit(-1), WithMetadata(map[string]string{"origin": "test"}))
+			require.NoError(t, err)
+			assert.Equal(t, "e1", resp.Fields.Get("event"))
+			require.Len(t, resp.Files, 2)
+
+			for i, filename := range []string{"lobster.txt", "spam.txt"} {
+				fr := resp.Files[i]
+				assert.Equal(t, "evidence/e1/"+filename, fr.Identity)
+				assert.Equal(t, filename, fr.FileName)
+				assert.Equal(t, "file", fr.FormName)
+				assert.Equal(t, int64(len(files[filename])), fr.Size)
+				assert.Equal(t, "text/plain; charset=utf-8", fr.MimeType)
+
+				rr, err := store.Reader(ctx, fr.Identity, WithGetMetadata(BothMetadataAndBlob))
+				require.NoError(t, err)
+				assert.Equal(t, fr.HashValue, rr.HashValue)
+				assert.Equal(t, "test", rr.Metadata["Origin"])
+				assert.Equal(t, "e1", rr.Metadata["Event"])
+				assert.Equal(t, files[filename], readAll(t, rr))
+			}
+
+			// a naming error stops the request, keeping the files already written
+			namer = func(file StreamFile) (string, map[string]string, error) {
+				if file.Index > 0 {
+					return "", nil, NewStatusError("one file please", http.StatusBadRequest)
+				}
+				return "evidence/partial", nil, nil
+			}
+			resp, err = store.WriteStreamFiles(ctx, multipartFilesRequest(t, nil, files), namer, WithSizeLimit(-1))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+			require.Len(t, resp.Files, 1)
+			assert.Equal(t, "evidence/partial", resp.Files[0].Identity)
+
+			// WriteStream only accepts a single file
+			_, err = store.WriteStream(ctx, "evidence/single", multipartFilesRequest(t, nil, files), WithSizeLimit(-1))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+			// the fields are held in memory, so their size is limited, but
+			// WriteStream skips them
+			large := map[string]string{"notes": strings.Repeat("x", maxStreamFieldSize+1)}
+			lobster := map[string][]byte{"lobster.txt": files["lobster.txt"]}
+			_, err = store.WriteStreamFiles(ctx, multipartFilesRequest(t, large, lobster), namer, WithSizeLimit(-1))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+			wr, err := store.WriteStream(ctx, "evidence/single", multipartFilesRequest(t, large, lobster), WithSizeLimit(-1))
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(files["lobster.txt"])), wr.Size)
+		})
+	}
+}
+
+func TestLocalStorerLease(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// the blob is created if it does not exist
+			leaseID, err := store.AcquireLease(ctx, "leased", 15)
+			require.NoError(t, err)
+
+			_, err = store.AcquireLease(ctx, "leased", 15)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			_, err = store.Put(ctx, "leased", NewBytesReaderCloser([]byte("VALUE")))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			_, err = store.Put(ctx, "leased", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(leaseID))
+			require.NoError(t, err)
+
+			err = store.Delete(ctx, "leased")
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			require.NoError(t, store.ReleaseLease(ctx, "leased", leaseID))
+
+			leaseID, renew, err := store.AcquireLeaseRenewable(ctx, "leased", 15)
+			require.NoError(t, err)
+			require.NoError(t, renew(ctx))
+			require.NoError(t, store.ReleaseLease(ctx, "leased", leaseID))
+			require.NoError(t, store.Delete(ctx, "leased"))
+		})
+	}
+}
+
+func TestLocalStorerList(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for _, blob := range []struct {
+				name       string
+				firstindex string
+			}{
+				{"tenant/1/massifs/0", "0000000000000000"},
+				{"tenant/1/massifs/1", "0000000000000010"},
+				{"tenant/2/massifs/0", "0000000000000000"},
+				{"other", "0000000000000020"},
+			} {
+				_, err := store.Write(ctx, blob.name, bytes.NewReader([]byte(blob.name)),
+					WithTags(map[string]string{"firstindex": blob.firstindex}))
+				require.NoError(t, err)
+			}
+
+			r, err := store.List(ctx, WithListPrefix("tenant/"), WithListMaxResults(2), WithListTags())
+			require.NoError(t, err)
+			require.Len(t, r.Items, 2)
+			assert.Equal(t, "tenant/1/massifs/0", *r.Items[0].Name)
+			assert.Len(t, r.Items[0].BlobTags.BlobTagSet, 1)
+			require.NotNil(t, r.Marker)
+
+			r, err = store.List(ctx, WithListPrefix("tenant/"), WithListMarker(r.Marker))
+			require.NoError(t, err)
+			require.Len(t, r.Items, 1)
+			assert.Equal(t, "tenant/2/massifs/0", *r.Items[0].Name)
+			assert.Nil(t, r.Marker)
+
+			count, err := store.Count(ctx, `"firstindex">'0000000000000000'`, WithListMaxResults(1))
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), count)
+
+			fr, err := store.FilteredList(ctx, `@container='devcontainer' AND firstindex='0000000000000000'`)
+			require.NoError(t, err)
+			assert.Len(t, fr.Items, 2)
+		})
+	}
+}
+
+// Storer, MemStorer and DirStorer must all be usable as a Store
+func TestStoreImplementations(t *testing.T) {
+	var stores []Store
+	stores = append(stores, &Storer{}, &MemStorer{}, &DirStorer{})
+	assert.Len(t, stores, 3)
+}
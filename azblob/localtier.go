@@ -0,0 +1,127 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func localBlobArchived(identity string) *Error {
+	return newStorageCodeError(
+		azStorageBlob.StorageErrorCodeBlobArchived, http.StatusConflict,
+		fmt.Sprintf("blob %s is archived", identity))
+}
+
+// localTier returns the tier of a block blob, and whether it is the default
+func localTier(blob *localBlob) (AccessTier, bool) {
+	if blob.AccessTier == "" {
+		return AccessTierHot, true
+	}
+	return blob.AccessTier, false
+}
+
+// localResponseTier sets the tier of a block blob in the response
+func localResponseTier(blob *localBlob, resp *ReaderResponse) {
+	if blob.BlobType == "" {
+		resp.AccessTier, resp.AccessTierInferred = localTier(blob)
+	}
+}
+
+// SetTier sets the access tier of the blob. See Storer.SetTier for the
+// options. Rehydrating an archived blob completes immediately.
+func (s *localStorer) SetTier(ctx context.Context, identity string, tier AccessTier, opts ...Option) error {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Infof("SetTier %s %s", identity, tier)
+	if err := checkTier(tier); err != nil {
+		return err
+	}
+	if err := checkTierOptions(options); err != nil {
+		return err
+	}
+	return s.setTier(identity, tier, options)
+}
+
+func (s *localStorer) setTier(identity string, tier AccessTier, options *StorerOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := localVersionKey(identity, options)
+	if err != nil {
+		return err
+	}
+	blob, err := s.records.load(key, false)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if blob == nil {
+		return localNotFound(identity)
+	}
+	if blob.BlobType != "" {
+		return newStorageCodeError(
+			azStorageBlob.StorageErrorCodeInvalidBlobType, http.StatusConflict,
+			fmt.Sprintf("blob %s is not a block blob", identity))
+	}
+	// as for tags, the lease is only checked if one is given
+	if options.leaseID != "" {
+		if err = s.checkLease(identity, blob, options.leaseID); err != nil {
+			return err
+		}
+	}
+	if err = s.checkWriteConditions(identity, blob, options); err != nil {
+		return err
+	}
+	blob.AccessTier = tier
+	if err = s.records.store(key, blob); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// Rehydrate moves an archived blob to the hot or cool tier, immediately. See
+// Storer.Rehydrate.
+func (s *localStorer) Rehydrate(
+	ctx context.Context, identity string, tier AccessTier, priority RehydratePriority, opts ...Option,
+) error {
+	if err := checkRehydrate(tier); err != nil {
+		return err
+	}
+	return s.SetTier(ctx, identity, tier, append(opts[:len(opts):len(opts)], WithRehydratePriority(priority))...)
+}
+
+// SetTierMany sets the access tier of each of the blobs. See
+// Storer.SetTierMany for the results and options.
+func (s *localStorer) SetTierMany(
+	ctx context.Context, identities []string, tier AccessTier, opts ...Option,
+) ([]BatchResult, error) {
+	logger.Sugar.Infof("SetTierMany %d blobs %s", len(identities), tier)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkTier(tier); err != nil {
+		return nil, err
+	}
+	if err := checkTierOptions(options); err != nil {
+		return nil, err
+	}
+	results := newBatchResults(identities)
+	for i, identity := range identities {
+		switch {
+		case ctx.Err() != nil:
+			results[i].Err = ErrorFromError(ctx.Err())
+		case options.dryRun:
+			logger.Sugar.Infof("SetTierMany dry run: would set %s to %s", identity, tier)
+		default:
+			results[i].Err = batchItemError(s.setTier(identity, tier, options))
+		}
+	}
+	return results, ctx.Err()
+}
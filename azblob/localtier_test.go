@@ -0,0 +1,81 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorerTiers(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			metadata := map[string]string{SizeKey: "5"}
+
+			w, err := store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("VALUE")),
+				WithMetadata(metadata), WithAccessTier(AccessTierCool))
+			require.NoError(t, err)
+			_, err = store.Put(ctx, "default", NewBytesReaderCloser([]byte("VALUE")), WithMetadata(metadata))
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "evidence", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, AccessTierCool, rr.AccessTier)
+			assert.False(t, rr.AccessTierInferred)
+			rr, err = store.Reader(ctx, "default", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, AccessTierHot, rr.AccessTier)
+			assert.True(t, rr.AccessTierInferred)
+
+			// an archived blob can't be read until it is rehydrated
+			require.NoError(t, store.SetTier(ctx, "evidence", AccessTierArchive))
+			_, err = store.Reader(ctx, "evidence")
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+			assert.Equal(t, string(azStorageBlob.StorageErrorCodeBlobArchived), ErrorFromError(err).StorageErrorCode())
+			rr, err = store.Reader(ctx, "evidence", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, AccessTierArchive, rr.AccessTier)
+
+			lr, err := store.List(ctx)
+			require.NoError(t, err)
+			require.Len(t, lr.Items, 2)
+			assert.Equal(t, AccessTierHot, *lr.Items[0].Properties.AccessTier)
+			assert.Equal(t, AccessTierArchive, *lr.Items[1].Properties.AccessTier)
+
+			err = store.Rehydrate(ctx, "evidence", AccessTierArchive, RehydratePriorityHigh)
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+			require.NoError(t, store.Rehydrate(ctx, "evidence", AccessTierHot, RehydratePriorityHigh))
+			rr, err = store.Reader(ctx, "evidence")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+			assert.Equal(t, *w.ETag, *rr.ETag, "the etag is unchanged")
+
+			// only tag conditions are supported
+			err = store.SetTier(ctx, "evidence", AccessTierCool, WithEtagMatch(*w.ETag))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+			_, err = store.Append(ctx, "log", []byte("entry"), WithCreateIfAbsent())
+			require.NoError(t, err)
+			err = store.SetTier(ctx, "log", AccessTierCool)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			results, err := store.SetTierMany(ctx, []string{"evidence", "default", "missing"}, AccessTierCool)
+			require.NoError(t, err)
+			assert.NoError(t, results[0].Err)
+			assert.NoError(t, results[1].Err)
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(results[2].Err).StatusCode())
+			rr, err = store.Reader(ctx, "default", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, AccessTierCool, rr.AccessTier)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	// localSnapshotSep separates the blob name and snapshot in the record
+	// names for snapshots. It can't appear in a blob name.
+	localSnapshotSep = "\x00"
+	// localDeletedSep is appended to the record name of a soft deleted blob
+	// or snapshot. Like localSnapshotSep it is not used in blob names.
+	localDeletedSep = "\x01"
+	// the format azure uses for snapshot timestamps
+	localSnapshotFormat = "2006-01-02T15:04:05.0000000Z"
+)
+
+// The local stores behave as an azure storage account without versioning.
+// Snapshots are supported, WithVersionID() never finds a version. Soft delete
+// is always enabled, without a retention limit.
+
+func localSnapshotKey(identity string, snapshot string) string {
+	return identity + localSnapshotSep + snapshot
+}
+
+// localVersionKey returns the record name for the blob, or the snapshot of it,
+// selected by the options
+func localVersionKey(identity string, options *StorerOptions) (string, error) {
+	switch {
+	case options.snapshot != "" && options.versionID != "":
+		return "", NewStatusError("only one of snapshot and version can be specified", http.StatusBadRequest)
+	case options.versionID != "":
+		return "", newStorageCodeError(
+			azStorageBlob.StorageErrorCodeBlobNotFound, http.StatusNotFound,
+			fmt.Sprintf("version %s of blob %s not found", options.versionID, identity))
+	case options.snapshot != "":
+		return localSnapshotKey(identity, options.snapshot), nil
+	default:
+		return identity, nil
+	}
+}
+
+// blobNames returns the names of the blobs, excluding the snapshots and soft
+// deleted blobs
+func (s *localStorer) blobNames() ([]string, error) {
+	names, err := s.records.names()
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	blobs := names[:0]
+	for _, name := range names {
+		if !strings.Contains(name, localSnapshotSep) && !strings.Contains(name, localDeletedSep) {
+			blobs = append(blobs, name)
+		}
+	}
+	return blobs, nil
+}
+
+// snapshotNames returns the record names of the snapshots of the blob, oldest
+// first
+func (s *localStorer) snapshotNames(identity string) ([]string, error) {
+	return localSnapshotNames(s.records, identity)
+}
+
+func localSnapshotNames(records localRecords, identity string) ([]string, error) {
+	names, err := records.names()
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	var snapshots []string
+	for _, name := range names {
+		if strings.HasPrefix(name, identity+localSnapshotSep) && !strings.HasSuffix(name, localDeletedSep) {
+			snapshots = append(snapshots, name)
+		}
+	}
+	return snapshots, nil
+}
+
+// nextSnapshot returns a snapshot timestamp that is later than any issued
+// before, at the 100ns resolution azure uses
+func (s *localStorer) nextSnapshot() string {
+	now := time.Now().UTC().Truncate(100 * time.Nanosecond)
+	if !now.After(s.lastSnapshot) {
+		now = s.lastSnapshot.Add(100 * time.Nanosecond)
+	}
+	s.lastSnapshot = now
+	return now.Format(localSnapshotFormat)
+}
+
+// Snapshot takes a read only snapshot of the blob as it is now. See
+// Storer.Snapshot for the options.
+func (s *localStorer) Snapshot(ctx context.Context, identity string, opts ...Option) (*WriteResponse, error) {
+	logger.Sugar.Debugf("Snapshot %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.records.load(identity, true)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if blob == nil {
+		return nil, localNotFound(identity)
+	}
+	// unlike a write, the lease id is only checked if it is specified
+	if options.leaseID != "" {
+		if err = s.checkLease(identity, blob, options.leaseID); err != nil {
+			return nil, err
+		}
+	}
+	if err = s.checkWriteConditions(identity, blob, options); err != nil {
+		return nil, err
+	}
+
+	snapshot := s.nextSnapshot()
+	c := *blob
+	c.localLease = localLease{}
+	if options.metadata != nil {
+		c.Metadata = copyStringMap(options.metadata)
+	}
+	if err = s.records.store(localSnapshotKey(identity, snapshot), &c); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	wr := localWriteResponse(blob)
+	wr.Snapshot = snapshot
+	return wr, nil
+}
+
+// ListVersions returns the snapshots of the blob, oldest first, followed by
+// the blob itself.
+func (s *localStorer) ListVersions(ctx context.Context, identity string, opts ...Option) ([]*azStorageBlob.BlobItemInternal, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.snapshotNames(identity)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, identity)
+
+	now := time.Now()
+	var items []*azStorageBlob.BlobItemInternal
+	for _, name := range names {
+		blob, err := s.records.load(name, false)
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		if blob == nil {
+			continue
+		}
+		item := s.listItem(identity, blob, options, now)
+		snapshot := strings.TrimPrefix(name, identity+localSnapshotSep)
+		if name != identity {
+			item.Snapshot = &snapshot
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// PromoteVersion replaces the content and metadata of the blob with that of
+// the snapshot selected by WithSnapshot(). See Storer.PromoteVersion for the
+// options.
+func (s *localStorer) PromoteVersion(ctx context.Context, identity string, opts ...Option) (*WriteResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.snapshot == "" && options.versionID == "" {
+		return nil, NewStatusError("a snapshot or version to promote is required", http.StatusBadRequest)
+	}
+	key, err := localVersionKey(identity, options)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source, err := s.records.load(key, true)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if source == nil {
+		return nil, localNotFound(key)
+	}
+	existing, err := s.records.load(identity, false)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if err = s.checkLease(identity, existing, options.leaseID); err != nil {
+		return nil, err
+	}
+	if err = s.checkWriteConditions(identity, existing, options); err != nil {
+		return nil, err
+	}
+
+	blob := *source
+	blob.ETag = s.nextETag()
+	blob.LastModified = s.now()
+	blob.localLease = localLease{}
+	blob.Tags = copyStringMap(options.tags)
+	if existing != nil {
+		blob.localLease = existing.localLease
+		if options.tags == nil {
+			blob.Tags = existing.Tags
+		}
+	}
+	if err = s.records.store(identity, &blob); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return localWriteResponse(&blob), nil
+}
@@ -0,0 +1,79 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorerSnapshots(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("ORIGINAL")),
+				WithMetadata(map[string]string{"revision": "1"}),
+				WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+			_, err = store.Put(ctx, "evidence2", NewBytesReaderCloser([]byte("OTHER")))
+			require.NoError(t, err)
+
+			first, err := store.Snapshot(ctx, "evidence")
+			require.NoError(t, err)
+			require.NotEmpty(t, first.Snapshot)
+
+			_, err = store.Put(ctx, "evidence", NewBytesReaderCloser([]byte("OVERWRITTEN")),
+				WithMetadata(map[string]string{"revision": "2"}),
+				WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+			second, err := store.Snapshot(ctx, "evidence")
+			require.NoError(t, err)
+			assert.Greater(t, second.Snapshot, first.Snapshot)
+
+			// snapshots are not listed as blobs
+			r, err := store.List(ctx)
+			require.NoError(t, err)
+			assert.Len(t, r.Items, 2)
+
+			versions, err := store.ListVersions(ctx, "evidence")
+			require.NoError(t, err)
+			require.Len(t, versions, 3)
+			assert.Equal(t, first.Snapshot, *versions[0].Snapshot)
+			assert.Equal(t, second.Snapshot, *versions[1].Snapshot)
+			assert.Equal(t, "", *versions[2].Snapshot)
+			for _, v := range versions {
+				assert.Equal(t, "evidence", *v.Name)
+			}
+
+			rr, err := store.Reader(ctx, "evidence", WithSnapshot(first.Snapshot))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("ORIGINAL"), readAll(t, rr))
+
+			_, err = store.Reader(ctx, "evidence", WithVersionID("2024-01-01T00:00:00.0000000Z"))
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			// can't delete a blob with snapshots
+			err = store.Delete(ctx, "evidence")
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			_, err = store.PromoteVersion(ctx, "evidence")
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+			_, err = store.PromoteVersion(ctx, "evidence", WithSnapshot(first.Snapshot))
+			require.NoError(t, err)
+			rr, err = store.Reader(ctx, "evidence",
+				WithGetMetadata(BothMetadataAndBlob), WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("ORIGINAL"), readAll(t, rr))
+			assert.Equal(t, "1", rr.Metadata["Revision"])
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package azblob
+
+import (
+	"sort"
+)
+
+// MemStorer implements Store in memory. It is intended for unit tests that
+// exercise blob logic without needing azure or the azurite emulator.
+type MemStorer struct {
+	*localStorer
+}
+
+// NewMemStorer returns an empty in memory store for the named container. The
+// container name is only used to evaluate @container in tags filters.
+func NewMemStorer(container string) *MemStorer {
+	return &MemStorer{
+		localStorer: newLocalStorer(container, &memRecords{blobs: map[string]*localBlob{}}),
+	}
+}
+
+type memRecords struct {
+	blobs map[string]*localBlob
+}
+
+// load returns a copy so that callers can't change the stored blob without
+// calling store.
+func (m *memRecords) load(identity string, withData bool) (*localBlob, error) {
+	blob, ok := m.blobs[identity]
+	if !ok {
+		return nil, nil
+	}
+	c := *blob
+	if !withData {
+		c.Data = nil
+	}
+	c.Metadata = copyStringMap(blob.Metadata)
+	c.Tags = copyStringMap(blob.Tags)
+	return &c, nil
+}
+
+func (m *memRecords) store(identity string, blob *localBlob) error {
+	c := *blob
+	if existing, ok := m.blobs[identity]; ok && c.Data == nil {
+		c.Data = existing.Data
+	}
+	m.blobs[identity] = &c
+	return nil
+}
+
+func (m *memRecords) remove(identity string) error {
+	delete(m.blobs, identity)
+	return nil
+}
+
+func (m *memRecords) names() ([]string, error) {
+	names := make([]string, 0, len(m.blobs))
+	for name := range m.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
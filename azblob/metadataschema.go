@@ -0,0 +1,394 @@
+package azblob
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	// metadataTag is the struct tag naming the metadata key of a field, eg.
+	// `metadata:"size,required"` or `metadata:"retain_until,omitempty"`
+	metadataTag = "metadata"
+
+	// maxMetadataSize is the azure limit on the total size of the keys and
+	// values of the metadata of a blob
+	maxMetadataSize = 8 * 1024
+)
+
+var (
+	ErrInvalidMetadataSchema = errors.New("invalid metadata schema")
+	ErrInvalidMetadata       = errors.New("invalid metadata")
+
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// MetadataSchema encodes a struct to and from the metadata of a blob. Each
+// field with a metadata tag is stored under the key it names. The keys must
+// be valid C# identifiers, as azure requires, and are matched without regard
+// to case when decoding, as azure returns them in canonical header form from
+// a read but as written from a list. The values are escaped so that any
+// string can be stored, see escapeMetadataValue.
+//
+// The tag options are:
+//
+//	required - Encode and Decode fail if the value is empty or missing
+//	omitempty - the zero value is not written, empty strings never are
+//
+// Fields may be strings, bools, integers, floats or implement
+// encoding.TextMarshaler and encoding.TextUnmarshaler, eg. time.Time.
+type MetadataSchema struct {
+	typ    reflect.Type
+	fields []metadataField
+}
+
+type metadataField struct {
+	key       string
+	index     int
+	required  bool
+	omitempty bool
+}
+
+// ContentMetadata is the metadata WriteStream records about the content of a
+// blob. It is decoded into the ReaderResponse when the metadata is read. The
+// values are escaped, and EscapedKey is set to say so, blobs written without
+// it have the values as they are.
+type ContentMetadata struct {
+	HashValue         string `metadata:"hash"`
+	MimeType          string `metadata:"mime_type"`
+	Size              int64  `metadata:"size"`
+	TimestampAccepted string `metadata:"time_accepted"`
+}
+
+var contentMetadataSchema = mustMetadataSchema(ContentMetadata{})
+
+// contentMetadata returns the ContentMetadata keys of metadata, escaped if
+// the blob was written before the values were
+func contentMetadata(metadata map[string]string) map[string]string {
+	if metadataValue(metadata, EscapedKey) != "" {
+		return metadata
+	}
+	escaped := map[string]string{}
+	for _, key := range []string{HashKey, MimeKey, SizeKey, TimeKey} {
+		if value := metadataValue(metadata, key); value != "" {
+			escaped[key] = escapeMetadataValue(value)
+		}
+	}
+	return escaped
+}
+
+// NewMetadataSchema returns the schema for the struct type of prototype,
+// which may be a struct or a pointer to one.
+func NewMetadataSchema(prototype any) (*MetadataSchema, error) {
+	typ := reflect.TypeOf(prototype)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %v is not a struct", ErrInvalidMetadataSchema, typ)
+	}
+	s := &MetadataSchema{typ: typ}
+	keys := map[string]string{}
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag, ok := sf.Tag.Lookup(metadataTag)
+		if !ok || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if !sf.IsExported() {
+			return nil, fmt.Errorf("%w: field %s is not exported", ErrInvalidMetadataSchema, sf.Name)
+		}
+		if !validMetadataKey(name) {
+			return nil, fmt.Errorf("%w: key '%s' of field %s is not a valid identifier",
+				ErrInvalidMetadataSchema, name, sf.Name)
+		}
+		if other, ok := keys[strings.ToLower(name)]; ok {
+			return nil, fmt.Errorf("%w: fields %s and %s have the same key '%s'",
+				ErrInvalidMetadataSchema, other, sf.Name, name)
+		}
+		if !metadataFieldSupported(sf.Type) {
+			return nil, fmt.Errorf("%w: field %s has unsupported type %s", ErrInvalidMetadataSchema, sf.Name, sf.Type)
+		}
+		keys[strings.ToLower(name)] = sf.Name
+		field := metadataField{key: name, index: i}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "":
+			case "required":
+				field.required = true
+			case "omitempty":
+				field.omitempty = true
+			default:
+				return nil, fmt.Errorf("%w: unknown option '%s' for field %s", ErrInvalidMetadataSchema, opt, sf.Name)
+			}
+		}
+		s.fields = append(s.fields, field)
+	}
+	return s, nil
+}
+
+func mustMetadataSchema(prototype any) *MetadataSchema {
+	s, err := NewMetadataSchema(prototype)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Encode returns the metadata for v, which must be a struct, or a pointer to
+// one, of the schema type. Pass it to a write with WithMetadata().
+func (s *MetadataSchema) Encode(v any) (map[string]string, error) {
+	rv, err := s.structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(s.fields))
+	size := 0
+	for _, field := range s.fields {
+		fv := rv.Field(field.index)
+		if field.omitempty && fv.IsZero() {
+			if field.required {
+				return nil, invalidMetadataError("required key '%s' is empty", field.key)
+			}
+			continue
+		}
+		value, err := encodeMetadataField(fv)
+		if err != nil {
+			return nil, invalidMetadataError("key '%s': %v", field.key, err)
+		}
+		if value == "" {
+			if field.required {
+				return nil, invalidMetadataError("required key '%s' is empty", field.key)
+			}
+			continue
+		}
+		value = escapeMetadataValue(value)
+		size += len(field.key) + len(value)
+		metadata[field.key] = value
+	}
+	if size > maxMetadataSize {
+		return nil, invalidMetadataError("size %d exceeds the maximum of %d", size, maxMetadataSize)
+	}
+	return metadata, nil
+}
+
+// Decode returns a pointer to a new struct of the schema type with the
+// fields set from metadata. The error wraps ErrInvalidMetadata and has status
+// 400 if a required key is missing or a value can't be decoded.
+func (s *MetadataSchema) Decode(metadata map[string]string) (any, error) {
+	v := reflect.New(s.typ)
+	if err := s.decode(metadata, v.Elem()); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// DecodeInto is Decode for an existing struct, v must be a pointer to a
+// struct of the schema type. Fields whose keys are missing are left as they
+// are.
+func (s *MetadataSchema) DecodeInto(metadata map[string]string, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Type() != s.typ {
+		return invalidMetadataError("can't decode into %T, expected *%s", v, s.typ)
+	}
+	return s.decode(metadata, rv.Elem())
+}
+
+func (s *MetadataSchema) decode(metadata map[string]string, rv reflect.Value) error {
+	for _, field := range s.fields {
+		value := metadataValue(metadata, field.key)
+		if value == "" {
+			if field.required {
+				return invalidMetadataError("required key '%s' is missing", field.key)
+			}
+			continue
+		}
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return invalidMetadataError("key '%s': %v", field.key, err)
+		}
+		if err = decodeMetadataField(rv.Field(field.index), value); err != nil {
+			return invalidMetadataError("key '%s': %v", field.key, err)
+		}
+	}
+	return nil
+}
+
+// structValue returns the struct v, which may be a pointer to it
+func (s *MetadataSchema) structValue(v any) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv.Type() != s.typ {
+		return reflect.Value{}, invalidMetadataError("can't encode %T, expected %s", v, s.typ)
+	}
+	return rv, nil
+}
+
+func invalidMetadataError(format string, args ...any) *Error {
+	return &Error{
+		err:        fmt.Errorf("%w: %s", ErrInvalidMetadata, fmt.Sprintf(format, args...)),
+		statusCode: http.StatusBadRequest,
+	}
+}
+
+// validMetadataKey returns true if key is a valid C# identifier, which azure
+// requires of metadata names. Only ascii identifiers are accepted, as the
+// keys are sent as http headers.
+func validMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, c := range key {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeMetadataValue percent encodes the bytes that azure rejects or alters
+// in a metadata value: anything that is not printable ascii, and leading or
+// trailing spaces, which are trimmed from http headers. '%' is escaped so the
+// value can be unescaped with url.PathUnescape.
+func escapeMetadataValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '%', c < ' ', c >= 0x7f:
+		case c == ' ' && (i == 0 || i == len(value)-1):
+		default:
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func metadataFieldSupported(typ reflect.Type) bool {
+	if typ.Implements(textMarshalerType) && reflect.PointerTo(typ).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+func encodeMetadataField(fv reflect.Value) (string, error) {
+	if m, ok := fv.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits()), nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", fv.Type())
+	}
+}
+
+func decodeMetadataField(fv reflect.Value, value string) error {
+	if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// typedReaderMetadata decodes the metadata of the response with the schema
+// given by WithMetadataSchema()
+func typedReaderMetadata(resp *ReaderResponse, options *StorerOptions) error {
+	if options.metadataSchema == nil {
+		return nil
+	}
+	v, err := options.metadataSchema.Decode(resp.Metadata)
+	if err != nil {
+		return err
+	}
+	resp.TypedMetadata = v
+	return nil
+}
+
+// typedListMetadata decodes the metadata of each listed blob with the schema
+// given by WithMetadataSchema(), if the metadata was listed. An item whose
+// metadata can't be decoded gets an error instead.
+func typedListMetadata(r *ListerResponse, options *StorerOptions) {
+	if options.metadataSchema == nil || !options.listIncludeMetadata {
+		return
+	}
+	r.TypedMetadata = make([]any, len(r.Items))
+	r.TypedMetadataErrors = make([]error, len(r.Items))
+	for i, item := range r.Items {
+		metadata := make(map[string]string, len(item.Metadata))
+		for k, v := range item.Metadata {
+			if v != nil {
+				metadata[k] = *v
+			}
+		}
+		v, err := options.metadataSchema.Decode(metadata)
+		if err != nil {
+			logger.Sugar.Infof("cannot decode metadata of blob %s: %v", *item.Name, err)
+			r.TypedMetadataErrors[i] = &Error{err: fmt.Errorf("blob %s: %w", *item.Name, err), statusCode: http.StatusBadRequest}
+			continue
+		}
+		r.TypedMetadata[i] = v
+	}
+}
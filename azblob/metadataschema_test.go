@@ -0,0 +1,301 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+type testEvidence struct {
+	Owner       string    `metadata:"owner,required"`
+	Description string    `metadata:"description"`
+	Pages       int       `metadata:"pages,omitempty"`
+	Redacted    bool      `metadata:"redacted"`
+	Score       float64   `metadata:"score,omitempty"`
+	Collected   time.Time `metadata:"collected,omitempty"`
+	Untagged    string
+	Ignored     string `metadata:"-"`
+}
+
+func TestNewMetadataSchema(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	_, err := NewMetadataSchema(&testEvidence{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		prototype any
+	}{
+		{name: "not a struct", prototype: "owner"},
+		{name: "nil", prototype: nil},
+		{name: "invalid key", prototype: struct {
+			A string `metadata:"content-type"`
+		}{}},
+		{name: "key starts with a digit", prototype: struct {
+			A string `metadata:"1st"`
+		}{}},
+		{name: "non ascii key", prototype: struct {
+			A string `metadata:"größe"`
+		}{}},
+		{name: "duplicate key", prototype: struct {
+			A string `metadata:"owner"`
+			B string `metadata:"Owner"`
+		}{}},
+		{name: "unsupported type", prototype: struct {
+			A []string `metadata:"owners"`
+		}{}},
+		{name: "unknown option", prototype: struct {
+			A string `metadata:"owner,optional"`
+		}{}},
+		{name: "unexported", prototype: struct {
+			a string `metadata:"owner"` //nolint:unused
+		}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMetadataSchema(tt.prototype)
+			assert.True(t, errors.Is(err, ErrInvalidMetadataSchema), "%v", err)
+		})
+	}
+}
+
+func TestMetadataSchemaEncodeDecode(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	schema, err := NewMetadataSchema(testEvidence{})
+	require.NoError(t, err)
+
+	collected := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	evidence := testEvidence{
+		Owner:       "Zoë",
+		Description: " 100% complete\r\n",
+		Pages:       12,
+		Score:       0.5,
+		Collected:   collected,
+		Untagged:    "not stored",
+		Ignored:     "not stored",
+	}
+	metadata, err := schema.Encode(&evidence)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"owner":       "Zo%C3%AB",
+		"description": "%20100%25 complete%0D%0A",
+		"pages":       "12",
+		"redacted":    "false",
+		"score":       "0.5",
+		"collected":   "2024-03-01T12:30:00Z",
+	}, metadata)
+	for k, v := range metadata {
+		for _, c := range []byte(v) {
+			assert.True(t, c >= ' ' && c < 0x7f, "%s has an invalid character", k)
+		}
+	}
+
+	// decoding is case insensitive, as azure returns canonical header keys
+	canonical := canonicalMetadata(metadata)
+	v, err := schema.Decode(canonical)
+	require.NoError(t, err)
+	decoded, ok := v.(*testEvidence)
+	require.True(t, ok)
+	evidence.Untagged, evidence.Ignored = "", ""
+	assert.Equal(t, evidence, *decoded)
+
+	// the zero values of omitempty fields are not written
+	metadata, err = schema.Encode(testEvidence{Owner: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "alice", "redacted": "false"}, metadata)
+
+	decoded = &testEvidence{Pages: 3}
+	require.NoError(t, schema.DecodeInto(metadata, decoded))
+	assert.Equal(t, testEvidence{Owner: "alice", Pages: 3}, *decoded)
+
+	_, err = schema.Encode(testEvidence{})
+	assert.True(t, errors.Is(err, ErrInvalidMetadata))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	_, err = schema.Encode(ContentMetadata{})
+	assert.True(t, errors.Is(err, ErrInvalidMetadata))
+	_, err = schema.Encode(testEvidence{Owner: strings.Repeat("x", maxMetadataSize)})
+	assert.True(t, errors.Is(err, ErrInvalidMetadata))
+
+	for name, metadata := range map[string]map[string]string{
+		"missing required": {"pages": "1"},
+		"invalid int":      {"owner": "alice", "pages": "many"},
+		"invalid time":     {"owner": "alice", "collected": "yesterday"},
+		"invalid escape":   {"owner": "100%"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := schema.Decode(metadata)
+			assert.True(t, errors.Is(err, ErrInvalidMetadata), "%v", err)
+		})
+	}
+	err = schema.DecodeInto(metadata, testEvidence{})
+	assert.True(t, errors.Is(err, ErrInvalidMetadata))
+}
+
+func TestLocalStorerMetadataSchema(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	schema, err := NewMetadataSchema(testEvidence{})
+	require.NoError(t, err)
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			metadata, err := schema.Encode(testEvidence{Owner: "Zoë", Pages: 2})
+			require.NoError(t, err)
+			_, err = store.Put(ctx, "tenant/1/a", NewBytesReaderCloser([]byte("VALUE")), WithMetadata(metadata))
+			require.NoError(t, err)
+			_, err = store.WriteStream(ctx, "tenant/1/b", multipartRequest(t, "b.txt", []byte("VALUE")),
+				WithSizeLimit(-1), WithMetadata(metadata))
+			require.NoError(t, err)
+
+			// a blob without the content metadata can still be read
+			rr, err := store.Reader(ctx, "tenant/1/a", WithGetMetadata(OnlyMetadata), WithMetadataSchema(schema))
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), rr.Size)
+			assert.Equal(t, &testEvidence{Owner: "Zoë", Pages: 2}, rr.TypedMetadata)
+
+			rr, err = store.Reader(ctx, "tenant/1/b", WithGetMetadata(BothMetadataAndBlob), WithMetadataSchema(schema))
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), rr.Size)
+			assert.Equal(t, &testEvidence{Owner: "Zoë", Pages: 2}, rr.TypedMetadata)
+			assert.Equal(t, []byte("VALUE"), readAll(t, rr))
+
+			rr, err = store.Reader(ctx, "tenant/1/b", WithMetadataSchema(schema))
+			require.NoError(t, err)
+			assert.Nil(t, rr.TypedMetadata, "the metadata is only decoded on request")
+			readAll(t, rr)
+
+			lr, err := store.List(ctx, WithListPrefix("tenant/"), WithListMetadata(), WithMetadataSchema(schema))
+			require.NoError(t, err)
+			require.Len(t, lr.TypedMetadata, 2)
+			for _, v := range lr.TypedMetadata {
+				assert.Equal(t, &testEvidence{Owner: "Zoë", Pages: 2}, v)
+			}
+			lr, err = store.List(ctx, WithListPrefix("tenant/"), WithMetadataSchema(schema))
+			require.NoError(t, err)
+			assert.Nil(t, lr.TypedMetadata)
+
+			// a blob without the required owner
+			_, err = store.Put(ctx, "tenant/1/c", NewBytesReaderCloser([]byte("VALUE")),
+				WithMetadata(map[string]string{"pages": "1"}))
+			require.NoError(t, err)
+			_, err = store.Reader(ctx, "tenant/1/c", WithGetMetadata(OnlyMetadata), WithMetadataSchema(schema))
+			assert.True(t, errors.Is(err, ErrInvalidMetadata))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+			// but the listing carries on past it
+			lr, err = store.List(ctx, WithListPrefix("tenant/"), WithListMetadata(), WithMetadataSchema(schema))
+			require.NoError(t, err)
+			require.Len(t, lr.Items, 3)
+			require.Len(t, lr.TypedMetadata, 3)
+			require.Len(t, lr.TypedMetadataErrors, 3)
+			for i, item := range lr.Items {
+				if *item.Name == "tenant/1/c" {
+					assert.Nil(t, lr.TypedMetadata[i])
+					assert.True(t, errors.Is(lr.TypedMetadataErrors[i], ErrInvalidMetadata))
+					assert.Equal(t, http.StatusBadRequest, ErrorFromError(lr.TypedMetadataErrors[i]).StatusCode())
+					continue
+				}
+				assert.NoError(t, lr.TypedMetadataErrors[i])
+				assert.Equal(t, &testEvidence{Owner: "Zoë", Pages: 2}, lr.TypedMetadata[i])
+			}
+		})
+	}
+}
+
+func TestLocalStorerContentMetadataEscaped(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	mimeType := `text/plain; name="100% Zoë"`
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition":                        {`form-data; name="file"; filename="a.txt"`},
+		textproto.CanonicalMIMEHeaderKey(ContentKey): {mimeType},
+	})
+	require.NoError(t, err)
+	_, err = part.Write([]byte("VALUE"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			r := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(body.Bytes()))
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+
+			w, err := store.WriteStream(ctx, "a", r, WithSizeLimit(-1))
+			require.NoError(t, err)
+			assert.Equal(t, mimeType, w.MimeType)
+
+			rr, err := store.Reader(ctx, "a", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, mimeType, rr.MimeType)
+			assert.Equal(t, int64(5), rr.Size)
+			assert.Equal(t, `text/plain; name="100%25 Zo%C3%AB"`, metadataValue(rr.Metadata, MimeKey))
+			assert.Equal(t, "true", metadataValue(rr.Metadata, EscapedKey))
+		})
+	}
+}
+
+func TestLegacyContentMetadata(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	// the metadata as WriteStream wrote it before the values were escaped,
+	// with the canonical keys azure returns
+	legacy := map[string]string{
+		"Hash":          "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"Size":          "5",
+		"Mime_type":     `text/plain; name="100%"; q=a%20b`,
+		"Time_accepted": "2024-03-01T12:30:00Z",
+	}
+	resp := &ReaderResponse{}
+	require.NoError(t, readerResponseMetadata(resp, legacy))
+	assert.Equal(t, legacy["Hash"], resp.HashValue)
+	assert.Equal(t, int64(5), resp.Size)
+	assert.Equal(t, `text/plain; name="100%"; q=a%20b`, resp.MimeType, "legacy values are not unescaped")
+	assert.Equal(t, "2024-03-01T12:30:00Z", resp.TimestampAccepted)
+
+	// the same values written escaped
+	escaped := map[string]string{
+		"Hash":             legacy["Hash"],
+		"Size":             "5",
+		"Mime_type":        `text/plain; name="100%25"; q=a%2520b`,
+		"Time_accepted":    "2024-03-01T12:30:00Z",
+		"Metadata_escaped": "true",
+	}
+	resp = &ReaderResponse{}
+	require.NoError(t, readerResponseMetadata(resp, escaped))
+	assert.Equal(t, `text/plain; name="100%"; q=a%20b`, resp.MimeType)
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			_, err := store.Put(ctx, "legacy", NewBytesReaderCloser([]byte("hello")), WithMetadata(legacy))
+			require.NoError(t, err)
+			rr, err := store.Reader(ctx, "legacy", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), rr.Size)
+			assert.Equal(t, `text/plain; name="100%"; q=a%20b`, rr.MimeType)
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package azblob
 
-import "time"
+import (
+	"net"
+	"time"
+)
 
 type GetMetadata int
 
@@ -27,6 +30,18 @@ const (
 	IfConditionUnmodifiedSince
 )
 
+// DeleteSnapshots is the policy for the snapshots of a blob that is deleted
+type DeleteSnapshots int
+
+const (
+	// DeleteSnapshotsNone fails the delete with 409 if the blob has snapshots
+	DeleteSnapshotsNone DeleteSnapshots = iota
+	// DeleteSnapshotsInclude deletes the blob and all of its snapshots
+	DeleteSnapshotsInclude
+	// DeleteSnapshotsOnly deletes the snapshots and keeps the blob
+	DeleteSnapshotsOnly
+)
+
 func (g GetMetadata) String() string {
 	return [...]string{"No metadata handling", "Only metadata", "metadata and blob"}[g]
 }
@@ -43,6 +58,44 @@ type StorerOptions struct {
 	etagCondition  ETagCondition // ETagMatch || ETagNoneMatch
 	sinceCondition IfSinceCondition
 	since          *time.Time
+	// Options for Reader()
+	rangeOffset int64
+	rangeCount  int64
+	readRetries int
+	verifyHash  bool
+	scanStates  []ScanState
+	snapshot    string
+	versionID   string
+	// Options for Reader() and List()
+	metadataSchema *MetadataSchema
+	// Options for Write(), WriteStream() and Put()
+	accessTier         AccessTier
+	contentType        string
+	cacheControl       string
+	contentDisposition string
+	contentMD5         []byte
+	// Options for SetTier()
+	rehydratePriority RehydratePriority
+	// Options for Append()
+	appendPosition *int64
+	appendMaxSize  *int64
+	createIfAbsent bool
+	// Options for Copy() and Move()
+	copySourceContainer string
+	copySourceEtag      string
+	// Options for Delete()
+	deleteSnapshots DeleteSnapshots
+	// Options for EnsureContainer()
+	containerAccess ContainerAccess
+	// Options for SignedURL()
+	sasIPRange            string
+	sasContentDisposition string
+	// Options for DeleteMany() and SetTagsMany()
+	dryRun bool
+	// Options for transfers in blocks
+	blockSize   int64
+	concurrency int
+	progress    ProgressFunc
 	// Options for List()
 	listPrefix     string
 	listDelim      string
@@ -51,6 +104,7 @@ type StorerOptions struct {
 	// extra data model items to include in the respponse
 	listIncludeTags     bool
 	listIncludeMetadata bool
+	listIncludeVersions bool
 	// There are more, but these are all we need for now
 }
 type ListMarker *string
@@ -70,7 +124,8 @@ func WithListMaxResults(maxResults int32) Option {
 	}
 }
 
-// TODO: this is an sdk v1.2.1 feature
+// WithListDelim lists the blobs as a hierarchy of virtual directories
+// separated by delim, typically "/" - List() only. See also Walk and Tree.
 func WithListDelim(delim string) Option {
 	return func(a *StorerOptions) {
 		a.listDelim = delim
@@ -95,6 +150,13 @@ func WithListMetadata() Option {
 	}
 }
 
+// withListVersions includes the snapshots and versions of each blob
+func withListVersions() Option {
+	return func(a *StorerOptions) {
+		a.listIncludeVersions = true
+	}
+}
+
 func WithModifiedSince(since *time.Time) Option {
 	return func(a *StorerOptions) {
 		if since == nil {
@@ -187,8 +249,257 @@ func WithGetTags() Option {
 
 // WithSizeLimit specifies the size limit of the blob.
 // -1 for unlimited. 0+ for limited.
+// The content must be smaller than the limit - WriteStream() and
+// WriteStreamFiles() only. It is enforced as the content is uploaded, and the
+// error wraps ErrSizeLimitExceeded and has status 402.
 func WithSizeLimit(sizeLimit int64) Option {
 	return func(a *StorerOptions) {
 		a.sizeLimit = sizeLimit
 	}
 }
+
+// WithRange specifies the range of the blob to read - Reader() only. A count of
+// CountToEnd reads from offset to the end of the blob. The response for a
+// range has status 206 (Partial Content) and ContentLength is the length of
+// the range.
+func WithRange(offset int64, count int64) Option {
+	return func(a *StorerOptions) {
+		a.rangeOffset = offset
+		a.rangeCount = count
+	}
+}
+
+// WithResumable makes the ReaderResponse.Reader resumable - Reader() only. If
+// the body stream breaks mid transfer, up to maxRetries further ranged
+// downloads are issued, each starting from the last delivered byte. The
+// downloads are conditional on the etag of the original response, so the
+// content can't change part way through the read. The MemStorer and DirStorer
+// read the content into memory, so their readers never break and the option
+// has no effect.
+func WithResumable(maxRetries int) Option {
+	return func(a *StorerOptions) {
+		a.readRetries = maxRetries
+	}
+}
+
+// WithSnapshot selects a snapshot of the blob, as returned by Snapshot() -
+// Reader(), DownloadToWriterAt(), PromoteVersion(), Delete() and the source of
+// Copy()
+func WithSnapshot(snapshot string) Option {
+	return func(a *StorerOptions) {
+		a.snapshot = snapshot
+	}
+}
+
+// WithVersionID selects a version of the blob, as listed by ListVersions() -
+// Reader(), DownloadToWriterAt(), PromoteVersion(), Delete() and the source of
+// Copy()
+func WithVersionID(versionID string) Option {
+	return func(a *StorerOptions) {
+		a.versionID = versionID
+	}
+}
+
+// WithVerifyHash verifies the content read against the sha256 recorded in the
+// hash metadata by WriteStream - Reader() only. The ReaderResponse.Reader
+// returns an error wrapping ErrHashMismatch, instead of io.EOF, if the content
+// differs. Blobs without the hash metadata are read without verification. It
+// can't be combined with WithRange.
+func WithVerifyHash() Option {
+	return func(a *StorerOptions) {
+		a.verifyHash = true
+	}
+}
+
+// WithScanStates refuses to read the content of a blob unless its scan state
+// is one of states - Reader(), DownloadToWriterAt() and DownloadToFile().
+// The error wraps ErrScanStateNotAllowed and has status 403. Reading only the
+// metadata is always allowed.
+func WithScanStates(states ...ScanState) Option {
+	return func(a *StorerOptions) {
+		a.scanStates = append([]ScanState{}, states...)
+	}
+}
+
+// WithMetadataSchema decodes the metadata of the blob with schema - Reader()
+// with WithGetMetadata(), and List() with WithListMetadata(). The decoded
+// struct is ReaderResponse.TypedMetadata, or for List() the corresponding
+// entry of ListerResponse.TypedMetadata. If the metadata of a blob can't be
+// decoded the error wraps ErrInvalidMetadata and has status 400. For List()
+// the error is only for that item, in ListerResponse.TypedMetadataErrors, so
+// one blob with bad metadata doesn't stop the listing.
+func WithMetadataSchema(schema *MetadataSchema) Option {
+	return func(a *StorerOptions) {
+		a.metadataSchema = schema
+	}
+}
+
+// WithAppendPosition only appends if the committed length of the append blob
+// is position - Append() only. This makes concurrent appends safe, see
+// CommittedLength.
+func WithAppendPosition(position int64) Option {
+	return func(a *StorerOptions) {
+		a.appendPosition = &position
+	}
+}
+
+// WithAppendMaxSize only appends if the length of the append blob after the
+// append would be no more than maxSize - Append() only.
+func WithAppendMaxSize(maxSize int64) Option {
+	return func(a *StorerOptions) {
+		a.appendMaxSize = &maxSize
+	}
+}
+
+// WithCreateIfAbsent creates the blob, with the metadata and tags options, if
+// it does not exist - Append() only.
+func WithCreateIfAbsent() Option {
+	return func(a *StorerOptions) {
+		a.createIfAbsent = true
+	}
+}
+
+// WithCopySourceContainer specifies the container, in the same storage
+// account, of the source blob - Copy() and Move(). The default is the
+// container of the store.
+func WithCopySourceContainer(container string) Option {
+	return func(a *StorerOptions) {
+		a.copySourceContainer = container
+	}
+}
+
+// WithCopySourceEtagMatch only copies if the etag of the source blob matches -
+// Copy() and Move(). The other etag options apply to the destination.
+func WithCopySourceEtagMatch(etag string) Option {
+	return func(a *StorerOptions) {
+		a.copySourceEtag = etag
+	}
+}
+
+// WithDeleteSnapshots specifies what happens to the snapshots of the blob -
+// Delete() and DeleteMany(). The default is DeleteSnapshotsNone.
+func WithDeleteSnapshots(policy DeleteSnapshots) Option {
+	return func(a *StorerOptions) {
+		a.deleteSnapshots = policy
+	}
+}
+
+// WithContainerAccess specifies the public access level of a new container -
+// EnsureContainer() only
+func WithContainerAccess(access ContainerAccess) Option {
+	return func(a *StorerOptions) {
+		a.containerAccess = access
+	}
+}
+
+// WithSASIPRange only allows requests from the addresses start to end
+// inclusive, or just start if end is nil - SignedURL() only
+func WithSASIPRange(start net.IP, end net.IP) Option {
+	return func(a *StorerOptions) {
+		a.sasIPRange = sasIPRange(start, end)
+	}
+}
+
+// WithSASContentDisposition overrides the Content-Disposition header of the
+// response to downloads, eg to name the file - SignedURL() only
+func WithSASContentDisposition(disposition string) Option {
+	return func(a *StorerOptions) {
+		a.sasContentDisposition = disposition
+	}
+}
+
+// WithDryRun logs what would be done without changing anything -
+// DeleteMany() and SetTagsMany()
+func WithDryRun() Option {
+	return func(a *StorerOptions) {
+		a.dryRun = true
+	}
+}
+
+// WithBlockSize specifies the size of each block for transfers that are split
+// into blocks - DownloadToWriterAt() and DownloadToFile(), and the uploads of
+// Write(), WriteStream() and WriteStreamFiles(), which buffer up to
+// concurrency blocks in memory. The default is 2MiB for uploads. Uploads
+// reduce the concurrency so that the buffers are at most 512MiB in total.
+func WithBlockSize(blockSize int64) Option {
+	return func(a *StorerOptions) {
+		a.blockSize = blockSize
+	}
+}
+
+// WithConcurrency specifies the maximum number of requests in flight. The
+// default depends on the caller:
+//
+//	DownloadToWriterAt(), DownloadToFile() - blocks, default 5
+//	Write(), WriteStream(), WriteStreamFiles() - blocks staged, default 3
+//	DeleteMany(), SetTierMany() - batches, default 4, or 16 single requests without a shared key
+//	SetTagsMany() - single requests, default 16
+func WithConcurrency(concurrency int) Option {
+	return func(a *StorerOptions) {
+		a.concurrency = concurrency
+	}
+}
+
+// WithProgress specifies a function that is called with the total number of
+// bytes transferred so far - DownloadToWriterAt(), DownloadToFile(), Write(),
+// WriteStream() and WriteStreamFiles(). Uploads report the bytes staged, the
+// blob is not visible until they are committed.
+func WithProgress(progress ProgressFunc) Option {
+	return func(a *StorerOptions) {
+		a.progress = progress
+	}
+}
+
+// WithAccessTier sets the access tier of the blob written - Write(),
+// WriteStream(), WriteStreamFiles() and Put(). By default the blob has the
+// default tier of the storage account.
+func WithAccessTier(tier AccessTier) Option {
+	return func(a *StorerOptions) {
+		a.accessTier = tier
+	}
+}
+
+// WithRehydratePriority sets the priority for rehydrating an archived blob -
+// SetTier() and SetTierMany()
+func WithRehydratePriority(priority RehydratePriority) Option {
+	return func(a *StorerOptions) {
+		a.rehydratePriority = priority
+	}
+}
+
+// WithContentType sets the Content-Type of the blob, returned when it is
+// downloaded - Write(), WriteStream(), WriteStreamFiles() and Put(). The
+// default is application/octet-stream. WriteStream() does not use the mime
+// type of the content, which may be chosen by the uploader, as the blob may
+// be served directly from storage, eg. by SignedURL().
+func WithContentType(contentType string) Option {
+	return func(a *StorerOptions) {
+		a.contentType = contentType
+	}
+}
+
+// WithCacheControl sets the Cache-Control of the blob, returned when it is
+// downloaded - Write(), WriteStream(), WriteStreamFiles() and Put()
+func WithCacheControl(cacheControl string) Option {
+	return func(a *StorerOptions) {
+		a.cacheControl = cacheControl
+	}
+}
+
+// WithContentDisposition sets the Content-Disposition of the blob, returned
+// when it is downloaded - Write(), WriteStream(), WriteStreamFiles() and Put()
+func WithContentDisposition(disposition string) Option {
+	return func(a *StorerOptions) {
+		a.contentDisposition = disposition
+	}
+}
+
+// WithContentMD5 sets the Content-MD5 of the blob, returned when it is
+// downloaded - Write(), WriteStream(), WriteStreamFiles() and Put(). Put()
+// also has azure verify the content against it, for the block uploads it is
+// only recorded.
+func WithContentMD5(md5 []byte) Option {
+	return func(a *StorerOptions) {
+		a.contentMD5 = md5
+	}
+}
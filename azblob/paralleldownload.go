@@ -0,0 +1,332 @@
+package azblob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+var (
+	ErrHashMismatch = errors.New("content does not match the recorded hash")
+)
+
+const (
+	defaultDownloadBlockSize   = 4 * 1024 * 1024
+	defaultDownloadConcurrency = 5
+)
+
+// ProgressFunc is called with the total number of bytes transferred so far
+type ProgressFunc func(bytesTransferred int64)
+
+// rangeFetcher reads count bytes of the blob starting at offset
+type rangeFetcher func(ctx context.Context, offset int64, count int64) ([]byte, error)
+
+// parallelDownload fetches size bytes as blocks, with at most concurrency
+// blocks in flight, and writes them to w. The blocks are hashed and reported
+// for progress in order, so no more than concurrency blocks are ever held in
+// memory regardless of which requests are slow.
+type parallelDownload struct {
+	fetch       rangeFetcher
+	w           io.WriterAt
+	size        int64
+	blockSize   int64
+	concurrency int
+	progress    ProgressFunc
+
+	hasher hash.Hash
+}
+
+type downloadedBlock struct {
+	data []byte
+	err  error
+}
+
+func (d *parallelDownload) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d.hasher = sha256.New()
+	numBlocks := (d.size + d.blockSize - 1) / d.blockSize
+
+	// pending holds the in flight blocks in order. Together with the block the
+	// loop below is waiting on, this limits the blocks in flight to concurrency
+	pending := make(chan chan downloadedBlock, d.concurrency-1)
+	go func() {
+		defer close(pending)
+		for n := int64(0); n < numBlocks; n++ {
+			done := make(chan downloadedBlock, 1)
+			select {
+			case pending <- done:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				done <- d.block(ctx, n)
+			}()
+		}
+	}()
+
+	// on the first error cancel the blocks in flight and wait for them, so
+	// nothing writes to w after run returns
+	var transferred int64
+	var firstErr error
+	for done := range pending {
+		block := <-done
+		if firstErr != nil {
+			continue
+		}
+		if block.err != nil {
+			firstErr = block.err
+			cancel()
+			continue
+		}
+		// hash.Hash Write never returns an error
+		_, _ = d.hasher.Write(block.data)
+		transferred += int64(len(block.data))
+		if d.progress != nil {
+			d.progress(transferred)
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	// pending is also closed if the context is cancelled part way through
+	if transferred != d.size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("downloaded %d bytes of %d", transferred, d.size)
+	}
+	return nil
+}
+
+func (d *parallelDownload) block(ctx context.Context, n int64) downloadedBlock {
+	offset := n * d.blockSize
+	count := d.blockSize
+	if offset+count > d.size {
+		count = d.size - offset
+	}
+	data, err := d.fetch(ctx, offset, count)
+	if err != nil {
+		return downloadedBlock{err: err}
+	}
+	if int64(len(data)) != count {
+		return downloadedBlock{err: fmt.Errorf("block at %d: got %d bytes, expected %d", offset, len(data), count)}
+	}
+	// don't write once the download has failed
+	if err = ctx.Err(); err != nil {
+		return downloadedBlock{err: err}
+	}
+	if _, err = d.w.WriteAt(data, offset); err != nil {
+		return downloadedBlock{err: err}
+	}
+	return downloadedBlock{data: data}
+}
+
+// sum returns the hex sha256 of the downloaded content
+func (d *parallelDownload) sum() string {
+	var h [sha256.Size]byte
+	d.hasher.Sum(h[:0])
+	return hex.EncodeToString(h[:])
+}
+
+// DownloadToWriterAt downloads the blob to w, fetching ranges of the blob
+// concurrently. It is intended for moving large blobs, for anything else
+// Reader is simpler.
+//
+// Options:
+//
+//	WithBlockSize() - the size of each ranged request, default 4MiB
+//	WithConcurrency() - the maximum number of requests in flight, default 5
+//	WithProgress() - called after each block with the total bytes so far
+//	WithResumable() - retries for each block if its body stream breaks
+//	WithTags() - only download the blob if it has these tags, as for Reader
+//	WithGetTags() - include the tags of the blob in the response
+//	WithEtagMatch(), WithLeaseID() etc. - applied when the download starts
+//
+// The whole blob is always downloaded, WithRange() is rejected with status 400.
+//
+// All the ranged requests are pinned to the etag the blob had when the
+// download started. If the blob has the sha256 HashKey metadata written by
+// WriteStream, the content is verified against it and an error wrapping
+// ErrHashMismatch is returned if it differs. The returned response has the
+// processed metadata but no Reader.
+func (azp *Storer) DownloadToWriterAt(
+	ctx context.Context,
+	identity string,
+	w io.WriterAt,
+	opts ...Option,
+) (*ReaderResponse, error) {
+	logger.Sugar.Debugf("DownloadToWriterAt %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkDownloadOptions(options); err != nil {
+		return nil, err
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	if azp.containerClient == nil {
+		return nil, errors.New("no container client available for reader")
+	}
+	blobClient, err := azp.blobClient(identity, options)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	// as for Reader, the tags are checked before any content is read
+	var tags map[string]string
+	if len(options.tags) > 0 || options.getTags {
+		if tags, err = blobTags(ctx, blobClient); err != nil {
+			return nil, err
+		}
+		if err = checkRequiredTags(tags, options.tags); err != nil {
+			return nil, err
+		}
+	}
+
+	props, err := blobClient.GetProperties(ctx, &azStorageBlob.BlobGetPropertiesOptions{
+		BlobAccessConditions: &blobAccessConditions,
+	})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	resp := &ReaderResponse{
+		setReadResponseScannedStatus: azp.setReadResponseScannedStatus,
+		BlobClient:                   blobClient,
+		ETag:                         props.ETag,
+		LastModified:                 props.LastModified,
+		Metadata:                     props.Metadata,
+		Tags:                         tags,
+		StatusCode:                   props.RawResponse.StatusCode,
+		Status:                       props.RawResponse.Status,
+	}
+	if props.ContentLength != nil {
+		resp.ContentLength = *props.ContentLength
+	}
+	_ = readerResponseMetadata(resp, resp.Metadata) // the parse error is benign
+	propertiesTier(props, resp)
+	if err = checkScanState(identity, resp.Metadata, options); err != nil {
+		return nil, err
+	}
+
+	// pin each range to the version of the blob we got the properties for
+	pinned := azStorageBlob.BlobAccessConditions{
+		LeaseAccessConditions: blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{
+			IfMatch: props.ETag,
+		},
+	}
+	fetch := func(ctx context.Context, offset int64, count int64) ([]byte, error) {
+		get, err := blobClient.Download(ctx, &azStorageBlob.BlobDownloadOptions{
+			BlobAccessConditions: &pinned,
+			Offset:               &offset,
+			Count:                &count,
+		})
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		body := get.Body(&azStorageBlob.RetryReaderOptions{MaxRetryRequests: options.readRetries})
+		defer body.Close()
+		data := make([]byte, count)
+		if _, err = io.ReadFull(body, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	if err = downloadVerified(ctx, identity, fetch, w, resp, options); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkDownloadOptions validates the options for DownloadToWriterAt, which
+// downloads the whole blob
+func checkDownloadOptions(options *StorerOptions) error {
+	if options.rangeOffset != 0 || options.rangeCount != CountToEnd {
+		return NewStatusError("a range can't be downloaded in parallel, use Reader", http.StatusBadRequest)
+	}
+	return checkRangeOptions(options)
+}
+
+// downloadVerified runs a parallelDownload for the blob described by resp
+// and checks the result against the recorded hash, if there is one.
+func downloadVerified(
+	ctx context.Context,
+	identity string,
+	fetch rangeFetcher,
+	w io.WriterAt,
+	resp *ReaderResponse,
+	options *StorerOptions,
+) error {
+	d := &parallelDownload{
+		fetch:       fetch,
+		w:           w,
+		size:        resp.ContentLength,
+		blockSize:   options.blockSize,
+		concurrency: options.concurrency,
+		progress:    options.progress,
+	}
+	if d.blockSize <= 0 {
+		d.blockSize = defaultDownloadBlockSize
+	}
+	if d.concurrency <= 0 {
+		d.concurrency = defaultDownloadConcurrency
+	}
+	if err := d.run(ctx); err != nil {
+		return err
+	}
+	if resp.HashValue != "" && resp.HashValue != d.sum() {
+		return ErrorFromError(fmt.Errorf("%w: blob %s", ErrHashMismatch, identity))
+	}
+	return nil
+}
+
+// DownloadToFile downloads the blob to the named file, which is created or
+// truncated. See DownloadToWriterAt for the options and verification. The file
+// is removed if the download fails.
+func (azp *Storer) DownloadToFile(
+	ctx context.Context,
+	identity string,
+	path string,
+	opts ...Option,
+) (*ReaderResponse, error) {
+	return downloadToFile(path, func(w io.WriterAt) (*ReaderResponse, error) {
+		return azp.DownloadToWriterAt(ctx, identity, w, opts...)
+	})
+}
+
+func downloadToFile(
+	path string,
+	download func(w io.WriterAt) (*ReaderResponse, error),
+) (*ReaderResponse, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := download(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Sugar.Infof("download to %s failed: %v", path, err)
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return resp, nil
+}
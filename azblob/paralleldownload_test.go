@@ -0,0 +1,206 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// writerAtBuffer is a fixed size io.WriterAt
+type writerAtBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (w *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return copy(w.data[off:], p), nil
+}
+
+func TestParallelDownload(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	content := make([]byte, 1000)
+	_, _ = rand.Read(content)
+	sum := sha256.Sum256(content)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	fetch := func(ctx context.Context, offset int64, count int64) ([]byte, error) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		// complete out of order
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return content[offset : offset+count], nil
+	}
+
+	var progress []int64
+	w := &writerAtBuffer{data: make([]byte, len(content))}
+	d := &parallelDownload{
+		fetch:       fetch,
+		w:           w,
+		size:        int64(len(content)),
+		blockSize:   64,
+		concurrency: 4,
+		progress:    func(n int64) { progress = append(progress, n) },
+	}
+	require.NoError(t, d.run(context.Background()))
+	assert.Equal(t, content, w.data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), d.sum())
+	assert.LessOrEqual(t, maxInFlight, 4)
+	assert.Len(t, progress, 16)
+	assert.Equal(t, int64(len(content)), progress[len(progress)-1])
+
+	failed := errors.New("failed")
+	d.fetch = func(ctx context.Context, offset int64, count int64) ([]byte, error) {
+		if offset == 128 {
+			return nil, failed
+		}
+		return content[offset : offset+count], nil
+	}
+	assert.ErrorIs(t, d.run(context.Background()), failed)
+}
+
+// closingWriterAt fails the test if it is written to after it is closed
+type closingWriterAt struct {
+	t      *testing.T
+	closed atomic.Bool
+}
+
+func (w *closingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	assert.False(w.t, w.closed.Load(), "write at %d after the download returned", off)
+	return len(p), nil
+}
+
+func TestParallelDownloadFailureWaitsForBlocks(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	content := make([]byte, 1000)
+	failed := errors.New("failed")
+	var fetches atomic.Int32
+	fetch := func(ctx context.Context, offset int64, count int64) ([]byte, error) {
+		fetches.Add(1)
+		if offset == 0 {
+			return nil, failed
+		}
+		// the slow blocks ignore the cancellation, as a body read may
+		time.Sleep(20 * time.Millisecond)
+		return content[offset : offset+count], nil
+	}
+
+	w := &closingWriterAt{t: t}
+	d := &parallelDownload{
+		fetch:       fetch,
+		w:           w,
+		size:        int64(len(content)),
+		blockSize:   64,
+		concurrency: 4,
+	}
+	err := d.run(context.Background())
+	w.closed.Store(true)
+	assert.ErrorIs(t, err, failed)
+	assert.LessOrEqual(t, fetches.Load(), int32(4), "no blocks are started after the failure")
+
+	// any block still running would write in this time
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestLocalStorerDownloadToFile(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	store := NewMemStorer("devcontainer")
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	sum := sha256.Sum256(content)
+
+	_, err := store.Put(ctx, "good", NewBytesReaderCloser(content),
+		WithMetadata(map[string]string{SizeKey: "1000", HashKey: hex.EncodeToString(sum[:])}))
+	require.NoError(t, err)
+	_, err = store.Put(ctx, "bad", NewBytesReaderCloser(content),
+		WithMetadata(map[string]string{SizeKey: "1000", HashKey: "not the hash"}))
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "download")
+	rr, err := store.DownloadToFile(ctx, "good", path, WithBlockSize(100), WithConcurrency(3))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), rr.ContentLength)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	_, err = store.DownloadToFile(ctx, "bad", path, WithBlockSize(100))
+	assert.ErrorIs(t, err, ErrHashMismatch)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStorerDownloadToWriterAtOptions(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := newBlobServer()
+	var contentReads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Get("comp") == "" {
+			contentReads.Add(1)
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	fake.put("a", string(content), nil, map[string]string{"owner": "tenant1"})
+
+	// the tags are checked before any content is read
+	w := &writerAtBuffer{data: make([]byte, len(content))}
+	_, err := azp.DownloadToWriterAt(ctx, "a", w, WithTags(map[string]string{"owner": "tenant2"}))
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+	_, err = azp.DownloadToWriterAt(ctx, "a", w, WithRange(10, 20))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	assert.Equal(t, int32(0), contentReads.Load())
+
+	rr, err := azp.DownloadToWriterAt(ctx, "a", w, WithTags(map[string]string{"owner": "tenant1"}), WithBlockSize(300))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "tenant1"}, rr.Tags)
+	assert.Equal(t, content, w.data)
+	assert.Equal(t, int32(4), contentReads.Load())
+
+	// as for the local stores
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Put(ctx, "a", NewBytesReaderCloser(content), WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+			_, err = store.DownloadToWriterAt(ctx, "a", &writerAtBuffer{data: make([]byte, len(content))}, WithTags(map[string]string{"owner": "tenant2"}))
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			_, err = store.DownloadToWriterAt(ctx, "a", &writerAtBuffer{data: make([]byte, len(content))}, WithRange(10, 20))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+		})
+	}
+}
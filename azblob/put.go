@@ -19,10 +19,7 @@ func (azp *Storer) Put(
 ) (*WriteResponse, error) {
 	logger.Sugar.Debugf("Create or replace BlockBlob %s", identity)
 
-	options := &StorerOptions{}
-	for _, opt := range opts {
-		opt(options)
-	}
+	options := azp.uploadOptions(opts)
 
 	wr, err := azp.putBlob(
 		ctx, identity, source, options)
@@ -63,9 +60,12 @@ func (azp *Storer) putBlob(
 		ctx,
 		body,
 		&azStorageBlob.BlockBlobUploadOptions{
-			BlobAccessConditions: &blobAccessConditions,
-			Metadata:             options.metadata,
-			TagsMap:              options.tags,
+			BlobAccessConditions:    &blobAccessConditions,
+			HTTPHeaders:             blobHTTPHeaders(options),
+			Tier:                    accessTierOption(options),
+			TransactionalContentMD5: options.contentMD5,
+			Metadata:                options.metadata,
+			TagsMap:                 options.tags,
 		},
 	)
 	if err != nil {
@@ -114,6 +114,7 @@ func NewReaderDefaultAuth(url string, opts ...ReaderOption) (Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+	azp.tokenCredential = credentials
 
 	azp.serviceClient, err = azStorageBlob.NewServiceClient(
 		url,
@@ -3,7 +3,6 @@ package azblob
 import (
 	"errors"
 	"io"
-	"net/textproto"
 	"strconv"
 	"time"
 
@@ -18,13 +17,26 @@ type ReaderResponse struct {
 	HashValue         string
 	MimeType          string
 	ContentLength     int64
-	Size              int64 // MIME size
+	ContentRange      string // set for ranged reads, eg "bytes 0-499/1234"
+	Size              int64  // MIME size
 	Tags              map[string]string
 	TimestampAccepted string
 	ScannedStatus     string
 	ScannedBadReason  string
 	ScannedTimestamp  string
 
+	// TypedMetadata is a pointer to the struct decoded from the metadata by
+	// WithMetadataSchema(), nil otherwise
+	TypedMetadata any
+
+	// The access tier is only known when the properties of the blob are
+	// read, by WithGetMetadata(OnlyMetadata) and DownloadToWriterAt. The
+	// ArchiveStatus is set while an archived blob is being rehydrated, eg.
+	// "rehydrate-pending-to-hot".
+	AccessTier         AccessTier
+	AccessTierInferred bool // true if the tier is the account default
+	ArchiveStatus      azStorageBlob.ArchiveStatus
+
 	BlobClient *azStorageBlob.BlobClient
 
 	// The following are copied as appropriate from the azure sdk response.
@@ -43,12 +55,12 @@ func (r *ReaderResponse) ConditionNotMet() bool {
 	return r.XMsErrorCode == string(azStorageBlob.StorageErrorCodeConditionNotMet)
 }
 
-// Ok returns true if the http status was 200 or 201
+// Ok returns true if the http status was 200, 201 or, for a ranged read, 206
 // This method is provided for use in combination with specific headers like
 // If-Match and ETags conditions.  In thos circumstances we often get err=nil
 // but no content.
 func (r *ReaderResponse) Ok() bool {
-	return r.StatusCode == 200 || r.StatusCode == 201
+	return r.StatusCode == 200 || r.StatusCode == 201 || r.StatusCode == 206
 }
 
 const (
@@ -95,6 +107,10 @@ func downloadReaderResponse(r azStorageBlob.BlobDownloadResponse, rr *ReaderResp
 		rr.XMsErrorCode = value[0]
 	}
 
+	if r.ContentRange != nil {
+		rr.ContentRange = *r.ContentRange
+	}
+
 	s, ok := r.RawResponse.Header["Content-Length"]
 	if !ok {
 		return nil
@@ -109,21 +125,25 @@ func downloadReaderResponse(r azStorageBlob.BlobDownloadResponse, rr *ReaderResp
 	return err
 }
 
-// readerResponseMetadata processes and conditions values from the metadata we have specific support for.
+// readerResponseMetadata processes and conditions values from the metadata we
+// have specific support for, see ContentMetadata. Blobs that were not written
+// by WriteStream may not have the content metadata, in which case the fields
+// are left empty.
 func readerResponseMetadata(resp *ReaderResponse, metaData map[string]string) error {
-	size, parseErr := strconv.ParseInt(metaData[textproto.CanonicalMIMEHeaderKey(SizeKey)], 10, 64)
-	if parseErr != nil {
-		logger.Sugar.Infof("cannot get size value: %v", parseErr)
-		return parseErr
-	}
-	resp.Size = size
-	resp.HashValue = metaData[textproto.CanonicalMIMEHeaderKey(HashKey)]
-	resp.MimeType = metaData[textproto.CanonicalMIMEHeaderKey(MimeKey)]
-	resp.TimestampAccepted = metaData[textproto.CanonicalMIMEHeaderKey(TimeKey)]
+	// Note: it is fine if these are the same instances
+	resp.Metadata = metaData
+	// the scan status doesn't depend on the content metadata
 	if resp.setReadResponseScannedStatus != nil {
 		resp.setReadResponseScannedStatus(resp, metaData)
 	}
-	// Note: it is fine if these are the same instances
-	resp.Metadata = metaData
+	var content ContentMetadata
+	if err := contentMetadataSchema.DecodeInto(contentMetadata(metaData), &content); err != nil {
+		logger.Sugar.Infof("cannot get content metadata: %v", err)
+		return err
+	}
+	resp.Size = content.Size
+	resp.HashValue = content.HashValue
+	resp.MimeType = content.MimeType
+	resp.TimestampAccepted = content.TimestampAccepted
 	return nil
 }
@@ -0,0 +1,362 @@
+package azblob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// The permissions for SignedURL. Combine them for more than one, eg
+// SASRead+SASWrite.
+const (
+	SASRead   = "r"
+	SASAdd    = "a"
+	SASCreate = "c"
+	SASWrite  = "w"
+	SASDelete = "d"
+	SASTag    = "t"
+	// SASList is only meaningful for a container
+	SASList = "l"
+)
+
+const (
+	// sasVersion is the version of the signed fields for both kinds of SAS
+	sasVersion = "2020-10-02"
+	// sasClockSkew back dates the start of the SAS so that it is usable at
+	// once by clients whose clocks are a little behind
+	sasClockSkew = 5 * time.Minute
+	// userDelegationKeyMinLifetime is the shortest lifetime requested for a
+	// user delegation key, so a key serves many SAS with short expiries
+	userDelegationKeyMinLifetime = time.Hour
+	// userDelegationKeyMaxLifetime is the azure limit on the lifetime of a
+	// user delegation key, and so of a user delegation SAS
+	userDelegationKeyMaxLifetime = 7 * 24 * time.Hour
+	storageTokenScope            = "https://storage.azure.com/.default"
+)
+
+// sasValues are the signed fields of a service or user delegation SAS. See
+// https://learn.microsoft.com/en-us/rest/api/storageservices/create-service-sas
+type sasValues struct {
+	permissions        string
+	start              time.Time
+	expiry             time.Time
+	canonicalName      string
+	ipRange            string
+	protocol           string
+	resource           string
+	snapshotTime       string
+	contentDisposition string
+}
+
+// serviceStringToSign is the string to sign with the account key
+func (v *sasValues) serviceStringToSign() string {
+	return strings.Join([]string{
+		v.permissions,
+		v.start.Format(azStorageBlob.SASTimeFormat),
+		v.expiry.Format(azStorageBlob.SASTimeFormat),
+		v.canonicalName,
+		"", // signed identifier, stored access policies are not used
+		v.ipRange,
+		v.protocol,
+		sasVersion,
+		v.resource,
+		v.snapshotTime,
+		"", // rscc
+		v.contentDisposition,
+		"", // rsce
+		"", // rscl
+		"", // rsct
+	}, "\n")
+}
+
+// userDelegationStringToSign is the string to sign with the user delegation
+// key. See
+// https://learn.microsoft.com/en-us/rest/api/storageservices/create-user-delegation-sas
+func (v *sasValues) userDelegationStringToSign(key *azStorageBlob.UserDelegationKey) string {
+	return strings.Join([]string{
+		v.permissions,
+		v.start.Format(azStorageBlob.SASTimeFormat),
+		v.expiry.Format(azStorageBlob.SASTimeFormat),
+		v.canonicalName,
+		*key.SignedOid,
+		*key.SignedTid,
+		key.SignedStart.UTC().Format(azStorageBlob.SASTimeFormat),
+		key.SignedExpiry.UTC().Format(azStorageBlob.SASTimeFormat),
+		*key.SignedService,
+		*key.SignedVersion,
+		"", // saoid
+		"", // suoid
+		"", // scid
+		v.ipRange,
+		v.protocol,
+		sasVersion,
+		v.resource,
+		v.snapshotTime,
+		"", // rscc
+		v.contentDisposition,
+		"", // rsce
+		"", // rscl
+		"", // rsct
+	}, "\n")
+}
+
+// query returns the SAS query parameters, other than the signature and the
+// user delegation key fields
+func (v *sasValues) query() url.Values {
+	q := url.Values{}
+	q.Set("sv", sasVersion)
+	q.Set("sr", v.resource)
+	q.Set("st", v.start.Format(azStorageBlob.SASTimeFormat))
+	q.Set("se", v.expiry.Format(azStorageBlob.SASTimeFormat))
+	q.Set("sp", v.permissions)
+	if v.ipRange != "" {
+		q.Set("sip", v.ipRange)
+	}
+	if v.protocol != "" {
+		q.Set("spr", v.protocol)
+	}
+	if v.contentDisposition != "" {
+		q.Set("rscd", v.contentDisposition)
+	}
+	return q
+}
+
+// sasPermissions checks the permissions and puts them in the order azure
+// requires
+func sasPermissions(perms string, container bool) (string, error) {
+	var err error
+	if container {
+		p := azStorageBlob.ContainerSASPermissions{}
+		if err = p.Parse(perms); err == nil {
+			perms = p.String()
+		}
+	} else {
+		p := azStorageBlob.BlobSASPermissions{}
+		if err = p.Parse(perms); err == nil {
+			perms = p.String()
+		}
+	}
+	if err != nil || perms == "" {
+		return "", NewStatusError(fmt.Sprintf("invalid SAS permissions '%s'", perms), http.StatusBadRequest)
+	}
+	return perms, nil
+}
+
+// sasAccountName returns the storage account name, which is part of the
+// signed resource
+func (azp *Storer) sasAccountName() string {
+	if azp.credential != nil {
+		return azp.credential.AccountName()
+	}
+	if azp.AccountName != "" {
+		return azp.AccountName
+	}
+	return accountNameFromURL(azp.rootURL)
+}
+
+// SignedURL returns a url for the blob, or for the container if identity is
+// empty, that grants perms until expiry without any other credentials. The
+// url is signed with the account key if the storer has one, otherwise with a
+// user delegation key obtained with the storer's azure ad credential.
+//
+// perms is a combination of the SAS permission constants, eg SASRead.
+//
+// Options:
+//
+//	WithSnapshot() or WithVersionID() - the url is for just that snapshot or version
+//	WithSASIPRange() - only allow requests from the address range
+//	WithSASContentDisposition() - override the Content-Disposition of downloads
+func (azp *Storer) SignedURL(
+	ctx context.Context, identity string, perms string, expiry time.Duration, opts ...Option,
+) (string, error) {
+	logger.Sugar.Debugf("SignedURL %s perms %s expiry %v", identity, perms, expiry)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	switch {
+	case expiry <= 0:
+		return "", NewStatusError("the SAS expiry must be in the future", http.StatusBadRequest)
+	case options.snapshot != "" && options.versionID != "":
+		return "", NewStatusError("only one of snapshot and version can be specified", http.StatusBadRequest)
+	case identity == "" && (options.snapshot != "" || options.versionID != ""):
+		return "", NewStatusError("a container SAS can't be for a snapshot or version", http.StatusBadRequest)
+	}
+	permissions, err := sasPermissions(perms, identity == "")
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	v := sasValues{
+		permissions:        permissions,
+		start:              now.Add(-sasClockSkew),
+		expiry:             now.Add(expiry),
+		canonicalName:      "/blob/" + azp.sasAccountName() + "/" + azp.Container,
+		ipRange:            options.sasIPRange,
+		resource:           "c",
+		contentDisposition: options.sasContentDisposition,
+	}
+	if strings.HasPrefix(azp.rootURL, "https:") {
+		v.protocol = string(azStorageBlob.SASProtocolHTTPS)
+	}
+	// the query for the url, not including the SAS
+	target := url.Values{}
+	if identity != "" {
+		v.canonicalName += "/" + identity
+		v.resource = "b"
+	}
+	switch {
+	case options.snapshot != "":
+		v.resource = "bs"
+		v.snapshotTime = options.snapshot
+		target.Set("snapshot", options.snapshot)
+	case options.versionID != "":
+		v.resource = "bv"
+		v.snapshotTime = options.versionID
+		target.Set("versionid", options.versionID)
+	default:
+	}
+
+	q := v.query()
+	switch {
+	case azp.credential != nil:
+		signature, err := azp.credential.ComputeHMACSHA256(v.serviceStringToSign())
+		if err != nil {
+			return "", ErrorFromError(err)
+		}
+		q.Set("sig", signature)
+	case azp.tokenCredential != nil:
+		key, err := azp.userDelegationKey(ctx, v.expiry)
+		if err != nil {
+			return "", err
+		}
+		signature, err := signUserDelegation(key, v.userDelegationStringToSign(key))
+		if err != nil {
+			return "", err
+		}
+		q.Set("skoid", *key.SignedOid)
+		q.Set("sktid", *key.SignedTid)
+		q.Set("skt", key.SignedStart.UTC().Format(azStorageBlob.SASTimeFormat))
+		q.Set("ske", key.SignedExpiry.UTC().Format(azStorageBlob.SASTimeFormat))
+		q.Set("sks", *key.SignedService)
+		q.Set("skv", *key.SignedVersion)
+		q.Set("sig", signature)
+	default:
+		return "", NewStatusError("the storer has no credential to sign a SAS with", http.StatusForbidden)
+	}
+
+	signedURL := azp.containerURL
+	if identity != "" {
+		signedURL += "/" + escapeBlobPath(identity)
+	}
+	if len(target) > 0 {
+		return signedURL + "?" + target.Encode() + "&" + q.Encode(), nil
+	}
+	return signedURL + "?" + q.Encode(), nil
+}
+
+// signUserDelegation signs the string with the user delegation key
+func signUserDelegation(key *azStorageBlob.UserDelegationKey, stringToSign string) (string, error) {
+	value, err := base64.StdEncoding.DecodeString(*key.Value)
+	if err != nil {
+		return "", ErrorFromError(fmt.Errorf("invalid user delegation key: %w", err))
+	}
+	h := hmac.New(sha256.New, value)
+	h.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// userDelegationKey returns a user delegation key that is valid until at
+// least expiry, reusing the last key obtained if it is still good enough
+func (azp *Storer) userDelegationKey(ctx context.Context, expiry time.Time) (*azStorageBlob.UserDelegationKey, error) {
+	azp.delegationKeyMu.Lock()
+	defer azp.delegationKeyMu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if expiry.After(now.Add(userDelegationKeyMaxLifetime)) {
+		return nil, NewStatusError(
+			fmt.Sprintf("a user delegation SAS can't be valid for more than %v", userDelegationKeyMaxLifetime),
+			http.StatusBadRequest)
+	}
+	key := azp.delegationKey
+	if key != nil && !key.SignedExpiry.Before(expiry) && !key.SignedStart.After(now.Add(-sasClockSkew)) {
+		return key, nil
+	}
+
+	keyExpiry := now.Add(max(expiry.Sub(now), userDelegationKeyMinLifetime))
+	key, err := azp.getUserDelegationKey(ctx, now.Add(-sasClockSkew), keyExpiry)
+	if err != nil {
+		return nil, err
+	}
+	azp.delegationKey = key
+	return key, nil
+}
+
+// getUserDelegationKey requests a new user delegation key, authorized by the
+// storer's azure ad credential. See
+// https://learn.microsoft.com/en-us/rest/api/storageservices/get-user-delegation-key
+func (azp *Storer) getUserDelegationKey(
+	ctx context.Context, start time.Time, expiry time.Time,
+) (*azStorageBlob.UserDelegationKey, error) {
+	logger.Sugar.Infof("Get user delegation key for %s, expires %v", azp.rootURL, expiry)
+
+	startText := start.Format(azStorageBlob.SASTimeFormat)
+	expiryText := expiry.Format(azStorageBlob.SASTimeFormat)
+	body, err := xml.Marshal(azStorageBlob.KeyInfo{Start: &startText, Expiry: &expiryText})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	token, err := azp.tokenCredential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{storageTokenScope}})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	req, err := newRESTRequest(
+		ctx, http.MethodPost, strings.TrimSuffix(azp.rootURL, "/")+"/?restype=service&comp=userdelegationkey",
+		body, "application/xml")
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	req.Raw().Header.Set("Authorization", "Bearer "+token.Token)
+	req.Raw().Header.Set("x-ms-version", sasVersion)
+
+	resp, err := restPipeline.Do(req)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, restResponseError(resp, "get user delegation key")
+	}
+	key := &azStorageBlob.UserDelegationKey{}
+	if err = xml.NewDecoder(resp.Body).Decode(key); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if key.SignedOid == nil || key.SignedTid == nil || key.SignedStart == nil || key.SignedExpiry == nil ||
+		key.SignedService == nil || key.SignedVersion == nil || key.Value == nil {
+		return nil, NewStatusError("incomplete user delegation key", http.StatusInternalServerError)
+	}
+	return key, nil
+}
+
+// sasIPRange formats the range as azure expects. end may be nil for a single
+// address.
+func sasIPRange(start net.IP, end net.IP) string {
+	r := azStorageBlob.IPRange{Start: start, End: end}
+	return r.String()
+}
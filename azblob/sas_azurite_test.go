@@ -0,0 +1,76 @@
+//go:build azurite
+
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// TestSignedURLDownload checks a shared key SAS url can be used to download a
+// blob without any other credentials. Requires the azurite emulator to be
+// running
+func TestSignedURLDownload(t *testing.T) {
+
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	testName := uniqueTestName("SignedURLDownload", t)
+
+	storer, err := NewDev(NewDevConfigFromEnv(), "devcontainer")
+	if err != nil {
+		t.Fatalf("failed to connect to blob store emulator: %v", err)
+	}
+	// This will error if it exists and that is fine
+	_, _ = storer.GetServiceClient().CreateContainer(context.Background(), "devcontainer", nil)
+
+	blobName := fmt.Sprintf("tests/blobs/%s", testName)
+	value := []byte("SIGNED_VALUE")
+	if _, err = storer.Put(context.Background(), blobName, NewBytesReaderCloser(value)); err != nil {
+		t.Fatalf("failed put value: %v", err)
+	}
+
+	signedURL, err := storer.SignedURL(context.Background(), blobName, SASRead, time.Minute,
+		WithSASContentDisposition("attachment; filename=value.txt"))
+	if err != nil {
+		t.Fatalf("failed to sign url: %v", err)
+	}
+	resp, err := http.Get(signedURL)
+	if err != nil {
+		t.Fatalf("failed to get signed url: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for signed url, got %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read signed url: %v", err)
+	}
+	if string(data) != string(value) {
+		t.Fatalf("expected %s, got %s", value, data)
+	}
+	if resp.Header.Get("Content-Disposition") != "attachment; filename=value.txt" {
+		t.Fatalf("content disposition not overridden: %s", resp.Header.Get("Content-Disposition"))
+	}
+
+	// a write is not permitted by a read only url
+	req, err := http.NewRequest(http.MethodDelete, signedURL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to delete with signed url: %v", err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 deleting with a read only url, got %s", resp2.Status)
+	}
+}
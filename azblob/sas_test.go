@@ -0,0 +1,195 @@
+package azblob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func parseSignedURL(t *testing.T, signedURL string) (*url.URL, url.Values) {
+	t.Helper()
+	u, err := url.Parse(signedURL)
+	require.NoError(t, err)
+	return u, u.Query()
+}
+
+func parseSASTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	tm, err := time.Parse(azStorageBlob.SASTimeFormat, value)
+	require.NoError(t, err)
+	return tm
+}
+
+func TestSignedURLSharedKeyMatchesSDK(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	cred, err := azStorageBlob.NewSharedKeyCredential(azuriteWellKnownAccount, azuriteWellKnownKey)
+	require.NoError(t, err)
+	azp := &Storer{
+		Container:    "devcontainer",
+		credential:   cred,
+		rootURL:      "https://devstoreaccount1.blob.core.windows.net/",
+		containerURL: "https://devstoreaccount1.blob.core.windows.net/devcontainer",
+	}
+
+	signedURL, err := azp.SignedURL(context.Background(), "tenant/1/a blob", "wr", time.Hour,
+		WithSASIPRange(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.9")),
+		WithSASContentDisposition("attachment; filename=evidence.json"))
+	require.NoError(t, err)
+	u, q := parseSignedURL(t, signedURL)
+	assert.Equal(t, "/devcontainer/tenant/1/a%20blob", u.EscapedPath())
+	assert.Equal(t, "rw", q.Get("sp"))
+	assert.Equal(t, "b", q.Get("sr"))
+	assert.Equal(t, "https", q.Get("spr"))
+	assert.Equal(t, "10.0.0.1-10.0.0.9", q.Get("sip"))
+
+	start := parseSASTime(t, q.Get("st"))
+	expiry := parseSASTime(t, q.Get("se"))
+	assert.Equal(t, time.Hour+sasClockSkew, expiry.Sub(start))
+
+	sdk, err := azStorageBlob.BlobSASSignatureValues{
+		Version:            sasVersion,
+		Protocol:           azStorageBlob.SASProtocolHTTPS,
+		StartTime:          start,
+		ExpiryTime:         expiry,
+		Permissions:        "rw",
+		IPRange:            azStorageBlob.IPRange{Start: net.ParseIP("10.0.0.1"), End: net.ParseIP("10.0.0.9")},
+		ContainerName:      "devcontainer",
+		BlobName:           "tenant/1/a blob",
+		ContentDisposition: "attachment; filename=evidence.json",
+	}.NewSASQueryParameters(cred)
+	require.NoError(t, err)
+	assert.Equal(t, sdk.Signature(), q.Get("sig"))
+
+	// a container SAS
+	signedURL, err = azp.SignedURL(context.Background(), "", SASRead+SASList, time.Minute)
+	require.NoError(t, err)
+	u, q = parseSignedURL(t, signedURL)
+	assert.Equal(t, "/devcontainer", u.Path)
+	assert.Equal(t, "c", q.Get("sr"))
+	assert.Equal(t, "rl", q.Get("sp"))
+
+	// a snapshot SAS
+	snapshot := "2024-01-02T03:04:05.0000000Z"
+	signedURL, err = azp.SignedURL(context.Background(), "tenant/1/a blob", SASRead, time.Minute, WithSnapshot(snapshot))
+	require.NoError(t, err)
+	_, q = parseSignedURL(t, signedURL)
+	assert.Equal(t, "bs", q.Get("sr"))
+	assert.Equal(t, snapshot, q.Get("snapshot"))
+}
+
+func TestSignedURLErrors(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	azp := &Storer{Container: "devcontainer", rootURL: "https://example.blob.core.windows.net/"}
+	_, err := azp.SignedURL(ctx, "blob", SASRead, time.Hour)
+	assert.Equal(t, http.StatusForbidden, ErrorFromError(err).StatusCode())
+
+	cred, err := azStorageBlob.NewSharedKeyCredential(azuriteWellKnownAccount, azuriteWellKnownKey)
+	require.NoError(t, err)
+	azp.credential = cred
+	_, err = azp.SignedURL(ctx, "blob", "rq", time.Hour)
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	_, err = azp.SignedURL(ctx, "blob", SASRead, 0)
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	_, err = azp.SignedURL(ctx, "", SASRead, time.Hour, WithSnapshot("2024-01-02T03:04:05.0000000Z"))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
+
+type fakeTokenCredential struct{}
+
+func (fakeTokenCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if len(options.Scopes) != 1 || options.Scopes[0] != storageTokenScope {
+		return azcore.AccessToken{}, fmt.Errorf("unexpected scopes %v", options.Scopes)
+	}
+	return azcore.AccessToken{Token: "TOKEN", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestSignedURLUserDelegation(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	keyValue := base64.StdEncoding.EncodeToString([]byte("user delegation key"))
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "userdelegationkey", r.URL.Query().Get("comp"))
+		assert.Equal(t, "Bearer TOKEN", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><UserDelegationKey>`+
+			`<SignedOid>oid</SignedOid><SignedTid>tid</SignedTid>`+
+			`<SignedStart>%s</SignedStart><SignedExpiry>%s</SignedExpiry>`+
+			`<SignedService>b</SignedService><SignedVersion>%s</SignedVersion>`+
+			`<Value>%s</Value></UserDelegationKey>`,
+			time.Now().Add(-time.Hour).UTC().Format(azStorageBlob.SASTimeFormat),
+			time.Now().Add(2*time.Hour).UTC().Format(azStorageBlob.SASTimeFormat),
+			sasVersion, keyValue)
+	}))
+	defer srv.Close()
+
+	azp := &Storer{
+		Container:       "devcontainer",
+		rootURL:         srv.URL + "/devstoreaccount1/",
+		containerURL:    srv.URL + "/devstoreaccount1/devcontainer",
+		tokenCredential: fakeTokenCredential{},
+	}
+	ctx := context.Background()
+
+	signedURL, err := azp.SignedURL(ctx, "tenant/1/blob", SASRead, time.Hour)
+	require.NoError(t, err)
+	_, q := parseSignedURL(t, signedURL)
+	assert.Equal(t, "oid", q.Get("skoid"))
+	assert.Equal(t, "tid", q.Get("sktid"))
+
+	// re-create the string to sign from the url
+	stringToSign := strings.Join([]string{
+		q.Get("sp"), q.Get("st"), q.Get("se"), "/blob/devstoreaccount1/devcontainer/tenant/1/blob",
+		q.Get("skoid"), q.Get("sktid"), q.Get("skt"), q.Get("ske"), q.Get("sks"), q.Get("skv"),
+		"", "", "", "", "", q.Get("sv"), q.Get("sr"), "", "", "", "", "", "",
+	}, "\n")
+	h := hmac.New(sha256.New, []byte("user delegation key"))
+	h.Write([]byte(stringToSign))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(h.Sum(nil)), q.Get("sig"))
+
+	// the key is reused while it is valid for the expiry
+	_, err = azp.SignedURL(ctx, "tenant/1/other", SASRead, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+	_, err = azp.SignedURL(ctx, "tenant/1/other", SASRead, 3*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// a busy service is retried
+	busy, busyRequests := unavailableServer(srv.Config.Handler, 1)
+	defer busy.Close()
+	azp.rootURL = busy.URL + "/devstoreaccount1/"
+	_, err = azp.SignedURL(ctx, "tenant/1/other", SASRead, 4*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), busyRequests.Load())
+	assert.Equal(t, int32(3), requests.Load())
+
+	_, err = azp.SignedURL(ctx, "tenant/1/other", SASRead, 8*24*time.Hour)
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
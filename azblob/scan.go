@@ -0,0 +1,266 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// ScanState is the result of scanning the content of a blob for malware
+type ScanState int
+
+const (
+	// ScanPending is the state of a blob that has not been scanned, including
+	// blobs without any scan metadata
+	ScanPending ScanState = iota
+	// ScanClean is the state of a blob that was scanned and found to be clean
+	ScanClean
+	// ScanBad is the state of a blob that was scanned and found to be bad
+	ScanBad
+)
+
+const (
+	// metadata keys for the scan result
+	ScannedStatusKey    = "scanned_status"
+	ScannedBadReasonKey = "scanned_bad_reason"
+	ScannedTimestampKey = "scanned_timestamp"
+
+	// ScannedStatusTag is the tag recording the scan state, so that scanned
+	// blobs can be found with FilteredList, eg. "scanned_status"='bad'. It is
+	// only set by MarkScanned, so blobs that are not scanned yet don't have it.
+	ScannedStatusTag = "scanned_status"
+)
+
+var (
+	ErrScanStateNotAllowed = errors.New("scan state not allowed")
+)
+
+func (s ScanState) String() string {
+	switch s {
+	case ScanClean:
+		return "clean"
+	case ScanBad:
+		return "bad"
+	default:
+		return "pending"
+	}
+}
+
+// ParseScanState returns the state for its String value. Anything else is
+// ScanPending, so a blob is never treated as scanned by mistake.
+func ParseScanState(value string) ScanState {
+	switch value {
+	case "clean":
+		return ScanClean
+	case "bad":
+		return ScanBad
+	default:
+		return ScanPending
+	}
+}
+
+// ScanResult is the scan result recorded on a blob by MarkScanned
+type ScanResult struct {
+	State     ScanState
+	BadReason string    // only recorded for ScanBad
+	Timestamp time.Time // MarkScanned uses the current time if zero
+}
+
+// Scanner is the interface for recording the scan result of a blob
+type Scanner interface {
+	MarkScanned(ctx context.Context, identity string, result ScanResult, opts ...Option) (*WriteResponse, error)
+}
+
+// ScanResultFromMetadata returns the scan result recorded in the blob
+// metadata. The keys may be in any case, as returned by azure.
+func ScanResultFromMetadata(metadata map[string]string) ScanResult {
+	result := ScanResult{
+		State:     ParseScanState(metadataValue(metadata, ScannedStatusKey)),
+		BadReason: metadataValue(metadata, ScannedBadReasonKey),
+	}
+	if timestamp := metadataValue(metadata, ScannedTimestampKey); timestamp != "" {
+		// an unparsable timestamp is left as zero
+		result.Timestamp, _ = time.Parse(time.RFC3339, timestamp)
+	}
+	return result
+}
+
+// ScanResult returns the scan result recorded in the metadata of the response
+func (r *ReaderResponse) ScanResult() ScanResult {
+	return ScanResultFromMetadata(r.Metadata)
+}
+
+// ReadResponseScanResult is a ReadResponseScannedStatus that sets the Scanned
+// fields of the response from the metadata written by MarkScanned, see
+// WithSetScannedStatus. Use ScanResult to get the result without it.
+func ReadResponseScanResult(resp *ReaderResponse, metaData map[string]string) {
+	resp.ScannedStatus = ParseScanState(metadataValue(metaData, ScannedStatusKey)).String()
+	resp.ScannedBadReason = metadataValue(metaData, ScannedBadReasonKey)
+	resp.ScannedTimestamp = metadataValue(metaData, ScannedTimestampKey)
+}
+
+func metadataValue(metadata map[string]string, key string) string {
+	if value, ok := metadata[textproto.CanonicalMIMEHeaderKey(key)]; ok {
+		return value
+	}
+	for k, value := range metadata {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
+	return ""
+}
+
+// setMetadataValue sets key, replacing any value for it in another case. An
+// empty value removes the key.
+func setMetadataValue(metadata map[string]string, key string, value string) {
+	for k := range metadata {
+		if strings.EqualFold(k, key) {
+			delete(metadata, k)
+		}
+	}
+	if value != "" {
+		metadata[key] = value
+	}
+}
+
+// scanResultMetadata returns a copy of metadata and tags with the result
+// recorded in them
+func scanResultMetadata(
+	metadata map[string]string, tags map[string]string, result ScanResult,
+) (map[string]string, map[string]string) {
+	metadata = copyStringMap(metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	tags = copyStringMap(tags)
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	timestamp := result.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	badReason := ""
+	if result.State == ScanBad {
+		badReason = result.BadReason
+	}
+	setMetadataValue(metadata, ScannedStatusKey, result.State.String())
+	setMetadataValue(metadata, ScannedBadReasonKey, badReason)
+	setMetadataValue(metadata, ScannedTimestampKey, timestamp.UTC().Format(time.RFC3339))
+	tags[ScannedStatusTag] = result.State.String()
+	return metadata, tags
+}
+
+// tagsMatchFilter returns a tags filter that matches blobs with all of tags
+func tagsMatchFilter(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	terms := make([]string, 0, len(keys))
+	for _, k := range keys {
+		terms = append(terms, fmt.Sprintf("\"%s\"='%s'", k, tags[k]))
+	}
+	return strings.Join(terms, " AND ")
+}
+
+// checkScanState returns an error if WithScanStates was given and the scan
+// state recorded in metadata is not one of the allowed states
+func checkScanState(identity string, metadata map[string]string, options *StorerOptions) error {
+	if options.scanStates == nil {
+		return nil
+	}
+	state := ParseScanState(metadataValue(metadata, ScannedStatusKey))
+	if slices.Contains(options.scanStates, state) {
+		return nil
+	}
+	logger.Sugar.Infof("blob %s scan state %s not allowed", identity, state)
+	return &Error{
+		err:        fmt.Errorf("%w: blob %s is %s", ErrScanStateNotAllowed, identity, state),
+		statusCode: http.StatusForbidden,
+	}
+}
+
+func checkScanOptions(options *StorerOptions) error {
+	if options.snapshot != "" || options.versionID != "" {
+		return NewStatusError("a snapshot or version can't be marked as scanned", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// MarkScanned records the scan result in the metadata and tags of the blob.
+// Both are replaced in a single operation, by copying the blob onto itself,
+// so readers never see the metadata and tags disagree. The other metadata and
+// tags are kept. As for Copy, the blob gets a new etag.
+//
+// The blob is only updated if it is unchanged since its metadata was read,
+// so a concurrent write is never marked with the result of scanning the
+// previous content. If the blob changes the error has status 412. Pass the
+// etag of the content that was scanned with WithEtagMatch() to be sure the
+// result applies to it.
+//
+// Setting the tags doesn't change the etag, so the tags read are also a
+// condition of the copy, and a concurrent change or removal of a tag gives
+// status 412 rather than being overwritten. A tag the blob didn't have when
+// its tags were read can't be part of the condition, so a tag added
+// concurrently is lost.
+//
+// Options:
+//
+//	WithEtagMatch() - only mark the blob if it has the etag that was scanned
+//	WithLeaseID() - required if the blob is leased
+func (azp *Storer) MarkScanned(ctx context.Context, identity string, result ScanResult, opts ...Option) (*WriteResponse, error) {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Infof("MarkScanned %s %s", identity, result.State)
+	if err := checkScanOptions(options); err != nil {
+		return nil, err
+	}
+	blobClient, err := azp.blobClient(identity, options)
+	if err != nil {
+		return nil, err
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	props, err := blobClient.GetProperties(ctx, &azStorageBlob.BlobGetPropertiesOptions{
+		BlobAccessConditions: &blobAccessConditions,
+	})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if props.ETag == nil {
+		return nil, fmt.Errorf("no etag for blob %s", identity)
+	}
+	tags, err := blobTags(ctx, blobClient)
+	if err != nil {
+		return nil, err
+	}
+	metadata, scannedTags := scanResultMetadata(props.Metadata, tags, result)
+
+	// the blob is the source of the copy as well as the destination, so the
+	// etag is checked as a source condition, leaving the destination
+	// condition for the tags
+	copyOpts := []Option{WithMetadata(metadata), WithTags(scannedTags), WithCopySourceEtagMatch(*props.ETag)}
+	if len(tags) > 0 {
+		copyOpts = append(copyOpts, WithWhereTags(tagsMatchFilter(tags)))
+	}
+	if options.leaseID != "" {
+		copyOpts = append(copyOpts, WithLeaseID(options.leaseID))
+	}
+	return azp.Copy(ctx, identity, identity, copyOpts...)
+}
@@ -0,0 +1,159 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestScanState(t *testing.T) {
+	for _, state := range []ScanState{ScanPending, ScanClean, ScanBad} {
+		assert.Equal(t, state, ParseScanState(state.String()))
+	}
+	assert.Equal(t, ScanPending, ParseScanState(""))
+	assert.Equal(t, ScanPending, ParseScanState("CLEAN"), "states are case sensitive")
+}
+
+func TestScanResultMetadata(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	scanned := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// azure returns the keys in canonical form, they must be replaced rather
+	// than duplicated
+	metadata, tags := scanResultMetadata(
+		map[string]string{"Scanned_status": "bad", "Scanned_bad_reason": "virus", "Origin": "test"},
+		map[string]string{"owner": "tenant1"},
+		ScanResult{State: ScanClean, BadReason: "ignored", Timestamp: scanned})
+	assert.Equal(t, map[string]string{
+		ScannedStatusKey:    "clean",
+		ScannedTimestampKey: "2024-01-02T03:04:05Z",
+		"Origin":            "test",
+	}, metadata)
+	assert.Equal(t, map[string]string{"owner": "tenant1", ScannedStatusTag: "clean"}, tags)
+
+	result := ScanResultFromMetadata(metadata)
+	assert.Equal(t, ScanResult{State: ScanClean, Timestamp: scanned}, result)
+
+	err := checkScanState("blob", metadata, &StorerOptions{scanStates: []ScanState{ScanBad}})
+	assert.True(t, errors.Is(err, ErrScanStateNotAllowed))
+	assert.Equal(t, http.StatusForbidden, ErrorFromError(err).StatusCode())
+	assert.NoError(t, checkScanState("blob", metadata, &StorerOptions{}))
+	assert.NoError(t, checkScanState("blob", nil, &StorerOptions{scanStates: []ScanState{ScanPending}}))
+}
+
+func TestReadResponseScanResult(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	metadata := map[string]string{
+		"Scanned_status":     "bad",
+		"Scanned_bad_reason": "virus",
+		"Scanned_timestamp":  "2024-01-02T03:04:05Z",
+	}
+
+	// the scanned fields are only set when the status is opted into
+	resp := &ReaderResponse{}
+	require.NoError(t, readerResponseMetadata(resp, metadata))
+	assert.Empty(t, resp.ScannedStatus)
+	assert.Empty(t, resp.ScannedBadReason)
+	assert.Empty(t, resp.ScannedTimestamp)
+	assert.Equal(t, ScanBad, resp.ScanResult().State)
+
+	resp = &ReaderResponse{setReadResponseScannedStatus: ReadResponseScanResult}
+	require.NoError(t, readerResponseMetadata(resp, metadata))
+	assert.Equal(t, "bad", resp.ScannedStatus)
+	assert.Equal(t, "virus", resp.ScannedBadReason)
+	assert.Equal(t, "2024-01-02T03:04:05Z", resp.ScannedTimestamp)
+
+	resp = &ReaderResponse{setReadResponseScannedStatus: ReadResponseScanResult}
+	require.NoError(t, readerResponseMetadata(resp, map[string]string{}))
+	assert.Equal(t, "pending", resp.ScannedStatus)
+}
+
+func TestTagsMatchFilter(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	tags := map[string]string{"owner": "tenant1", ScannedStatusTag: "clean", "kind": "evidence"}
+	where := tagsMatchFilter(tags)
+	assert.Equal(t, `"kind"='evidence' AND "owner"='tenant1' AND "scanned_status"='clean'`, where)
+	filter, err := parseTagsFilter(where)
+	require.NoError(t, err)
+	assert.True(t, filter.match("", tags))
+	tags["kind"] = "report"
+	assert.False(t, filter.match("", tags))
+}
+
+func TestStorerMarkScanned(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fake := newBlobServer()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+	ctx := context.Background()
+
+	etag := fake.put("a", "VALUE",
+		map[string]string{"owner": "alice", ScannedStatusKey: "pending"}, map[string]string{"kind": "evidence"})
+
+	_, err := azp.MarkScanned(ctx, "a", ScanResult{State: ScanClean}, WithEtagMatch("\"0xFF\""))
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+	timestamp := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	w, err := azp.MarkScanned(ctx, "a", ScanResult{State: ScanBad, BadReason: "eicar", Timestamp: timestamp},
+		WithEtagMatch(etag))
+	require.NoError(t, err)
+	assert.NotEqual(t, etag, *w.ETag)
+
+	a := fake.blob("a")
+	require.NotNil(t, a)
+	assert.Equal(t, "VALUE", string(a.data))
+	assert.Equal(t, map[string]string{
+		"owner":             "alice",
+		ScannedStatusKey:    "bad",
+		ScannedBadReasonKey: "eicar",
+		ScannedTimestampKey: "2024-03-01T12:00:00Z",
+	}, a.metadata)
+	assert.Equal(t, map[string]string{"kind": "evidence", ScannedStatusTag: "bad"}, a.tags)
+
+	// the tags are set concurrently, after MarkScanned read them
+	var changeTags func(tags map[string]string)
+	racing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.ServeHTTP(w, r)
+		if r.Method == http.MethodGet && r.URL.Query().Get("comp") == "tags" && changeTags != nil {
+			fake.update("a", func(blob *fakeBlob) { changeTags(blob.tags) })
+		}
+	}))
+	defer racing.Close()
+	racingAzp := newContainerTestStorer(t, racing.URL)
+
+	changeTags = func(tags map[string]string) { tags["kind"] = "report" }
+	_, err = racingAzp.MarkScanned(ctx, "a", ScanResult{State: ScanClean})
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	changeTags = func(tags map[string]string) { delete(tags, ScannedStatusTag) }
+	_, err = racingAzp.MarkScanned(ctx, "a", ScanResult{State: ScanClean})
+	assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+	a = fake.blob("a")
+	assert.Equal(t, map[string]string{"kind": "report"}, a.tags)
+	assert.Equal(t, "bad", a.metadata[ScannedStatusKey])
+
+	changeTags = nil
+	_, err = racingAzp.MarkScanned(ctx, "a", ScanResult{State: ScanClean})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"kind": "report", ScannedStatusTag: "clean"}, fake.blob("a").tags)
+
+	_, err = azp.MarkScanned(ctx, "a", ScanResult{State: ScanClean}, WithSnapshot("2024-01-01T00:00:00.0000000Z"))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	_, err = azp.MarkScanned(ctx, "missing", ScanResult{State: ScanClean})
+	assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+}
@@ -0,0 +1,46 @@
+package azblob
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrSizeLimitExceeded = errors.New("filesize exceeds maximum")
+)
+
+// sizeLimitReader fails with ErrSizeLimitExceeded once the content read
+// reaches the limit, so a size limit can be enforced while the content is
+// streamed. As for the limit checked by WriteStream, the content must be
+// smaller than the limit.
+type sizeLimitReader struct {
+	reader io.Reader
+	limit  int64
+	count  int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.count >= l.limit {
+		return 0, ErrSizeLimitExceeded
+	}
+	// read at most enough to reach the limit
+	if remaining := l.limit - l.count; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.count += int64(n)
+	if l.count >= l.limit {
+		return n, ErrSizeLimitExceeded
+	}
+	return n, err
+}
+
+// sizeLimitError returns the status error for a write that failed because it
+// exceeded the size limit, otherwise err
+func sizeLimitError(err error) error {
+	if errors.Is(err, ErrSizeLimitExceeded) {
+		return &Error{err: err, statusCode: http.StatusPaymentRequired}
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestSizeLimitReader(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	content := []byte("0123456789")
+
+	data, err := io.ReadAll(&sizeLimitReader{reader: bytes.NewReader(content), limit: 11})
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// as for WriteStream the content must be smaller than the limit
+	data, err = io.ReadAll(&sizeLimitReader{reader: bytes.NewReader(content), limit: 10})
+	assert.True(t, errors.Is(err, ErrSizeLimitExceeded))
+	assert.Len(t, data, 10)
+
+	// no more than the limit is read from the source
+	source := bytes.NewReader(content)
+	_, err = io.ReadAll(&sizeLimitReader{reader: source, limit: 4})
+	assert.True(t, errors.Is(err, ErrSizeLimitExceeded))
+	assert.Equal(t, 6, source.Len())
+
+	assert.Equal(t, http.StatusPaymentRequired, ErrorFromError(sizeLimitError(ErrorFromError(err))).StatusCode())
+	assert.Equal(t, io.EOF, sizeLimitError(io.EOF))
+}
+
+func TestLocalStorerWriteStreamSizeLimit(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	content := bytes.Repeat([]byte("spam "), 1000)
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// exceeded while detecting the mime type, and after it
+			for _, limit := range []int64{0, 100, int64(len(content))} {
+				_, err := store.WriteStream(ctx, "evidence", multipartRequest(t, "spam.txt", content),
+					WithSizeLimit(limit))
+				assert.Equal(t, http.StatusPaymentRequired, ErrorFromError(err).StatusCode())
+				assert.EqualError(t, err, "filesize exceeds maximum")
+			}
+			_, err := store.Reader(ctx, "evidence")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			wr, err := store.WriteStream(ctx, "evidence", multipartRequest(t, "spam.txt", content),
+				WithSizeLimit(int64(len(content)+1)))
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(content)), wr.Size)
+		})
+	}
+}
+
+// TestStorerWriteStreamSizeLimit checks the blocks of an upload that exceeds
+// the limit are never committed
+func TestStorerWriteStreamSizeLimit(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	var staged, commits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		switch r.URL.Query().Get("comp") {
+		case "block":
+			staged.Add(1)
+			w.WriteHeader(http.StatusCreated)
+		case "blocklist":
+			commits.Add(1)
+			w.Header().Set("ETag", "\"0x1\"")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			// the container exists
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+	azp := newContainerTestStorer(t, srv.URL)
+	ctx := context.Background()
+
+	// more than one block, so some are staged before the limit is reached
+	content := bytes.Repeat([]byte("spam "), chunkSize/2)
+	_, err := azp.WriteStream(ctx, "evidence", multipartRequest(t, "spam.txt", content),
+		WithSizeLimit(int64(len(content)-1)))
+	assert.Equal(t, http.StatusPaymentRequired, ErrorFromError(err).StatusCode())
+	assert.Positive(t, staged.Load())
+	assert.Equal(t, int32(0), commits.Load())
+
+	_, err = azp.WriteStream(ctx, "evidence", multipartRequest(t, "spam.txt", content),
+		WithSizeLimit(int64(len(content)+1)))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), commits.Load())
+}
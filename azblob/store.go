@@ -0,0 +1,91 @@
+package azblob
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+/**
+ * Store is the complete blob storage abstraction. Storer implements it against
+ * azure blob storage, MemStorer and DirStorer implement it locally for tests
+ * and tooling that should not depend on azure or azurite.
+ *
+ * Managing the container (ContainerManager) and SignedURL need the storage
+ * service, so they are only implemented by Storer and are not part of Store.
+ */
+
+var (
+	_ Store = (*Storer)(nil)
+	_ Store = (*MemStorer)(nil)
+	_ Store = (*DirStorer)(nil)
+
+	_ ContainerManager = (*Storer)(nil)
+)
+
+// Writer is the interface in order to carry out write operations on blob storage
+type Writer interface {
+	Write(
+		ctx context.Context,
+		identity string,
+		source io.Reader,
+		opts ...Option,
+	) (*WriteResponse, error)
+	WriteStream(
+		ctx context.Context,
+		identity string,
+		source *http.Request,
+		opts ...Option,
+	) (*WriteResponse, error)
+	WriteStreamFiles(
+		ctx context.Context,
+		source *http.Request,
+		namer StreamNamer,
+		opts ...Option,
+	) (*WriteStreamResponse, error)
+	Put(
+		ctx context.Context,
+		identity string,
+		source io.ReadSeekCloser,
+		opts ...Option,
+	) (*WriteResponse, error)
+	Delete(ctx context.Context, identity string, opts ...Option) error
+	Undelete(ctx context.Context, identity string) error
+}
+
+// Leaser is the interface in order to manage leases on blobs
+type Leaser interface {
+	AcquireLease(ctx context.Context, objectname string, leaseTimeout int32) (string, error)
+	AcquireLeaseRenewable(ctx context.Context, objectname string, leaseTimeout int32) (string, LeaseRenewer, error)
+	ReleaseLease(ctx context.Context, objectname string, leaseID string) error
+	ReleaseLeaseDeferable(ctx context.Context, objectname string, leaseID string)
+	BreakLease(ctx context.Context, objectname string, breakPeriod int32) (int32, error)
+	ChangeLease(ctx context.Context, objectname string, leaseID string, proposedLeaseID string) (string, error)
+}
+
+// ContainerLeaser is the interface in order to manage the lease on the container
+type ContainerLeaser interface {
+	AcquireContainerLease(ctx context.Context, leaseTimeout int32) (string, error)
+	RenewContainerLease(ctx context.Context, leaseID string) error
+	ReleaseContainerLease(ctx context.Context, leaseID string) error
+	BreakContainerLease(ctx context.Context, breakPeriod int32) (int32, error)
+	ChangeContainerLease(ctx context.Context, leaseID string, proposedLeaseID string) (string, error)
+}
+
+// Store is the interface for read, write, list and lease operations on blob
+// storage, regardless of the backing implementation. It has every operation
+// that MemStorer and DirStorer implement as well as Storer.
+type Store interface {
+	Reader
+	Writer
+	Leaser
+	ContainerLeaser
+	Appender
+	Versioner
+	Copier
+	Batcher
+	Scanner
+	Tierer
+	Count(ctx context.Context, tagsFilter string, opts ...Option) (int64, error)
+	DownloadToWriterAt(ctx context.Context, identity string, w io.WriterAt, opts ...Option) (*ReaderResponse, error)
+}
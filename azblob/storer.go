@@ -5,7 +5,10 @@ package azblob
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/datatrails/go-datatrails-common/logger"
 )
@@ -27,23 +30,57 @@ type Storer struct {
 	Container     string
 
 	credential      *SharedKeyCredential
+	tokenCredential azcore.TokenCredential
 	rootURL         string
 	containerURL    string
 	containerClient *ContainerClient
 	serviceClient   *ServiceClient
 
+	// the last successful container check, see WithContainerCheckTTL
+	containerCheckTTL  time.Duration
+	containerMu        sync.Mutex
+	containerCheckedAt time.Time
+
+	// the last user delegation key, for SignedURL
+	delegationKeyMu sync.Mutex
+	delegationKey   *azStorageBlob.UserDelegationKey
+
+	// options applied before those of each Write, WriteStream and Put
+	uploadDefaults []Option
+
 	log                          Logger
 	setReadResponseScannedStatus ReadResponseScannedStatus
 }
 
 type StorerOption func(*Storer)
 
+// WithSetScannedStatus sets the Scanned fields of each ReaderResponse with s.
+// The fields are left empty without it, use ReadResponseScanResult to set them
+// from the metadata written by MarkScanned.
 func WithSetScannedStatus(s ReadResponseScannedStatus) StorerOption {
 	return func(a *Storer) {
 		a.setReadResponseScannedStatus = s
 	}
 }
 
+// WithUploadDefaults sets the options for every Write, WriteStream,
+// WriteStreamFiles and Put, eg. the block size and concurrency for bulk
+// ingest or the access tier. The options given to each call override them.
+func WithUploadDefaults(opts ...Option) StorerOption {
+	return func(a *Storer) {
+		a.uploadDefaults = append(a.uploadDefaults, opts...)
+	}
+}
+
+// WithContainerCheckTTL remembers that the container exists for ttl, rather
+// than checking before every Write and WriteStream. A write that finds the
+// container missing discards the remembered check.
+func WithContainerCheckTTL(ttl time.Duration) StorerOption {
+	return func(a *Storer) {
+		a.containerCheckTTL = ttl
+	}
+}
+
 // New returns new az blob read/write object
 func New(
 	accountName string,
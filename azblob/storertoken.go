@@ -0,0 +1,153 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// accountNameFromURL returns the storage account name from the root url of
+// the blob service, for logging and for signing. Emulators such as azurite
+// address the account by path, eg. http://127.0.0.1:10000/devstoreaccount1/,
+// and the blob service by the first label of the host. Custom domains and
+// private endpoints need WithStorerAccountName.
+func accountNameFromURL(rootURL string) string {
+	u, err := url.Parse(rootURL)
+	if err != nil {
+		return ""
+	}
+	if isEmulatorHost(u) {
+		account, _, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+		return account
+	}
+	account, _, _ := strings.Cut(u.Hostname(), ".")
+	return account
+}
+
+// isEmulatorHost returns true if the url uses path-style addressing: an ip
+// address, localhost or an explicit port.
+func isEmulatorHost(u *url.URL) bool {
+	host := u.Hostname()
+	return u.Port() != "" || host == "localhost" || net.ParseIP(host) != nil
+}
+
+// WithStorerAccountName sets the storage account name for a Storer created
+// with a token credential, rather than taking it from the url. It is needed to
+// sign SignedURL for custom domains and private endpoints.
+func WithStorerAccountName(accountName string) StorerOption {
+	return func(a *Storer) {
+		a.AccountName = accountName
+	}
+}
+
+// NewWithCredential returns a read/write Storer for the container that
+// authenticates with an azure ad token credential - workload identity,
+// managed identity, a client secret or a StaticTokenCredential. Unlike New,
+// it never uses the account keys, so the identity only needs a data plane
+// role such as Storage Blob Data Contributor on the account or container.
+//
+// Without an account key DeleteMany uses single deletes rather than batches,
+// and SignedURL signs with a user delegation key, which needs the Storage
+// Blob Delegator role.
+//
+// The account name is the first label of the host, or the first path segment
+// for emulators. Use WithStorerAccountName for custom domains and private
+// endpoints.
+//
+// example:
+//
+//	url: https://myaccount.blob.core.windows.net/
+func NewWithCredential(
+	url string,
+	container string,
+	credential azcore.TokenCredential,
+	options ...StorerOption,
+) (*Storer, error) {
+	return newWithCredential(url, container, credential, nil, options...)
+}
+
+// NewDefaultAuth returns a read/write Storer for the container that obtains
+// an azure ad credential from the environment, see NewWithCredential.
+func NewDefaultAuth(url string, container string, options ...StorerOption) (*Storer, error) {
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		logger.Sugar.Infof("failed NewDefaultAzureCredential: %v", err)
+		return nil, err
+	}
+	return NewWithCredential(url, container, credential, options...)
+}
+
+func newWithCredential(
+	url string,
+	container string,
+	credential azcore.TokenCredential,
+	clientOptions *azStorageBlob.ClientOptions,
+	options ...StorerOption,
+) (*Storer, error) {
+	var err error
+	if url == "" {
+		return nil, errors.New("url is a required parameter and cannot be empty")
+	}
+	if container == "" {
+		logger.Sugar.Infof("Storer: %v", ErrUnspecifiedContainer)
+		return nil, ErrUnspecifiedContainer
+	}
+	if credential == nil {
+		return nil, errors.New("credential is a required parameter and cannot be nil")
+	}
+
+	// normalise trailing slash
+	url = strings.TrimSuffix(url, "/") + "/"
+	logger.Sugar.Debugf("New Storer with credential: %s%s", url, container)
+
+	azp := &Storer{
+		AccountName:     accountNameFromURL(url),
+		Container:       container,
+		tokenCredential: credential,
+		rootURL:         url,
+		containerURL:    url + container,
+	}
+	for _, option := range options {
+		option(azp)
+	}
+
+	azp.serviceClient, err = azStorageBlob.NewServiceClient(url, credential, clientOptions)
+	if err != nil {
+		logger.Sugar.Infof("unable to create serviceclient %s: %v", url, err)
+		return nil, err
+	}
+	azp.containerClient, err = azp.serviceClient.NewContainerClient(container)
+	if err != nil {
+		logger.Sugar.Infof("unable to create containerclient %s: %v", container, err)
+		return nil, err
+	}
+	return azp, nil
+}
+
+// StaticTokenCredential is a TokenCredential that always returns the same
+// token, for tests and for tokens obtained by other means.
+type StaticTokenCredential struct {
+	token     string
+	expiresOn time.Time
+}
+
+// NewStaticTokenCredential returns a credential for the bearer token, which
+// expires at expiresOn
+func NewStaticTokenCredential(token string, expiresOn time.Time) *StaticTokenCredential {
+	return &StaticTokenCredential{token: token, expiresOn: expiresOn}
+}
+
+// GetToken implements azcore.TokenCredential. The scopes are ignored.
+func (c *StaticTokenCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: c.token, ExpiresOn: c.expiresOn}, nil
+}
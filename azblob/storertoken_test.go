@@ -0,0 +1,84 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestAccountNameFromURL(t *testing.T) {
+	assert.Equal(t, "myaccount", accountNameFromURL("https://myaccount.blob.core.windows.net/"))
+	assert.Equal(t, "devstoreaccount1", accountNameFromURL("http://127.0.0.1:10000/devstoreaccount1/"))
+	assert.Equal(t, "devstoreaccount1", accountNameFromURL("http://localhost/devstoreaccount1"))
+	assert.Equal(t, "devstoreaccount1", accountNameFromURL("http://azurite:10000/devstoreaccount1/"))
+	assert.Equal(t, "myaccount", accountNameFromURL("https://myaccount.blob.core.windows.net/some/path"))
+	assert.Equal(t, "", accountNameFromURL("http://127.0.0.1:10000/"))
+	assert.Equal(t, "", accountNameFromURL("://"))
+}
+
+func TestWithStorerAccountName(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	credential := NewStaticTokenCredential("TOKEN", time.Now().Add(time.Hour))
+
+	azp, err := NewWithCredential("https://blobs.example.com/", "devcontainer", credential)
+	require.NoError(t, err)
+	assert.Equal(t, "blobs", azp.AccountName)
+
+	azp, err = NewWithCredential("https://blobs.example.com/", "devcontainer", credential,
+		WithStorerAccountName("myaccount"))
+	require.NoError(t, err)
+	assert.Equal(t, "myaccount", azp.AccountName)
+	assert.Equal(t, "myaccount", azp.sasAccountName())
+}
+
+func TestNewWithCredential(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	credential := NewStaticTokenCredential("TOKEN", time.Now().Add(time.Hour))
+
+	_, err := NewWithCredential("", "devcontainer", credential)
+	assert.Error(t, err)
+	_, err = NewWithCredential("https://myaccount.blob.core.windows.net", "", credential)
+	assert.ErrorIs(t, err, ErrUnspecifiedContainer)
+	_, err = NewWithCredential("https://myaccount.blob.core.windows.net", "devcontainer", nil)
+	assert.Error(t, err)
+
+	var puts atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer TOKEN", r.Header.Get("Authorization"))
+		assert.Empty(t, r.URL.Query().Get("sig"))
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "/myaccount/devcontainer/tenant/1/blob", r.URL.Path)
+		puts.Add(1)
+		w.Header().Set("ETag", "\"0x1\"")
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	azp, err := newWithCredential(srv.URL+"/myaccount", "devcontainer", credential,
+		&azStorageBlob.ClientOptions{Transport: srv.Client()})
+	require.NoError(t, err)
+	assert.Equal(t, "myaccount", azp.AccountName)
+	assert.Nil(t, azp.credential, "account keys are never used")
+
+	wr, err := azp.Put(context.Background(), "tenant/1/blob", NewBytesReaderCloser([]byte("VALUE")))
+	require.NoError(t, err)
+	assert.Equal(t, "\"0x1\"", *wr.ETag)
+	assert.Equal(t, int32(1), puts.Load())
+}
@@ -0,0 +1,198 @@
+package azblob
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidTagsFilter = errors.New("invalid tags filter expression")
+)
+
+const (
+	containerFilterKey = "@container"
+)
+
+// tagsCondition is a single `key op 'value'` term of a tags filter
+type tagsCondition struct {
+	key   string
+	op    string
+	value string
+}
+
+// tagsFilter is a parsed tags filter expression. The expression is held in
+// disjunctive normal form, each inner slice is a conjunction of conditions.
+//
+// This supports the subset of the azure syntax that is used for both Find
+// Blobs by Tags and the x-ms-if-tags conditional header. Parentheses are not
+// supported.
+//
+// See: https://learn.microsoft.com/en-us/rest/api/storageservices/find-blobs-by-tags?tabs=microsoft-entra-id#remarks
+type tagsFilter [][]tagsCondition
+
+// parseTagsFilter parses a tags filter expression such as
+//
+//	"firstindex">'0000000000000000' AND "@container"='merklelogs'
+func parseTagsFilter(expr string) (tagsFilter, error) {
+	toks, err := tokenizeTagsFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("%w: empty expression", ErrInvalidTagsFilter)
+	}
+
+	var filter tagsFilter
+	var conjunction []tagsCondition
+	for i := 0; i < len(toks); {
+		if len(toks)-i < 3 {
+			return nil, fmt.Errorf("%w: incomplete term in `%s'", ErrInvalidTagsFilter, expr)
+		}
+		c := tagsCondition{key: toks[i].text, op: toks[i+1].text, value: toks[i+2].text}
+		if toks[i].kind != tokKey || toks[i+1].kind != tokOp || toks[i+2].kind != tokValue {
+			return nil, fmt.Errorf("%w: expected key op 'value' in `%s'", ErrInvalidTagsFilter, expr)
+		}
+		conjunction = append(conjunction, c)
+		i += 3
+		if i == len(toks) {
+			break
+		}
+		switch strings.ToUpper(toks[i].text) {
+		case "AND":
+		case "OR":
+			filter = append(filter, conjunction)
+			conjunction = nil
+		default:
+			return nil, fmt.Errorf("%w: expected AND or OR, got `%s'", ErrInvalidTagsFilter, toks[i].text)
+		}
+		i++
+		if i == len(toks) {
+			return nil, fmt.Errorf("%w: dangling operator in `%s'", ErrInvalidTagsFilter, expr)
+		}
+	}
+	filter = append(filter, conjunction)
+	return filter, nil
+}
+
+// keys returns the distinct tag keys referenced by the filter, excluding
+// @container
+func (f tagsFilter) keys() []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, conjunction := range f {
+		for _, c := range conjunction {
+			if c.key == containerFilterKey || seen[c.key] {
+				continue
+			}
+			seen[c.key] = true
+			keys = append(keys, c.key)
+		}
+	}
+	return keys
+}
+
+// match returns true if the tags, for a blob in the named container, satisfy
+// the filter. Comparisons are lexicographic, as they are for azure.
+func (f tagsFilter) match(container string, tags map[string]string) bool {
+	for _, conjunction := range f {
+		if matchConjunction(conjunction, container, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchConjunction(conjunction []tagsCondition, container string, tags map[string]string) bool {
+	for _, c := range conjunction {
+		var value string
+		if c.key == containerFilterKey {
+			value = container
+		} else {
+			var ok bool
+			value, ok = tags[c.key]
+			if !ok {
+				return false
+			}
+		}
+		if !compareTag(value, c.op, c.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareTag(value string, op string, operand string) bool {
+	switch op {
+	case "=":
+		return value == operand
+	case "<>":
+		return value != operand
+	case ">":
+		return value > operand
+	case ">=":
+		return value >= operand
+	case "<":
+		return value < operand
+	case "<=":
+		return value <= operand
+	default:
+		return false
+	}
+}
+
+type tagsFilterTokenKind int
+
+const (
+	tokKey tagsFilterTokenKind = iota
+	tokOp
+	tokValue
+	tokWord
+)
+
+type tagsFilterToken struct {
+	kind tagsFilterTokenKind
+	text string
+}
+
+func tokenizeTagsFilter(expr string) ([]tagsFilterToken, error) {
+	var toks []tagsFilterToken
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(expr[i+1:], ch)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated quote in `%s'", ErrInvalidTagsFilter, expr)
+			}
+			kind := tokKey
+			if ch == '\'' {
+				kind = tokValue
+			}
+			toks = append(toks, tagsFilterToken{kind: kind, text: expr[i+1 : i+1+end]})
+			i += end + 2
+		case strings.IndexByte("=<>", ch) >= 0:
+			j := i + 1
+			for j < len(expr) && strings.IndexByte("=<>", expr[j]) >= 0 {
+				j++
+			}
+			toks = append(toks, tagsFilterToken{kind: tokOp, text: expr[i:j]})
+			i = j
+		default:
+			j := i
+			for j < len(expr) && strings.IndexByte(" \t\n=<>\"'", expr[j]) < 0 {
+				j++
+			}
+			word := expr[i:j]
+			kind := tokKey
+			if up := strings.ToUpper(word); up == "AND" || up == "OR" {
+				kind = tokWord
+			}
+			toks = append(toks, tagsFilterToken{kind: kind, text: word})
+			i = j
+		}
+	}
+	return toks, nil
+}
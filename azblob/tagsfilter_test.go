@@ -0,0 +1,47 @@
+package azblob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagsFilter(t *testing.T) {
+	tags := map[string]string{
+		"firstindex": "0000000000000010",
+		"cat":        "tiger",
+	}
+
+	tests := []struct {
+		name      string
+		expr      string
+		container string
+		want      bool
+		wantErr   bool
+	}{
+		{name: "quoted key greater", expr: `"firstindex">'0000000000000000'`, want: true},
+		{name: "bare key equal", expr: `cat='tiger'`, want: true},
+		{name: "and both true", expr: `cat='tiger' AND firstindex>='0000000000000010'`, want: true},
+		{name: "and one false", expr: `cat='tiger' AND firstindex<'0000000000000010'`, want: false},
+		{name: "or one true", expr: `cat='lion' OR cat='tiger'`, want: true},
+		{name: "missing tag", expr: `penguin='emperorpenguin'`, want: false},
+		{name: "not equal", expr: `cat<>'lion'`, want: true},
+		{name: "container", expr: `@container='zoo' AND cat='tiger'`, container: "zoo", want: true},
+		{name: "other container", expr: `@container='zoo' AND cat='tiger'`, container: "farm", want: false},
+		{name: "empty", expr: ``, wantErr: true},
+		{name: "unterminated", expr: `cat='tiger`, wantErr: true},
+		{name: "dangling and", expr: `cat='tiger' AND`, wantErr: true},
+		{name: "missing value", expr: `cat=`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseTagsFilter(tt.expr)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTagsFilter)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, f.match(tt.container, tags))
+		})
+	}
+}
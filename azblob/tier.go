@@ -0,0 +1,266 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// AccessTier is the access tier of a block blob, which trades the cost of
+// storage against the cost of access. The Cold tier needs a later storage
+// service version than the sdk uses, so it is not available.
+type AccessTier = azStorageBlob.AccessTier
+
+const (
+	AccessTierHot     = azStorageBlob.AccessTierHot
+	AccessTierCool    = azStorageBlob.AccessTierCool
+	AccessTierArchive = azStorageBlob.AccessTierArchive
+)
+
+// RehydratePriority is the priority of moving a blob out of the archive tier.
+// Standard may take up to 15 hours, High may complete in under an hour for
+// blobs smaller than 10GB.
+type RehydratePriority = azStorageBlob.RehydratePriority
+
+const (
+	RehydratePriorityStandard = azStorageBlob.RehydratePriorityStandard
+	RehydratePriorityHigh     = azStorageBlob.RehydratePriorityHigh
+)
+
+// Tierer is the interface for managing the access tier of blobs
+type Tierer interface {
+	SetTier(ctx context.Context, identity string, tier AccessTier, opts ...Option) error
+	Rehydrate(ctx context.Context, identity string, tier AccessTier, priority RehydratePriority, opts ...Option) error
+}
+
+// accessTierOption returns the tier to set on write, nil for the account
+// default
+func accessTierOption(options *StorerOptions) *azStorageBlob.AccessTier {
+	if options.accessTier == "" {
+		return nil
+	}
+	tier := options.accessTier
+	return &tier
+}
+
+func checkTier(tier AccessTier) error {
+	switch tier {
+	case AccessTierHot, AccessTierCool, AccessTierArchive:
+		return nil
+	default:
+		return NewStatusError(fmt.Sprintf("unsupported access tier '%s'", tier), http.StatusBadRequest)
+	}
+}
+
+// checkTierOptions returns an error for the access conditions that azure
+// does not support when setting the tier. Only WithWhereTags() is supported.
+func checkTierOptions(options *StorerOptions) error {
+	if options.etagCondition == ETagMatch || options.etagCondition == ETagNoneMatch ||
+		options.sinceCondition != IfConditionNotUsed {
+		return NewStatusError("only tag conditions are supported for setting the tier", http.StatusBadRequest)
+	}
+	if options.snapshot != "" && options.versionID != "" {
+		return NewStatusError("only one of snapshot and version can be specified", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// checkRehydrate returns an error if the tier is not one a blob can be
+// rehydrated to
+func checkRehydrate(tier AccessTier) error {
+	if tier != AccessTierHot && tier != AccessTierCool {
+		return NewStatusError(fmt.Sprintf("can't rehydrate to access tier '%s'", tier), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// SetTier sets the access tier of the blob. Moving a blob to the archive tier
+// is immediate, and its content can't be read until it is rehydrated. Moving
+// an archived blob to another tier starts rehydrating it, see Rehydrate. The
+// etag and last modified time are unchanged.
+//
+// Options:
+//
+//	WithSnapshot() or WithVersionID() - set the tier of a snapshot or version
+//	WithRehydratePriority() - the priority for rehydrating an archived blob
+//	WithLeaseID() - only set the tier if the lease is active
+//	WithWhereTags() - only set the tier if the tags of the blob match
+func (azp *Storer) SetTier(ctx context.Context, identity string, tier AccessTier, opts ...Option) error {
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	logger.Sugar.Infof("SetTier %s %s", identity, tier)
+	if err := checkTier(tier); err != nil {
+		return err
+	}
+	if err := checkTierOptions(options); err != nil {
+		return err
+	}
+	blobClient, err := azp.blobClient(identity, options)
+	if err != nil {
+		return err
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return err
+	}
+	setTierOptions := &azStorageBlob.BlobSetTierOptions{
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	}
+	if options.rehydratePriority != "" {
+		setTierOptions.RehydratePriority = &options.rehydratePriority
+	}
+	if _, err = blobClient.SetTier(ctx, tier, setTierOptions); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// Rehydrate starts moving an archived blob to the hot or cool tier. The blob
+// can't be read until the rehydration completes, which may take hours. In the
+// meantime ReaderResponse.ArchiveStatus and the ArchiveStatus of list items
+// report the rehydration as pending. The options are as for SetTier.
+func (azp *Storer) Rehydrate(
+	ctx context.Context, identity string, tier AccessTier, priority RehydratePriority, opts ...Option,
+) error {
+	if err := checkRehydrate(tier); err != nil {
+		return err
+	}
+	return azp.SetTier(ctx, identity, tier, append(opts[:len(opts):len(opts)], WithRehydratePriority(priority))...)
+}
+
+// SetTierMany sets the access tier of each of the blobs, using the blob batch
+// endpoint with up to BatchMaxSize blobs per request. If the storer has no
+// shared key the tiers are set by concurrent single requests instead. The
+// results are as for DeleteMany.
+//
+// Options:
+//
+//	WithConcurrency() - the maximum number of batches in flight, default 4
+//	WithSnapshot() or WithVersionID() - set the tier of that snapshot or version of each blob
+//	WithRehydratePriority() - the priority for rehydrating archived blobs
+//	WithLeaseID() - only set the tier of the blobs with this lease active
+//	WithWhereTags() - only set the tier of the blobs whose tags match
+//	WithDryRun() - log the blobs that would be changed, without changing them
+func (azp *Storer) SetTierMany(
+	ctx context.Context, identities []string, tier AccessTier, opts ...Option,
+) ([]BatchResult, error) {
+	logger.Sugar.Infof("SetTierMany %d blobs %s", len(identities), tier)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := checkTier(tier); err != nil {
+		return nil, err
+	}
+	if err := checkTierOptions(options); err != nil {
+		return nil, err
+	}
+	results := newBatchResults(identities)
+	if options.dryRun {
+		for _, identity := range identities {
+			logger.Sugar.Infof("SetTierMany dry run: would set %s to %s", identity, tier)
+		}
+		return results, nil
+	}
+	skipped := func(i int) {
+		results[i].Err = ErrorFromError(ctx.Err())
+	}
+
+	if azp.credential == nil {
+		concurrency := options.concurrency
+		if concurrency <= 0 {
+			concurrency = defaultManyConcurrency
+		}
+		runConcurrently(ctx, len(identities), concurrency, func(i int) {
+			results[i].Err = batchItemError(azp.SetTier(ctx, identities[i], tier, opts...))
+		}, skipped)
+		return results, ctx.Err()
+	}
+
+	concurrency := options.concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	numBatches := (len(identities) + BatchMaxSize - 1) / BatchMaxSize
+	runConcurrently(ctx, numBatches, concurrency, func(n int) {
+		start := n * BatchMaxSize
+		end := min(start+BatchMaxSize, len(identities))
+		azp.setTierBatch(ctx, results[start:end], tier, options)
+	}, func(n int) {
+		start := n * BatchMaxSize
+		end := min(start+BatchMaxSize, len(identities))
+		for i := start; i < end; i++ {
+			skipped(i)
+		}
+	})
+	return results, ctx.Err()
+}
+
+// setTierBatch sets the tier of the blobs in a single batch request, filling
+// in the results
+func (azp *Storer) setTierBatch(ctx context.Context, results []BatchResult, tier AccessTier, options *StorerOptions) {
+	query := url.Values{"comp": {"tier"}}
+	if options.snapshot != "" {
+		query.Set("snapshot", options.snapshot)
+	}
+	if options.versionID != "" {
+		query.Set("versionid", options.versionID)
+	}
+	requests := make([]*http.Request, len(results))
+	for i := range results {
+		req, err := http.NewRequest(
+			http.MethodPut, azp.containerURL+"/"+escapeBlobPath(results[i].Identity)+"?"+query.Encode(), nil)
+		if err != nil {
+			results[i].Err = ErrorFromError(err)
+			continue
+		}
+		req.Header.Set("x-ms-access-tier", string(tier))
+		if options.rehydratePriority != "" {
+			req.Header.Set("x-ms-rehydrate-priority", string(options.rehydratePriority))
+		}
+		if options.leaseID != "" {
+			req.Header.Set("x-ms-lease-id", options.leaseID)
+		}
+		if options.etagCondition == TagsWhere {
+			req.Header.Set("x-ms-if-tags", options.etag)
+		}
+		requests[i] = req
+	}
+	responses, err := azp.submitBatch(ctx, requests)
+	for i := range results {
+		switch {
+		case results[i].Err != nil:
+		case err != nil:
+			results[i].Err = err
+		case responses[i] == nil:
+			results[i].Err = NewStatusError(
+				fmt.Sprintf("no response in batch for set tier of %s", results[i].Identity), http.StatusInternalServerError)
+		case responses[i].StatusCode == http.StatusOK, responses[i].StatusCode == http.StatusAccepted:
+		default:
+			results[i].Err = restResponseError(responses[i], "set tier "+results[i].Identity)
+		}
+	}
+}
+
+// propertiesTier copies the tier of the blob from its properties into the
+// response
+func propertiesTier(props azStorageBlob.BlobGetPropertiesResponse, resp *ReaderResponse) {
+	if props.AccessTier != nil {
+		resp.AccessTier = AccessTier(*props.AccessTier)
+	}
+	if props.AccessTierInferred != nil {
+		resp.AccessTierInferred = *props.AccessTierInferred
+	}
+	if props.ArchiveStatus != nil {
+		resp.ArchiveStatus = azStorageBlob.ArchiveStatus(*props.ArchiveStatus)
+	}
+}
@@ -10,9 +10,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/textproto"
-	"strconv"
+	"net/url"
 	"time"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -29,58 +30,6 @@ const (
 	chunkSize = 2 * 1024 * 1024
 )
 
-func (azp *Storer) checkContainer(ctx context.Context) error {
-	logger.Sugar.Debugf("Checking container URL %s", azp.containerURL)
-	_, err := azp.containerClient.GetProperties(ctx, nil)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	return nil
-}
-
-// setMetadata sets metadata in blob storage
-func (azp *Storer) setMetadata(
-	ctx context.Context,
-	identity string,
-	metadata map[string]string,
-) error {
-	logger.Sugar.Debugf("setMetadata BlockBlob %s: %v", identity, metadata)
-
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	_, err = blobClient.SetMetadata(ctx, metadata, nil)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	return nil
-}
-
-// setTags sets Tags in blob storage
-func (azp *Storer) setTags(
-	ctx context.Context,
-	identity string,
-	tags map[string]string,
-) error {
-	logger.Sugar.Debugf("setTags BlockBlob %s: %v", identity, tags)
-
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	_, err = blobClient.SetTags(
-		ctx,
-		&azStorageBlob.BlobSetTagsOptions{
-			TagsMap: tags,
-		},
-	)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	return nil
-}
-
 // Write writes to blob from io.Reader.
 func (azp *Storer) Write(
 	ctx context.Context,
@@ -94,34 +43,11 @@ func (azp *Storer) Write(
 	}
 	logger.Sugar.Debugf("Create BlockBlob %s", identity)
 
-	options := &StorerOptions{}
-	for _, opt := range opts {
-		opt(options)
-	}
+	options := azp.uploadOptions(opts)
 
-	if options.etagCondition != EtagNotUsed {
-		return nil, errors.New("etag conditions are not supported on streaming uploads")
-	}
-
-	wr, err := azp.writeStream(ctx, identity, source, options.leaseID)
-	if err != nil {
-		return nil, err
-	}
-	if options.metadata != nil {
-		// upload metadata
-		err = azp.setMetadata(ctx, identity, options.metadata)
-		if err != nil {
-			return nil, err
-		}
-	}
-	if options.tags != nil {
-		// upload tags
-		err = azp.setTags(ctx, identity, options.tags)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return wr, nil
+	wr, err := azp.writeStream(ctx, identity, source, options, nil)
+	azp.forgetContainerCheck(err)
+	return wr, err
 }
 
 // Write writes to blob from http request.
@@ -137,19 +63,61 @@ func (azp *Storer) WriteStream(
 	}
 	logger.Sugar.Debugf("Create BlockBlob %s", identity)
 
+	options := azp.uploadOptions(opts)
+
+	wr, err := streamReader(ctx, azp, identity, source, options)
+	azp.forgetContainerCheck(err)
+	return wr, err
+}
+
+// uploadOptions applies the WithUploadDefaults options of the Storer and then
+// opts
+func (azp *Storer) uploadOptions(opts []Option) *StorerOptions {
 	options := &StorerOptions{}
+	for _, opt := range azp.uploadDefaults {
+		opt(options)
+	}
 	for _, opt := range opts {
 		opt(options)
 	}
+	return options
+}
 
-	return azp.streamReader(ctx, identity, source, options)
+// blobHTTPHeaders returns the content headers for a write, nil if there are
+// none
+func blobHTTPHeaders(options *StorerOptions) *azStorageBlob.BlobHTTPHeaders {
+	if options.contentType == "" && options.cacheControl == "" &&
+		options.contentDisposition == "" && options.contentMD5 == nil {
+		return nil
+	}
+	headers := &azStorageBlob.BlobHTTPHeaders{BlobContentMD5: options.contentMD5}
+	if options.contentType != "" {
+		headers.BlobContentType = &options.contentType
+	}
+	if options.cacheControl != "" {
+		headers.BlobCacheControl = &options.cacheControl
+	}
+	if options.contentDisposition != "" {
+		headers.BlobContentDisposition = &options.contentDisposition
+	}
+	return headers
 }
 
+// writeStream uploads the reader as a block blob. The metadata and tags are
+// written with the commit of the block list, so readers never observe the
+// content without them. If commitMetadata is not nil, it is called once the
+// reader is exhausted and its result is used in place of the metadata option.
+//
+// The access conditions from the options are also applied on the commit, so a
+// failed condition leaves the existing blob untouched and the staged blocks
+// are discarded by azure. On failure the returned WriteResponse reports the
+// storage error code, eg. ConditionNotMet.
 func (azp *Storer) writeStream(
 	ctx context.Context,
 	identity string,
 	reader io.Reader,
-	leaseID string,
+	options *StorerOptions,
+	commitMetadata func() (map[string]string, error),
 ) (*WriteResponse, error) {
 	logger.Sugar.Debugf("write %s", identity)
 	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(identity)
@@ -157,42 +125,127 @@ func (azp *Storer) writeStream(
 		logger.Sugar.Infof("Cannot get block blob client blob: %v", err)
 		return nil, ErrorFromError(err)
 	}
-	blobAccessConditions := azStorageBlob.BlobAccessConditions{
-		LeaseAccessConditions:    &azStorageBlob.LeaseAccessConditions{},
-		ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{},
+	if err = checkUploadOptions(options); err != nil {
+		return nil, err
 	}
-	if leaseID != "" {
-		blobAccessConditions.LeaseAccessConditions.LeaseID = &leaseID
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
 	}
 
-	// Sream uploading does not support setting tags because the pages are
-	// uploaded in parallel and the tags can only be set once those pages block
-	// ids are commited. Use putBlob if you want this behaviour.
-	r, err := blockBlobClient.UploadStream(
-		ctx,
-		reader,
-		azStorageBlob.UploadStreamOptions{
-			BufferSize:           chunkSize,
-			MaxBuffers:           3,
-			BlobAccessConditions: &blobAccessConditions,
-		},
-	)
+	upload := &blockUpload{
+		client:         blockBlobClient,
+		options:        options,
+		conditions:     &blobAccessConditions,
+		commitMetadata: commitMetadata,
+	}
+	r, err := upload.run(ctx, reader)
 	if err != nil {
 		logger.Sugar.Infof("Cannot upload blob: %v", err)
-		return nil, ErrorFromError(err)
+		wr := &WriteResponse{}
+		normaliseWriteResponseErr(err, wr)
+		return wr, ErrorFromError(err)
 
 	}
 	return uploadStreamWriteResponse(r), nil
 }
 
-func (azp *Storer) streamReader(
+// multipartWriter is implemented by each store so that the multipart, hashing
+// and mime type handling of WriteStream is shared between them.
+type multipartWriter interface {
+	writeStream(
+		ctx context.Context,
+		identity string,
+		reader io.Reader,
+		options *StorerOptions,
+		commitMetadata func() (map[string]string, error),
+	) (*WriteResponse, error)
+}
+
+// StreamFile describes a file part of a multipart WriteStreamFiles request, so
+// that a StreamNamer can choose where it is written.
+type StreamFile struct {
+	FormName string // the name of the form field
+	FileName string // the file name supplied by the client
+	Index    int    // zero based count of the file parts
+	Header   textproto.MIMEHeader
+
+	// The non file form fields which preceded the file part in the request
+	Fields url.Values
+}
+
+// StreamNamer returns the identity of the blob a file part is written to, and
+// any metadata to add to the blob. An error aborts the request and is returned
+// to the caller, so return a StatusError for a bad request.
+type StreamNamer func(file StreamFile) (identity string, metadata map[string]string, err error)
+
+// maxStreamFieldSize is the largest non file form field accepted by
+// WriteStreamFiles, the fields are held in memory.
+const maxStreamFieldSize = 64 * 1024
+
+// WriteStreamFiles writes each file part of a multipart http request to the
+// blob named by namer.
+//
+// The non file form fields are returned in the response, and each file part is
+// given the fields which precede it, so clients should send the fields first
+// if they are to influence the naming or metadata of the files. The files are
+// written in order. If one fails the blobs already written are kept, and are
+// listed in the response returned with the error.
+//
+// The options apply to every file.
+func (azp *Storer) WriteStreamFiles(
 	ctx context.Context,
+	source *http.Request,
+	namer StreamNamer,
+	opts ...Option,
+) (*WriteStreamResponse, error) {
+	err := azp.checkContainer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	options := azp.uploadOptions(opts)
+
+	resp, err := streamFiles(ctx, azp, source, namer, true, options)
+	azp.forgetContainerCheck(err)
+	return resp, err
+}
+
+// streamReader writes the single file part of the request to identity, for
+// WriteStream. It returns nil if the request has no file part.
+func streamReader(
+	ctx context.Context,
+	azp multipartWriter,
 	identity string,
 	r *http.Request,
 	options *StorerOptions,
 ) (*WriteResponse, error) {
+	namer := func(file StreamFile) (string, map[string]string, error) {
+		if file.Index > 0 {
+			// we got multiple files - bad request
+			logger.Sugar.Infof("only one file expected")
+			return "", nil, NewStatusError("only one file expected", http.StatusBadRequest)
+		}
+		return identity, nil, nil
+	}
+	// the form fields are not returned, so they are skipped rather than read
+	resp, err := streamFiles(ctx, azp, r, namer, false, options)
+	if resp == nil || len(resp.Files) == 0 {
+		return nil, err
+	}
+	return resp.Files[0].WriteResponse, err
+}
+
+func streamFiles(
+	ctx context.Context,
+	azp multipartWriter,
+	r *http.Request,
+	namer StreamNamer,
+	readFields bool,
+	options *StorerOptions,
+) (*WriteStreamResponse, error) {
 
-	logger.Sugar.Debugf("streamReader: %v", r)
+	logger.Sugar.Debugf("streamFiles: %v", r)
 	var err error
 
 	if r.ContentLength < 1 {
@@ -208,124 +261,176 @@ func (azp *Storer) streamReader(
 		return nil, NewStatusError(fmt.Sprintf("failed to get multipart reader: %v", err), http.StatusBadRequest)
 	}
 
-	var resp *WriteResponse
-
-	// we don't know how many files to expect but we only accept one - for now that is
-	numFiles := 1
+	resp := &WriteStreamResponse{Fields: url.Values{}}
 	for {
 
 		part, err := reader.NextPart()
 		if err == io.EOF { //nolint https://github.com/golang/go/issues/39155
 			// we've got all of it just exit
-			logger.Sugar.Debugf("got complete file")
+			logger.Sugar.Debugf("got complete request")
 			break
 		}
 
 		if err != nil {
 			// actual error just log and return
-			return nil, NewStatusError(fmt.Sprintf("failed to get next part: %v", err), http.StatusInternalServerError)
+			return resp, NewStatusError(fmt.Sprintf("failed to get next part: %v", err), http.StatusInternalServerError)
 		}
 
-		defer part.Close()
-		logger.Sugar.Debugf("uploading %s", part.FileName())
-
-		if numFiles > 1 {
-			// we got multiple files - bad request
-			logger.Sugar.Infof("only one file expected")
-			return nil, NewStatusError("only one file expected", http.StatusBadRequest)
-		}
-
-		// skip if we don't know what file it is - or if it is file at all
+		// form fields are collected for the caller, if it wants them
 		if part.FileName() == "" {
+			if !readFields {
+				part.Close()
+				continue
+			}
+			err = readStreamField(part, resp.Fields)
+			part.Close()
+			if err != nil {
+				return resp, err
+			}
 			continue
 		}
 
-		// set up our hashing reader
-		hasher := sha256.New()
-		uploadData := &hashingReader{
-			hasher: hasher,
-			part:   part,
+		file := StreamFile{
+			FormName: part.FormName(),
+			FileName: part.FileName(),
+			Index:    len(resp.Files),
+			Header:   part.Header,
+			Fields:   cloneValues(resp.Fields),
 		}
+		identity, metadata, err := namer(file)
+		if err != nil {
+			part.Close()
+			return resp, err
+		}
+		logger.Sugar.Debugf("uploading %s to %s", part.FileName(), identity)
+
+		wr, err := streamFile(ctx, azp, identity, part, options, metadata)
+		part.Close()
+		if wr != nil {
+			resp.Files = append(resp.Files, &StreamFileResponse{
+				Identity:      identity,
+				FormName:      file.FormName,
+				FileName:      file.FileName,
+				WriteResponse: wr,
+			})
+		}
+		if err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
 
-		// check we are within the correct size if size limited
-		// first check if we have a size limit. -1 is unlimited.
-		if options.sizeLimit >= 0 {
-			var buffer bytes.Buffer
-			counter := io.TeeReader(part, &buffer)
+// readStreamField adds the value of a non file form field to fields
+func readStreamField(part *multipart.Part, fields url.Values) error {
+	formName := part.FormName()
+	value, err := io.ReadAll(io.LimitReader(part, maxStreamFieldSize+1))
+	if err != nil {
+		return NewStatusError(fmt.Sprintf("failed to read form field %s: %v", formName, err), http.StatusBadRequest)
+	}
+	if len(value) > maxStreamFieldSize {
+		return NewStatusError(fmt.Sprintf("form field %s is too large", formName), http.StatusBadRequest)
+	}
+	fields.Add(formName, string(value))
+	return nil
+}
 
-			count, copyErr := io.Copy(io.Discard, counter)
-			if copyErr != nil {
-				return nil, copyErr
-			}
+func cloneValues(values url.Values) url.Values {
+	c := make(url.Values, len(values))
+	for k, v := range values {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
 
-			logger.Sugar.Infof("request file size: %d", count)
+// streamFile writes a single file part, with its hash, size and mime type
+// metadata
+func streamFile(
+	ctx context.Context,
+	azp multipartWriter,
+	identity string,
+	part *multipart.Part,
+	options *StorerOptions,
+	metadata map[string]string,
+) (*WriteResponse, error) {
 
-			if count >= options.sizeLimit {
-				return nil, NewStatusError("filesize exceeds maximum", http.StatusPaymentRequired)
-			}
-			uploadData.part = &buffer
-		}
+	// set up our hashing reader
+	hasher := sha256.New()
+	uploadData := &hashingReader{
+		hasher: hasher,
+		part:   part,
+	}
 
-		// Use mime type if it was supplied.
-		var mimeType string
-		contentTypeKey := textproto.CanonicalMIMEHeaderKey(ContentKey)
-		if len(part.Header[contentTypeKey]) > 0 {
-			mimeType = part.Header[contentTypeKey][0] // There should only be one anyway.
-		} else {
-			// stores bytes required to detect mimetype
-			header := bytes.NewBuffer(nil)
-			detector := io.TeeReader(uploadData.part, header)
-
-			// after detection bytes used to detect are in header
-			// remaining bytes are still in uploadData.part
-			m, readerErr := mimetype.DetectReader(detector)
-			if readerErr != nil {
-				return nil, readerErr
-			}
-			mimeType = m.String()
+	// check we are within the correct size if size limited
+	// first check if we have a size limit. -1 is unlimited.
+	// The limit is enforced as the content is uploaded, exceeding it fails the
+	// upload before the blocks are committed.
+	if options.sizeLimit >= 0 {
+		uploadData.part = &sizeLimitReader{reader: part, limit: options.sizeLimit}
+	}
 
-			// catenate the header and remaining data to make it look like a new reader
-			uploadData.part = io.MultiReader(header, uploadData.part)
+	// Use mime type if it was supplied.
+	var mimeType string
+	contentTypeKey := textproto.CanonicalMIMEHeaderKey(ContentKey)
+	if len(part.Header[contentTypeKey]) > 0 {
+		mimeType = part.Header[contentTypeKey][0] // There should only be one anyway.
+	} else {
+		// stores bytes required to detect mimetype
+		header := bytes.NewBuffer(nil)
+		detector := io.TeeReader(uploadData.part, header)
+
+		// after detection bytes used to detect are in header
+		// remaining bytes are still in uploadData.part
+		m, readerErr := mimetype.DetectReader(detector)
+		if readerErr != nil {
+			return nil, sizeLimitError(readerErr)
 		}
-		logger.Sugar.Debugf("Mime type is: %s", mimeType)
+		mimeType = m.String()
 
-		// prepare blob
-		resp, err = azp.writeStream(ctx, identity, uploadData, options.leaseID)
-		if err != nil {
-			return nil, err
-		}
+		// catenate the header and remaining data to make it look like a new reader
+		uploadData.part = io.MultiReader(header, uploadData.part)
+	}
+	logger.Sugar.Debugf("Mime type is: %s", mimeType)
 
-		// get hash, size and mime type from reader
+	// The hash and size are only known once the content has been read,
+	// the upload calls this before it commits the blob.
+	var accepted WriteResponse
+	commitMetadata := func() (map[string]string, error) {
 		var h [sha256.Size]byte
 		uploadData.hasher.Sum(h[:0])
-		resp.HashValue = hex.EncodeToString(h[:])
-		resp.Size = uploadData.size
-		resp.MimeType = mimeType
-		resp.TimestampAccepted = time.Now().UTC().Format(time.RFC3339)
-
-		// construct metadata
-		meta := map[string]string{
-			HashKey: resp.HashValue,
-			SizeKey: strconv.FormatInt(resp.Size, 10),
-			MimeKey: resp.MimeType,
-			TimeKey: resp.TimestampAccepted,
+		accepted.HashValue = hex.EncodeToString(h[:])
+		accepted.Size = uploadData.size
+		accepted.MimeType = mimeType
+		accepted.TimestampAccepted = time.Now().UTC().Format(time.RFC3339)
+
+		// construct metadata, escaped as it is unescaped by Reader
+		meta, err := contentMetadataSchema.Encode(ContentMetadata{
+			HashValue:         accepted.HashValue,
+			MimeType:          accepted.MimeType,
+			Size:              accepted.Size,
+			TimestampAccepted: accepted.TimestampAccepted,
+		})
+		if err != nil {
+			return nil, err
 		}
+		meta[EscapedKey] = "true"
 		for k, v := range options.metadata {
 			meta[k] = v
 		}
-		// upload metadata
-		err = azp.setMetadata(ctx, identity, meta)
-		if err != nil {
-			return nil, err
-		}
-
-		// upload tags
-		err = azp.setTags(ctx, identity, options.tags)
-		if err != nil {
-			return nil, err
+		for k, v := range metadata {
+			meta[k] = v
 		}
+		return meta, nil
+	}
 
-		numFiles++
+	// upload the blob, its metadata and its tags
+	resp, err := azp.writeStream(ctx, identity, uploadData, options, commitMetadata)
+	if err != nil {
+		return resp, sizeLimitError(err)
 	}
+	resp.HashValue = accepted.HashValue
+	resp.Size = accepted.Size
+	resp.MimeType = accepted.MimeType
+	resp.TimestampAccepted = accepted.TimestampAccepted
 	return resp, nil
 }
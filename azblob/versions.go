@@ -0,0 +1,229 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	copyPollInterval = 500 * time.Millisecond
+)
+
+// Versioner is the interface for the history of a blob. Snapshots are read
+// only copies of a blob taken on request. Versions are taken automatically on
+// every write, but only if versioning is enabled for the storage account.
+type Versioner interface {
+	Snapshot(ctx context.Context, identity string, opts ...Option) (*WriteResponse, error)
+	ListVersions(ctx context.Context, identity string, opts ...Option) ([]*azStorageBlob.BlobItemInternal, error)
+	PromoteVersion(ctx context.Context, identity string, opts ...Option) (*WriteResponse, error)
+}
+
+// blobClient returns the client for the blob, or for the snapshot or version
+// of it selected by WithSnapshot() or WithVersionID()
+func (azp *Storer) blobClient(identity string, options *StorerOptions) (*azStorageBlob.BlobClient, error) {
+	if azp.containerClient == nil {
+		return nil, errors.New("no container client available")
+	}
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return selectBlobVersion(blobClient, options)
+}
+
+// selectBlobVersion applies WithSnapshot() or WithVersionID() to the client
+func selectBlobVersion(blobClient *azStorageBlob.BlobClient, options *StorerOptions) (*azStorageBlob.BlobClient, error) {
+	var err error
+	switch {
+	case options.snapshot != "" && options.versionID != "":
+		return nil, NewStatusError("only one of snapshot and version can be specified", http.StatusBadRequest)
+	case options.snapshot != "":
+		blobClient, err = blobClient.WithSnapshot(options.snapshot)
+	case options.versionID != "":
+		blobClient, err = blobClient.WithVersionID(options.versionID)
+	default:
+	}
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return blobClient, nil
+}
+
+// Snapshot takes a read only snapshot of the blob as it is now. The returned
+// Snapshot identifies it for WithSnapshot().
+//
+// Options:
+//
+//	WithMetadata() - the metadata for the snapshot, otherwise that of the blob
+//	WithLeaseID() - only snapshot if the lease is active
+//	WithEtagMatch() etc. - the usual access conditions
+func (azp *Storer) Snapshot(ctx context.Context, identity string, opts ...Option) (*WriteResponse, error) {
+	logger.Sugar.Debugf("Snapshot %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	blobClient, err := azp.blobClient(identity, &StorerOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r, err := blobClient.CreateSnapshot(ctx, &azStorageBlob.BlobCreateSnapshotOptions{
+		Metadata:                 options.metadata,
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	w := &WriteResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		StatusCode:   r.RawResponse.StatusCode,
+		Status:       r.RawResponse.Status,
+	}
+	if r.Snapshot != nil {
+		w.Snapshot = *r.Snapshot
+	}
+	if r.VersionID != nil {
+		w.VersionID = *r.VersionID
+	}
+	return w, nil
+}
+
+// ListVersions returns the snapshots and versions of the blob, oldest first,
+// followed by the blob itself. Versions are only listed if versioning is
+// enabled for the storage account. WithListTags() and WithListMetadata()
+// include the tags and metadata in the items.
+func (azp *Storer) ListVersions(ctx context.Context, identity string, opts ...Option) ([]*azStorageBlob.BlobItemInternal, error) {
+
+	opts = append(opts[:len(opts):len(opts)], WithListPrefix(identity), WithListDelim(""), withListVersions())
+
+	var items []*azStorageBlob.BlobItemInternal
+	for item, err := range AllBlobs(ctx, azp, opts...) {
+		if err != nil {
+			return nil, err
+		}
+		// the prefix also matches blobs whose names start with identity
+		if item.Name != nil && *item.Name == identity {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// PromoteVersion replaces the content and metadata of the blob with that of
+// one of its snapshots or versions, selected by WithSnapshot() or
+// WithVersionID(). This is how an accidental write is rolled back. The
+// promotion is itself a write, so the replaced content is preserved as a
+// version if versioning is enabled.
+//
+// Options:
+//
+//	WithSnapshot() or WithVersionID() - required, the source
+//	WithTags() - the tags for the blob, otherwise its current tags are kept
+//	WithLeaseID() - required if the blob is leased
+//	WithEtagMatch() etc. - access conditions on the blob being replaced
+func (azp *Storer) PromoteVersion(ctx context.Context, identity string, opts ...Option) (*WriteResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.snapshot == "" && options.versionID == "" {
+		return nil, NewStatusError("a snapshot or version to promote is required", http.StatusBadRequest)
+	}
+	logger.Sugar.Infof("PromoteVersion %s snapshot '%s' version '%s'", identity, options.snapshot, options.versionID)
+
+	source, err := azp.blobClient(identity, options)
+	if err != nil {
+		return nil, err
+	}
+	blobClient, err := azp.blobClient(identity, &StorerOptions{})
+	if err != nil {
+		return nil, err
+	}
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// copy replaces the tags, so keep the current ones unless told otherwise
+	tags := options.tags
+	if tags == nil {
+		tags, err = azp.getTags(ctx, identity, &StorerOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := blobClient.StartCopyFromURL(ctx, source.URL(), &azStorageBlob.BlobStartCopyOptions{
+		TagsMap:                  tags,
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	w := &WriteResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		StatusCode:   r.RawResponse.StatusCode,
+		Status:       r.RawResponse.Status,
+	}
+	if r.VersionID != nil {
+		w.VersionID = *r.VersionID
+	}
+	if r.CopyStatus != nil && *r.CopyStatus == azStorageBlob.CopyStatusTypeSuccess {
+		return w, nil
+	}
+	if err = waitForCopy(ctx, blobClient, r.CopyID); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// waitForCopy polls the destination of a copy until the copy is no longer
+// pending. Copies within a storage account normally complete immediately.
+func waitForCopy(ctx context.Context, blobClient *azStorageBlob.BlobClient, copyID *string) error {
+	for {
+		props, err := blobClient.GetProperties(ctx, nil)
+		if err != nil {
+			return ErrorFromError(err)
+		}
+		if copyID != nil && props.CopyID != nil && *props.CopyID != *copyID {
+			return fmt.Errorf("copy %s was superseded by copy %s", *copyID, *props.CopyID)
+		}
+		status := azStorageBlob.CopyStatusTypeSuccess
+		if props.CopyStatus != nil {
+			status = *props.CopyStatus
+		}
+		switch status {
+		case azStorageBlob.CopyStatusTypeSuccess:
+			return nil
+		case azStorageBlob.CopyStatusTypePending:
+		default:
+			description := ""
+			if props.CopyStatusDescription != nil {
+				description = *props.CopyStatusDescription
+			}
+			return fmt.Errorf("copy %s: %s", status, description)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(copyPollInterval):
+		}
+	}
+}
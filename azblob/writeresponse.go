@@ -1,7 +1,7 @@
 package azblob
 
 import (
-	"errors"
+	"net/url"
 	"time"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -23,30 +23,64 @@ type WriteResponse struct {
 	StatusCode   int // For If- header fails, err can be nil and code can be 304
 	Status       string
 	XMsErrorCode string // will be "ConditioNotMet" for If- header predicate fails, even when err is nil
+
+	// Set if versioning is enabled for the storage account, or by Snapshot
+	VersionID string
+	Snapshot  string
+
+	// Set only by Copy and Move
+	CopyID string
+
+	// Set only by Append
+	AppendOffset        *int64 // the offset at which the block was appended
+	CommittedBlockCount *int32
+}
+
+// StreamFileResponse is the result of writing one file part of a multipart
+// request
+type StreamFileResponse struct {
+	Identity string // the blob written, as named by the StreamNamer
+	FormName string
+	FileName string
+	*WriteResponse
+}
+
+// WriteStreamResponse is the result of WriteStreamFiles
+type WriteStreamResponse struct {
+	// The file parts written, in request order
+	Files []*StreamFileResponse
+	// The non file form fields of the request
+	Fields url.Values
+}
+
+// ConditionNotMet returns true if an If- header predicate (eg ETag) was not
+// met for the write.
+func (w *WriteResponse) ConditionNotMet() bool {
+	return w.XMsErrorCode == string(azStorageBlob.StorageErrorCodeConditionNotMet)
 }
 
 // normaliseWriteResponseErr propagates appropriate err details to the response
 // this makes it easier to do consistent checking of responses when using ETags
 // and other conditional header features.
 //
-// Does nothing unless err can be handled As(azure-sdk.StorageError)
+// Does nothing unless err carries a storage error code, either because it can
+// be handled As(azure-sdk.StorageError) or it is an *Error from one of the non
+// azure stores.
 func normaliseWriteResponseErr(err error, wr *WriteResponse) {
 	if err == nil {
 		return
 	}
 
-	var terr *azStorageBlob.StorageError
-	if !errors.As(err, &terr) {
+	code := ErrorFromError(err).StorageErrorCode()
+	if code == "" {
 		return
 	}
-	if terr.ErrorCode != "" {
-		wr.XMsErrorCode = string(terr.ErrorCode)
-		switch terr.ErrorCode {
-		case azStorageBlob.StorageErrorCodeConditionNotMet:
-			wr.Status = "304 " + string(terr.ErrorCode)
-			wr.StatusCode = 304
-		default:
-		}
+	wr.XMsErrorCode = code
+	switch azStorageBlob.StorageErrorCode(code) {
+	case azStorageBlob.StorageErrorCodeConditionNotMet:
+		wr.Status = "304 " + code
+		wr.StatusCode = 304
+	default:
 	}
 }
 
@@ -56,11 +90,15 @@ func uploadStreamWriteResponse(r azStorageBlob.BlockBlobCommitBlockListResponse)
 	}
 	w.Status = r.RawResponse.Status
 	w.StatusCode = r.RawResponse.StatusCode
+	w.LastModified = r.LastModified
 	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
 	if ok && len(value) > 0 {
 		w.XMsErrorCode = value[0]
 	}
 
+	if r.VersionID != nil {
+		w.VersionID = *r.VersionID
+	}
 	return &w
 }
 
@@ -76,5 +114,8 @@ func uploadWriteResponse(r azStorageBlob.BlockBlobUploadResponse) *WriteResponse
 		w.XMsErrorCode = value[0]
 	}
 
+	if r.VersionID != nil {
+		w.VersionID = *r.VersionID
+	}
 	return &w
 }